@@ -0,0 +1,53 @@
+package sia
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dchest/blake2b"
+)
+
+//BlockHeaderSize is the length of a marshalled sia block header
+const BlockHeaderSize = 80
+
+//BlockHeader is the part of a sia block that is hashed while mining
+type BlockHeader struct {
+	ParentID   [HashSize]byte
+	Nonce      [8]byte
+	Timestamp  uint64
+	MerkleRoot [HashSize]byte
+}
+
+//Marshal returns the sia encoding of the header as it is hashed and sent to siad or the gpu
+func (bh *BlockHeader) Marshal() (b []byte) {
+	b = make([]byte, BlockHeaderSize)
+	copy(b[0:32], bh.ParentID[:])
+	copy(b[32:40], bh.Nonce[:])
+	binary.LittleEndian.PutUint64(b[40:48], bh.Timestamp)
+	copy(b[48:80], bh.MerkleRoot[:])
+	return
+}
+
+//Unmarshal decodes a sia encoded header, an error is returned if b does not have the right length
+func (bh *BlockHeader) Unmarshal(b []byte) (err error) {
+	if len(b) != BlockHeaderSize {
+		return fmt.Errorf("Invalid header length %d, a header is %d bytes", len(b), BlockHeaderSize)
+	}
+	copy(bh.ParentID[:], b[0:32])
+	copy(bh.Nonce[:], b[32:40])
+	bh.Timestamp = binary.LittleEndian.Uint64(b[40:48])
+	copy(bh.MerkleRoot[:], b[48:80])
+	return
+}
+
+//ID returns the hash of the header, this is also the id of the block
+func (bh *BlockHeader) ID() [HashSize]byte {
+	return blake2b.Sum256(bh.Marshal())
+}
+
+//MeetsTarget checks if the id of the header is not higher than the target
+func (bh *BlockHeader) MeetsTarget(t Target) bool {
+	id := bh.ID()
+	return bytes.Compare(id[:], t[:]) <= 0
+}
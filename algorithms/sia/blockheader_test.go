@@ -0,0 +1,38 @@
+package sia
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBlockHeader(t *testing.T) {
+	for _, provenSolution := range provenSolutions {
+		var bh BlockHeader
+		if err := bh.Unmarshal(provenSolution.submittedHeader); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(bh.Marshal(), provenSolution.submittedHeader) {
+			t.Error("Marshal does not return the unmarshalled header")
+		}
+		id := bh.ID()
+		if hex.EncodeToString(id[:]) != provenSolution.hash {
+			t.Error(hex.EncodeToString(id[:]), "returned instead of", provenSolution.hash)
+		}
+
+		var target Target
+		copy(target[:], id[:])
+		if !bh.MeetsTarget(target) {
+			t.Error("The header should meet a target equal to its id")
+		}
+		target[31]--
+		if bh.MeetsTarget(target) {
+			t.Error("The header should not meet a target lower than its id")
+		}
+	}
+
+	var bh BlockHeader
+	if err := bh.Unmarshal(make([]byte, 79)); err == nil {
+		t.Error("Expected an error on a header that is too short")
+	}
+}
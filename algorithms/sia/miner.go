@@ -1,14 +1,26 @@
 package sia
 
 import (
-	"log"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/robvanmieghem/go-opencl/cl"
 	"github.com/robvanmieghem/gominer/clients"
+	"github.com/robvanmieghem/gominer/clients/stratum"
+	"github.com/robvanmieghem/gominer/logging"
 	"github.com/robvanmieghem/gominer/mining"
+	"github.com/robvanmieghem/gominer/sharelog"
 )
 
+var minerLog = logging.New("sia.miner")
+
 //miningWork is sent to the mining routines and defines what ranges should be searched for a matching nonce
 type miningWork struct {
 	Header []byte
@@ -25,6 +37,32 @@ type Miner struct {
 	Intensity      int
 	GlobalItemSize int
 	Client         clients.Client
+	//DeviceSettings overrides the settings above for specific devices, by the key in ClDevices
+	DeviceSettings map[int]DeviceSettings
+	//Stats collects the outcome of the submitted shares if it is set
+	Stats *mining.Stats
+	//ShareLog records every solution and the answer of the pool if it is set
+	ShareLog *sharelog.Log
+
+	bestShare *bestShare
+	//workSize is the size of the nonce ranges handed to the devices, the largest GlobalItemSize of all devices
+	workSize int
+	//miners are the miners of the devices by their key in ClDevices
+	miners map[int]*singleDeviceMiner
+	//stop is closed to stop mining, running tracks the device miners and the solutions being submitted
+	stop     chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup
+}
+
+//DeviceSettings overrides the settings of the Miner for a single device
+type DeviceSettings struct {
+	//Intensity defines the GlobalItemSize of the device, the Intensity of the Miner is used if it is 0
+	Intensity int
+	//LocalItemSize is the work group size, the maximum the kernel supports is used if it is 0
+	LocalItemSize int
+	//KernelOptions are passed to the OpenCL compiler when building the kernel
+	KernelOptions string
 }
 
 //singleDeviceMiner actually mines on 1 opencl device
@@ -37,25 +75,188 @@ type singleDeviceMiner struct {
 	Intensity      int
 	GlobalItemSize int
 	Client         clients.HeaderReporter
+	//LocalItemSize is the work group size, the maximum the kernel supports is used if it is 0
+	LocalItemSize int
+	KernelOptions string
+	//WorkSize is the size of the nonce range of a miningWork, it is a multiple of the GlobalItemSize
+	WorkSize int
+	Stats    *mining.Stats
+	ShareLog *sharelog.Log
+
+	bestShare *bestShare
+	control   deviceControl
+	stop      chan struct{}
+	running   *sync.WaitGroup
+}
+
+//deviceControl holds the settings of a singleDeviceMiner that can be changed while it mines
+type deviceControl struct {
+	mutex     sync.Mutex // protects following
+	paused    bool
+	intensity int
+	//dutyCycle is the percentage of the time the device mines, it idles the rest of the time to cool down
+	dutyCycle int
+	//localItemSize is the work group size the kernel runs with, 0 until the device is initialized
+	localItemSize int
+	//changed is closed when the settings change
+	changed chan struct{}
+}
+
+//update changes the settings using f and wakes up the device miner if it is paused
+func (c *deviceControl) update(f func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	f()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+//bestShare keeps track of the highest difficulty share found during this session
+type bestShare struct {
+	mutex      sync.Mutex
+	difficulty float64
+}
+
+//update records the difficulty of a share and returns the best difficulty so far
+func (b *bestShare) update(difficulty float64) (best float64) {
+	if b == nil {
+		return difficulty
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if difficulty > b.difficulty {
+		b.difficulty = difficulty
+	}
+	return b.difficulty
 }
 
 //Mine spawns a seperate miner for each device defined in the CLDevices and feeds it with work
 func (m *Miner) Mine() {
 
 	m.miningWorkChannel = make(chan *miningWork, len(m.ClDevices))
-	go m.createWork()
+	m.bestShare = &bestShare{}
+	m.stop = make(chan struct{})
+	m.miners = make(map[int]*singleDeviceMiner, len(m.ClDevices))
+
+	//The devices can have different intensities, the nonce ranges handed out should cover the largest one
+	sdms := make([]*singleDeviceMiner, 0, len(m.ClDevices))
+	m.workSize = m.GlobalItemSize
 	for minerID, device := range m.ClDevices {
+		settings := m.DeviceSettings[minerID]
 		sdm := &singleDeviceMiner{
 			ClDevice:          device,
 			MinerID:           minerID,
 			HashRateReports:   m.HashRateReports,
 			miningWorkChannel: m.miningWorkChannel,
+			Intensity:         m.Intensity,
 			GlobalItemSize:    m.GlobalItemSize,
 			Client:            m.Client,
+			LocalItemSize:     settings.LocalItemSize,
+			KernelOptions:     settings.KernelOptions,
+			Stats:             m.Stats,
+			ShareLog:          m.ShareLog,
+			bestShare:         m.bestShare,
+			stop:              m.stop,
+			running:           &m.running,
 		}
+		if settings.Intensity > 0 {
+			sdm.Intensity = settings.Intensity
+			sdm.GlobalItemSize = 1 << uint(settings.Intensity)
+		}
+		if sdm.GlobalItemSize > m.workSize {
+			m.workSize = sdm.GlobalItemSize
+		}
+		sdm.control.intensity = sdm.Intensity
+		sdm.control.dutyCycle = 100
+		sdm.control.changed = make(chan struct{})
+		m.Stats.ReportState(minerID, sdm.Intensity, false)
+		m.miners[minerID] = sdm
+		sdms = append(sdms, sdm)
+	}
+	go m.createWork()
+	for _, sdm := range sdms {
+		sdm.WorkSize = m.workSize
+		m.running.Add(1)
 		go sdm.mine()
+	}
+}
 
+//device returns the miner of a device by its key in ClDevices
+func (m *Miner) device(minerID int) (sdm *singleDeviceMiner, err error) {
+	sdm, found := m.miners[minerID]
+	if !found {
+		err = fmt.Errorf("There is no device %d", minerID)
 	}
+	return
+}
+
+//Pause stops a device from taking new work, the work it is busy with is finished first
+func (m *Miner) Pause(minerID int) (err error) {
+	sdm, err := m.device(minerID)
+	if err != nil {
+		return
+	}
+	sdm.control.update(func() {
+		sdm.control.paused = true
+		m.Stats.ReportState(minerID, sdm.control.intensity, true)
+	})
+	return
+}
+
+//Resume lets a paused device take work again
+func (m *Miner) Resume(minerID int) (err error) {
+	sdm, err := m.device(minerID)
+	if err != nil {
+		return
+	}
+	sdm.control.update(func() {
+		sdm.control.paused = false
+		m.Stats.ReportState(minerID, sdm.control.intensity, false)
+	})
+	return
+}
+
+//SetIntensity changes the intensity of a device from the next work it takes on.
+// The nonce ranges handed out do not change, so the intensity can not be higher than the highest one the miner started with.
+func (m *Miner) SetIntensity(minerID, intensity int) (err error) {
+	sdm, err := m.device(minerID)
+	if err != nil {
+		return
+	}
+	if intensity < 1 || 1<<uint(intensity) > m.workSize {
+		return fmt.Errorf("The intensity should be between 1 and %d", bits.Len(uint(m.workSize))-1)
+	}
+	sdm.control.update(func() {
+		if localItemSize := sdm.control.localItemSize; localItemSize > 1<<uint(intensity) {
+			err = fmt.Errorf("The intensity is too low for the local item size %d", localItemSize)
+			return
+		}
+		sdm.control.intensity = intensity
+		m.Stats.ReportState(minerID, intensity, sdm.control.paused)
+	})
+	return
+}
+
+//SetDutyCycle throttles a device by letting it idle after every work, it mines percent of the time
+func (m *Miner) SetDutyCycle(minerID, percent int) (err error) {
+	sdm, err := m.device(minerID)
+	if err != nil {
+		return
+	}
+	if percent < 1 || percent > 100 {
+		return errors.New("The duty cycle should be between 1 and 100 percent")
+	}
+	sdm.control.update(func() {
+		sdm.control.dutyCycle = percent
+		m.Stats.ReportDutyCycle(minerID, percent)
+	})
+	return
+}
+
+//Stop lets the devices finish the work they are busy with and waits until the solutions are submitted
+func (m *Miner) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	m.running.Wait()
 }
 
 const maxUint32 = int64(^uint32(0))
@@ -73,22 +274,27 @@ func (m *Miner) createWork() {
 	m.Client.Start()
 
 	for {
-		target, header, deprecationChannel, job, err := m.Client.GetHeaderForWork()
+		target, rawHeader, deprecationChannel, job, err := m.Client.GetHeaderForWork()
 
+		var bh BlockHeader
+		if err == nil {
+			err = bh.Unmarshal(rawHeader)
+		}
 		if err != nil {
-			log.Println("ERROR fetching work -", err)
+			minerLog.Error("Unable to fetch work -", err)
 			time.Sleep(1000 * time.Millisecond)
 			continue
 		}
 
-		//copy target to header
+		//The kernel expects the first 8 bytes of the target in the nonce field, in reverse order
 		for i := 0; i < 8; i++ {
-			header[i+32] = target[7-i]
+			bh.Nonce[i] = target[7-i]
 		}
+		header := bh.Marshal()
 		//Fill the workchannel with work
 		// Only generate nonces for a 32 bit space (since gpu's are mostly 32 bit)
 	nonce32loop:
-		for i := int64(0); i*int64(m.GlobalItemSize) < (maxUint32 - int64(m.GlobalItemSize)); i++ {
+		for i := int64(0); i*int64(m.workSize) < (maxUint32 - int64(m.workSize)); i++ {
 			//Do not continue mining the 32 bit nonce space if the current job is deprecated
 			select {
 			case <-deprecationChannel:
@@ -96,40 +302,42 @@ func (m *Miner) createWork() {
 			default:
 			}
 
-			m.miningWorkChannel <- &miningWork{header, int(i) * m.GlobalItemSize, job}
+			m.miningWorkChannel <- &miningWork{header, int(i) * m.workSize, job}
 		}
 	}
 }
 
 func (miner *singleDeviceMiner) mine() {
-	log.Println(miner.MinerID, "- Initializing", miner.ClDevice.Type(), "-", miner.ClDevice.Name())
+	defer miner.running.Done()
+	logger := miner.logger()
+	logger.Info("Initializing", miner.ClDevice.Type(), "-", miner.ClDevice.Name())
 
 	context, err := cl.CreateContext([]*cl.Device{miner.ClDevice})
 	if err != nil {
-		log.Fatalln(miner.MinerID, "-", err)
+		logger.Fatal(err)
 	}
 	defer context.Release()
 
 	commandQueue, err := context.CreateCommandQueue(miner.ClDevice, 0)
 	if err != nil {
-		log.Fatalln(miner.MinerID, "-", err)
+		logger.Fatal(err)
 	}
 	defer commandQueue.Release()
 
 	program, err := context.CreateProgramWithSource([]string{kernelSource})
 	if err != nil {
-		log.Fatalln(miner.MinerID, "-", err)
+		logger.Fatal(err)
 	}
 	defer program.Release()
 
-	err = program.BuildProgram([]*cl.Device{miner.ClDevice}, "")
+	err = program.BuildProgram([]*cl.Device{miner.ClDevice}, miner.KernelOptions)
 	if err != nil {
-		log.Fatalln(miner.MinerID, "-", err)
+		logger.Fatal(err)
 	}
 
 	kernel, err := program.CreateKernel("nonceGrind")
 	if err != nil {
-		log.Fatalln(miner.MinerID, "-", err)
+		logger.Fatal(err)
 	}
 	defer kernel.Release()
 
@@ -143,69 +351,243 @@ func (miner *singleDeviceMiner) mine() {
 
 	localItemSize, err := kernel.WorkGroupSize(miner.ClDevice)
 	if err != nil {
-		log.Fatalln(miner.MinerID, "- WorkGroupSize failed -", err)
+		logger.Fatal("WorkGroupSize failed -", err)
+	}
+	if miner.LocalItemSize > 0 {
+		if miner.LocalItemSize > localItemSize {
+			logger.Warn("Local item size", miner.LocalItemSize, "is larger than the maximum of", localItemSize)
+		} else {
+			localItemSize = miner.LocalItemSize
+		}
 	}
 
-	log.Println(miner.MinerID, "- Global item size:", miner.GlobalItemSize, "(Intensity", miner.Intensity, ")", "- Local item size:", localItemSize)
+	logger.Info("Global item size:", miner.GlobalItemSize, "(Intensity", miner.Intensity, ")", "- Local item size:", localItemSize)
+	miner.control.mutex.Lock()
+	miner.control.localItemSize = localItemSize
+	miner.control.mutex.Unlock()
 
-	log.Println(miner.MinerID, "- Initialized ", miner.ClDevice.Type(), "-", miner.ClDevice.Name())
+	logger.Info("Initialized", miner.ClDevice.Type(), "-", miner.ClDevice.Name())
 
 	nonceOut := make([]byte, 8, 8)
 	if _, err = commandQueue.EnqueueWriteBufferByte(nonceOutObj, true, 0, nonceOut, nil); err != nil {
-		log.Fatalln(miner.MinerID, "-", err)
+		logger.Fatal(err)
 	}
 	for {
+		intensity, running := miner.waitWhilePaused()
+		if !running {
+			logger.Info("Halting")
+			break
+		}
+		if intensity != miner.Intensity {
+			miner.Intensity = intensity
+			miner.GlobalItemSize = 1 << uint(intensity)
+			logger.Info("Global item size:", miner.GlobalItemSize, "(Intensity", miner.Intensity, ")", "- Local item size:", localItemSize)
+		}
 		start := time.Now()
+		kernelRuns := 0
 		var work *miningWork
 		continueMining := true
 		select {
 		case work, continueMining = <-miner.miningWorkChannel:
 		default:
-			log.Println(miner.MinerID, "-", "No work ready")
-			work, continueMining = <-miner.miningWorkChannel
-			log.Println(miner.MinerID, "-", "Continuing")
+			logger.Debug("No work ready")
+			select {
+			case work, continueMining = <-miner.miningWorkChannel:
+			case <-miner.stop:
+				continueMining = false
+			}
+			logger.Debug("Continuing")
 		}
 		if !continueMining {
-			log.Println("Halting miner ", miner.MinerID)
+			logger.Info("Halting")
 			break
 		}
 		//Copy input to kernel args
 		if _, err = commandQueue.EnqueueWriteBufferByte(blockHeaderObj, true, 0, work.Header, nil); err != nil {
-			log.Fatalln(miner.MinerID, "-", err)
-		}
-
-		//Run the kernel
-		if _, err = commandQueue.EnqueueNDRangeKernel(kernel, []int{work.Offset}, []int{miner.GlobalItemSize}, []int{localItemSize}, nil); err != nil {
-			log.Fatalln(miner.MinerID, "-", err)
+			logger.Fatal(err)
 		}
-		//Get output
-		if _, err = commandQueue.EnqueueReadBufferByte(nonceOutObj, true, 0, nonceOut, nil); err != nil {
-			log.Fatalln(miner.MinerID, "-", err)
-		}
-		//Check if match found
-		if nonceOut[0] != 0 || nonceOut[1] != 0 || nonceOut[2] != 0 || nonceOut[3] != 0 || nonceOut[4] != 0 || nonceOut[5] != 0 || nonceOut[6] != 0 || nonceOut[7] != 0 {
-			log.Println(miner.MinerID, "-", "Yay, solution found!")
 
-			// Copy nonce to a new header.
-			header := append([]byte(nil), work.Header...)
-			for i := 0; i < 8; i++ {
-				header[i+32] = nonceOut[i]
+		//The nonce range of the work can be larger than the global item size of this device
+		for offset := work.Offset; offset < work.Offset+miner.WorkSize; offset += miner.GlobalItemSize {
+			//Run the kernel
+			kernelRuns++
+			if _, err = commandQueue.EnqueueNDRangeKernel(kernel, []int{offset}, []int{miner.GlobalItemSize}, []int{localItemSize}, nil); err != nil {
+				logger.Fatal(err)
+			}
+			//Get output
+			if _, err = commandQueue.EnqueueReadBufferByte(nonceOutObj, true, 0, nonceOut, nil); err != nil {
+				logger.Fatal(err)
 			}
-			go func() {
-				if e := miner.Client.SubmitHeader(header, work.Job); e != nil {
-					log.Println(miner.MinerID, "- Error submitting solution -", e)
+			//Check if match found
+			if nonceOut[0] != 0 || nonceOut[1] != 0 || nonceOut[2] != 0 || nonceOut[3] != 0 || nonceOut[4] != 0 || nonceOut[5] != 0 || nonceOut[6] != 0 || nonceOut[7] != 0 {
+				// Copy nonce to a new header.
+				var header BlockHeader
+				header.Unmarshal(work.Header)
+				targetPrefix := workTargetPrefix(header)
+				copy(header.Nonce[:], nonceOut)
+
+				id := header.ID()
+				pool := clients.JobPool(miner.Client, work.Job)
+				share := miner.share(header, work.Job, pool)
+				if bytes.Compare(id[:8], targetPrefix[:]) > 0 {
+					logger.Error("Hardware error, the solution does not meet the target")
+					miner.Stats.ReportShare(miner.MinerID, pool, 0, mining.ShareHardwareError)
+					share.Result = mining.ShareHardwareError.String()
+					miner.recordShare(share)
+				} else {
+					difficulty := Target(id).Difficulty()
+					best := miner.bestShare.update(difficulty)
+					logger.Info("Yay, solution found! - difficulty", formatDifficulty(difficulty), "- best share", formatDifficulty(best))
+					miner.running.Add(1)
+					go func() {
+						defer miner.running.Done()
+						start := time.Now()
+						e := miner.Client.SubmitHeader(header.Marshal(), work.Job)
+						share.Latency = time.Since(start)
+						result := shareResult(e)
+						switch {
+						case result == mining.ShareStale:
+							logger.Warn("Stale solution -", e)
+						case e != nil:
+							logger.Error("Unable to submit the solution -", e)
+						}
+						miner.Stats.ReportShare(miner.MinerID, pool, difficulty, result)
+						share.Result = result.String()
+						if e != nil {
+							share.Response = e.Error()
+						}
+						miner.recordShare(share)
+					}()
 				}
-			}()
 
-			//Clear the output since it is dirty now
-			nonceOut = make([]byte, 8, 8)
-			if _, err = commandQueue.EnqueueWriteBufferByte(nonceOutObj, true, 0, nonceOut, nil); err != nil {
-				log.Fatalln(miner.MinerID, "-", err)
+				//Clear the output since it is dirty now
+				nonceOut = make([]byte, 8, 8)
+				if _, err = commandQueue.EnqueueWriteBufferByte(nonceOutObj, true, 0, nonceOut, nil); err != nil {
+					logger.Fatal(err)
+				}
+			}
+		}
+
+		busy := time.Since(start)
+		miner.idle(busy)
+		//The hashrate includes the idle time of a throttled device
+		duration := time.Since(start)
+		hashRate := float64(miner.WorkSize) / (duration.Seconds() * 1000000)
+		miner.HashRateReports <- &mining.HashRateReport{MinerID: miner.MinerID, HashRate: hashRate, KernelDuration: busy / time.Duration(kernelRuns)}
+	}
+
+}
+
+//logger returns the logger of the device
+func (miner *singleDeviceMiner) logger() *logging.Logger {
+	return logging.New(fmt.Sprint("device ", miner.MinerID))
+}
+
+//share describes a solved header for the share log, the outcome of submitting it is filled in by the caller
+func (miner *singleDeviceMiner) share(header BlockHeader, job interface{}, pool string) (share sharelog.Share) {
+	details := clients.DescribeJob(miner.Client, job)
+	id := header.ID()
+	nTime := make([]byte, 8)
+	binary.LittleEndian.PutUint64(nTime, header.Timestamp)
+	return sharelog.Share{
+		Time:        time.Now(),
+		Device:      miner.MinerID,
+		Pool:        pool,
+		JobID:       details.JobID,
+		ExtraNonce2: details.ExtraNonce2,
+		NTime:       hex.EncodeToString(nTime),
+		Nonce:       hex.EncodeToString(header.Nonce[:]),
+		Header:      hex.EncodeToString(header.Marshal()),
+		Hash:        hex.EncodeToString(id[:]),
+		Difficulty:  Target(id).Difficulty(),
+	}
+}
+
+//recordShare writes a share to the share log
+func (miner *singleDeviceMiner) recordShare(share sharelog.Share) {
+	if err := miner.ShareLog.Record(share); err != nil {
+		miner.logger().Error("Unable to record the share -", err)
+	}
+}
+
+//waitWhilePaused blocks as long as the device is paused,
+// it returns the intensity to mine with and false if the miner is stopped
+func (miner *singleDeviceMiner) waitWhilePaused() (intensity int, running bool) {
+	paused := false
+	for {
+		select {
+		case <-miner.stop:
+			return
+		default:
+		}
+		miner.control.mutex.Lock()
+		intensity = miner.control.intensity
+		changed := miner.control.changed
+		if !miner.control.paused {
+			miner.control.mutex.Unlock()
+			if paused {
+				miner.logger().Info("Resumed")
 			}
+			return intensity, true
+		}
+		miner.control.mutex.Unlock()
+		if !paused {
+			paused = true
+			miner.logger().Info("Paused")
+			miner.HashRateReports <- &mining.HashRateReport{MinerID: miner.MinerID}
 		}
+		select {
+		case <-changed:
+		case <-miner.stop:
+			return
+		}
+	}
+}
+
+//idle waits long enough after mining for busy to match the duty cycle of the device,
+// it returns early if the settings change or the miner is stopped
+func (miner *singleDeviceMiner) idle(busy time.Duration) {
+	miner.control.mutex.Lock()
+	dutyCycle := miner.control.dutyCycle
+	changed := miner.control.changed
+	miner.control.mutex.Unlock()
+	if dutyCycle <= 0 || dutyCycle >= 100 {
+		return
+	}
+	idle := busy * time.Duration(100-dutyCycle) / time.Duration(dutyCycle)
+	select {
+	case <-time.After(idle):
+	case <-changed:
+	case <-miner.stop:
+	}
+}
 
-		hashRate := float64(miner.GlobalItemSize) / (time.Since(start).Seconds() * 1000000)
-		miner.HashRateReports <- &mining.HashRateReport{MinerID: miner.MinerID, HashRate: hashRate}
+//workTargetPrefix returns the first 8 bytes of the target the kernel compares the hashes with,
+// createWork puts them in the nonce field of the header in reverse order
+func workTargetPrefix(header BlockHeader) (prefix [8]byte) {
+	for i := 0; i < 8; i++ {
+		prefix[i] = header.Nonce[7-i]
 	}
+	return
+}
+
+//shareResult classifies the outcome of submitting a share
+func shareResult(err error) mining.ShareResult {
+	if err == nil {
+		return mining.ShareAccepted
+	}
+	var stratumErr *stratum.Error
+	if errors.As(err, &stratumErr) && stratumErr.Code == stratum.ErrJobNotFound.Code {
+		return mining.ShareStale
+	}
+	message := strings.ToLower(err.Error())
+	if strings.Contains(message, "stale") || strings.Contains(message, "job not found") {
+		return mining.ShareStale
+	}
+	return mining.ShareRejected
+}
 
+//formatDifficulty formats a difficulty in a short human readable way
+func formatDifficulty(difficulty float64) string {
+	return fmt.Sprintf("%.4g", difficulty)
 }
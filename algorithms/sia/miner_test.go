@@ -2,9 +2,12 @@ package sia
 
 import (
 	"bytes"
+	"encoding/hex"
 	"log"
 	"math"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/robvanmieghem/go-opencl/cl"
 	"github.com/robvanmieghem/gominer/mining"
@@ -62,18 +65,89 @@ func TestMine(t *testing.T) {
 	close(workChannel)
 	var hashRateReportsChannel = make(chan *mining.HashRateReport, len(provenSolutions)+1)
 	validator := newSubmittedHeaderValidator(len(provenSolutions))
+	running := &sync.WaitGroup{}
+	running.Add(1)
 	miner := &singleDeviceMiner{
 		ClDevice:          clDevice,
 		MinerID:           0,
 		HashRateReports:   hashRateReportsChannel,
 		GlobalItemSize:    int(math.Exp2(float64(28))),
+		WorkSize:          int(math.Exp2(float64(28))),
 		miningWorkChannel: workChannel,
 		Client:            validator,
+		running:           running,
 	}
 	miner.mine()
+	//Wait for the solutions to be submitted
+	running.Wait()
 	validator.validate(t)
 }
 
+func TestMinerControl(t *testing.T) {
+	stats := mining.NewStats()
+	m := &Miner{Stats: stats, workSize: 1 << 26, miners: map[int]*singleDeviceMiner{
+		2: {MinerID: 2, Intensity: 26, control: deviceControl{intensity: 26, localItemSize: 256, changed: make(chan struct{})}},
+	}}
+	sdm := m.miners[2]
+	changed := sdm.control.changed
+	if err := m.Pause(2); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Error("The device miner is not woken up")
+	}
+	if devices := stats.Devices(); len(devices) != 1 || !devices[0].Paused {
+		t.Error("Pause not reported:", devices)
+	}
+	if err := m.SetIntensity(2, 24); err != nil || sdm.control.intensity != 24 {
+		t.Error("Intensity not changed:", err, sdm.control.intensity)
+	}
+	for _, intensity := range []int{0, 7, 27} {
+		if err := m.SetIntensity(2, intensity); err == nil {
+			t.Error("Expected an error for intensity", intensity)
+		}
+	}
+	if err := m.Resume(2); err != nil || sdm.control.paused {
+		t.Error("Not resumed:", err)
+	}
+	if devices := stats.Devices(); devices[0].Paused || devices[0].Intensity != 24 {
+		t.Error("Unexpected state:", devices)
+	}
+	if err := m.SetDutyCycle(2, 50); err != nil || sdm.control.dutyCycle != 50 || stats.Devices()[0].DutyCycle != 50 {
+		t.Error("Duty cycle not changed:", err, sdm.control.dutyCycle)
+	}
+	for _, percent := range []int{0, 101} {
+		if err := m.SetDutyCycle(2, percent); err == nil {
+			t.Error("Expected an error for duty cycle", percent)
+		}
+	}
+	//A device mining half of the time idles as long as it was busy
+	start := time.Now()
+	sdm.idle(20 * time.Millisecond)
+	if idle := time.Since(start); idle < 20*time.Millisecond {
+		t.Error("The device idled", idle, "instead of 20ms")
+	}
+	if err := m.Pause(1); err == nil {
+		t.Error("Expected an error for an unknown device")
+	}
+}
+
+func TestShare(t *testing.T) {
+	var header BlockHeader
+	header.Timestamp = 100
+	header.Nonce[0] = 1
+	sdm := &singleDeviceMiner{MinerID: 3, Client: &submittedHeaderValidator{}}
+	share := sdm.share(header, "parent", "pool")
+	id := header.ID()
+	if share.Device != 3 || share.Pool != "pool" || share.JobID != "parent" || share.ExtraNonce2 != "" || share.NTime != "6400000000000000" ||
+		share.Nonce != "0100000000000000" || share.Header != hex.EncodeToString(header.Marshal()) || share.Hash != hex.EncodeToString(id[:]) ||
+		share.Difficulty != Target(id).Difficulty() || share.Time.IsZero() {
+		t.Error("Unexpected share:", share)
+	}
+}
+
 func newSubmittedHeaderValidator(capacity int) (v *submittedHeaderValidator) {
 	v = &submittedHeaderValidator{}
 	v.submittedHeaders = make(chan []byte, capacity)
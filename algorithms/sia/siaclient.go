@@ -2,30 +2,185 @@ package sia
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/robvanmieghem/gominer/clients"
+	"github.com/robvanmieghem/gominer/logging"
 )
 
-// NewClient creates a new SiadClient given a '[stratum+tcp://]host:port' connectionstring
+var siadLog = logging.New("siad")
+
+//DefaultTipPollInterval is the default time between checks of the chain tip of siad
+const DefaultTipPollInterval = 2 * time.Second
+
+// NewClient creates a new SiadClient given a '[stratum+tcp://|http://|https://]host:port' connectionstring
+// A '(http|https)+getwork://' connectionstring creates a GetworkClient, nil is returned if it is invalid.
 func NewClient(connectionstring, pooluser string) (sc clients.Client) {
 	if strings.HasPrefix(connectionstring, "stratum+tcp://") {
 		sc = &StratumClient{connectionstring: strings.TrimPrefix(connectionstring, "stratum+tcp://"), User: pooluser}
+	} else if strings.HasPrefix(connectionstring, "http+getwork://") || strings.HasPrefix(connectionstring, "https+getwork://") {
+		gc, err := NewGetworkClient(connectionstring, pooluser)
+		if err != nil {
+			logging.New("getwork").Error("Invalid getwork url -", err)
+			return
+		}
+		sc = gc
 	} else {
-		s := SiadClient{}
-		s.siadurl = "http://" + connectionstring + "/miner/header"
-		sc = &s
+		sc = NewSiadClient(connectionstring)
+	}
+	return
+}
+
+// NewSiadClient creates a new SiadClient given a '[http://|https://]host:port' connectionstring
+func NewSiadClient(connectionstring string) (sc *SiadClient) {
+	if !strings.HasPrefix(connectionstring, "http://") && !strings.HasPrefix(connectionstring, "https://") {
+		connectionstring = "http://" + connectionstring
 	}
+	sc = &SiadClient{HTTPClient: &http.Client{}, TipPollInterval: DefaultTipPollInterval}
+	sc.siadurl = strings.TrimSuffix(connectionstring, "/")
 	return
 }
 
 // SiadClient is a simple client to a siad
 type SiadClient struct {
 	siadurl string
+	//APIPassword is sent to siad using HTTP basic authentication if it is not empty
+	APIPassword string
+	//HTTPClient is used for the requests to siad, replace it to use custom TLS settings
+	HTTPClient *http.Client
+	//TipPollInterval is the time between checks of the chain tip once the client is started
+	TipPollInterval time.Duration
+
+	mutex    sync.Mutex // protects following
+	parentID string
+	clients.BaseClient
+
+	status workStatus
+}
+
+//workStatus keeps track of the work fetched over http to report the PoolStatus
+type workStatus struct {
+	mutex       sync.Mutex // protects following
+	connected   bool
+	job         string
+	jobReceived time.Time
+	difficulty  float64
+	jobs        uint64
+	latency     time.Duration
+}
+
+//update records the outcome of fetching work that was requested at start, job and target are only used if err is nil
+func (ws *workStatus) update(err error, job interface{}, target []byte, start time.Time) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.connected = err == nil
+	if err != nil {
+		return
+	}
+	ws.latency = time.Since(start)
+	if jobID, ok := job.(string); ok && jobID != ws.job {
+		ws.job = jobID
+		ws.jobReceived = time.Now()
+		ws.jobs++
+	}
+	var t Target
+	copy(t[:], target)
+	ws.difficulty = t.Difficulty()
+}
+
+//poolStatus returns the status of the server at url
+func (ws *workStatus) poolStatus(url string) []clients.PoolStatus {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	return []clients.PoolStatus{{URL: url, Active: true, Connected: ws.connected, Job: ws.job, JobReceived: ws.jobReceived, Difficulty: ws.difficulty, Jobs: ws.jobs, Latency: ws.latency}}
+}
+
+//errUnauthorized is returned when siad rejects the API password
+var errUnauthorized = errors.New("Status code 401, siad rejected the API password, supply the correct one using -apipassword, the SIA_API_PASSWORD environment variable or the apipassword file in the sia directory")
+
+//SiaDir returns the sia data directory, this is where siad stores the apipassword file
+func SiaDir() string {
+	if dir := os.Getenv("SIA_DIR"); dir != "" {
+		return dir
+	}
+	switch runtime.GOOS {
+	case "windows":
+		return filepath.Join(os.Getenv("LOCALAPPDATA"), "Sia")
+	case "darwin":
+		return filepath.Join(os.Getenv("HOME"), "Library", "Application Support", "Sia")
+	default:
+		return filepath.Join(os.Getenv("HOME"), ".sia")
+	}
+}
+
+//LoadAPIPassword returns the password to authenticate to siad with
+// If no password is given, the SIA_API_PASSWORD environment variable is used, if that is not set either,
+// the password is read from the apipassword file in the sia directory if it exists.
+func LoadAPIPassword(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+	if password = os.Getenv("SIA_API_PASSWORD"); password != "" {
+		return password, nil
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(SiaDir(), "apipassword"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+//NewHTTPClient creates an http client that trusts the certificate authorities in the PEM encoded caFile
+// besides the system ones, if caFile is empty, the default http client is returned.
+func NewHTTPClient(caFile string) (client *http.Client, err error) {
+	if caFile == "" {
+		client = &http.Client{}
+		return
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return
+	}
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(pem) {
+		err = fmt.Errorf("No valid certificates found in %s", caFile)
+		return
+	}
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs}}}
+	return
+}
+
+//newRequest creates a request to an endpoint of siad
+func (sc *SiadClient) newRequest(method, path string, body io.Reader) (req *http.Request, err error) {
+	req, err = http.NewRequest(method, sc.siadurl+path, body)
+	if err != nil {
+		return
+	}
+	req.Header.Add("User-Agent", "Sia-Agent")
+	if sc.APIPassword != "" {
+		req.SetBasicAuth("", sc.APIPassword)
+	}
+	return
 }
 
 func decodeMessage(resp *http.Response) (msg string, err error) {
@@ -42,32 +197,121 @@ func decodeMessage(resp *http.Response) (msg string, err error) {
 	return
 }
 
-//Start does nothing
-func (sc *SiadClient) Start() {}
+//Start watches the chain tip of siad in the background to abandon the outstanding work when a new block is found
+func (sc *SiadClient) Start() {
+	go sc.watchTip()
+}
 
-//SetDeprecatedJobCall does nothing
-func (sc *SiadClient) SetDeprecatedJobCall(call clients.DeprecatedJobCall) {}
+//SetDeprecatedJobCall sets the function to be called when the previous jobs should be abandoned
+func (sc *SiadClient) SetDeprecatedJobCall(call clients.DeprecatedJobCall) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.BaseClient.SetDeprecatedJobCall(call)
+}
 
-//GetHeaderForWork fetches new work from the SIA daemon
-func (sc *SiadClient) GetHeaderForWork() (target []byte, header []byte, deprecationChannel chan bool, job interface{}, err error) {
-	//the deprecationChannel is not used but return a valid channel anyway
-	deprecationChannel = make(chan bool)
+func (sc *SiadClient) watchTip() {
+	for {
+		currentBlock, err := sc.getCurrentBlock()
+		if err != nil {
+			siadLog.Error("Unable to check the chain tip -", err)
+		} else {
+			sc.updateTip(currentBlock)
+		}
+		time.Sleep(sc.TipPollInterval)
+	}
+}
+
+//getCurrentBlock fetches the id of the current block from the consensus module of siad
+func (sc *SiadClient) getCurrentBlock() (currentBlock string, err error) {
+	req, err := sc.newRequest("GET", "/consensus", nil)
+	if err != nil {
+		return
+	}
+	resp, err := sc.HTTPClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+	case 401:
+		err = errUnauthorized
+		return
+	default:
+		err = fmt.Errorf("Status code %d", resp.StatusCode)
+		return
+	}
+	var consensus struct {
+		CurrentBlock string `json:"currentblock"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&consensus); err != nil {
+		return
+	}
+	currentBlock = consensus.CurrentBlock
+	return
+}
+
+//updateTip deprecates the outstanding work if siad reports a new current block
+func (sc *SiadClient) updateTip(currentBlock string) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if currentBlock == sc.parentID {
+		return
+	}
+	if sc.parentID != "" {
+		siadLog.Info("New block detected, abandoning the work on the previous block")
+	}
+	sc.DeprecateOutstandingJobs()
+	sc.parentID = currentBlock
+	sc.AddJobToDeprecate(currentBlock)
+}
+
+//parentDeprecationChannel returns the channel that is closed when work on top of parentID should be abandoned.
+// Only the current block of siad moves the tip forward, the channel of a header that was fetched
+// just before a new block is already closed.
+func (sc *SiadClient) parentDeprecationChannel(parentID string) (deprecationChannel chan bool, err error) {
+	sc.mutex.Lock()
+	deprecationChannel = sc.GetDeprecationChannel(parentID)
+	sc.mutex.Unlock()
+	if deprecationChannel != nil {
+		return
+	}
+	currentBlock, err := sc.getCurrentBlock()
+	if err != nil {
+		return
+	}
+	sc.updateTip(currentBlock)
 
-	client := &http.Client{}
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if deprecationChannel = sc.GetDeprecationChannel(parentID); deprecationChannel == nil {
+		deprecationChannel = make(chan bool)
+		close(deprecationChannel)
+	}
+	return
+}
 
-	req, err := http.NewRequest("GET", sc.siadurl, nil)
+//GetHeaderForWork fetches new work from the SIA daemon
+// The deprecationChannel is closed when siad reports a new current block, either to the background
+// tip watcher or when a header with a different parent is fetched
+func (sc *SiadClient) GetHeaderForWork() (target []byte, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	start := time.Now()
+	defer func() { sc.status.update(err, job, target, start) }()
+	req, err := sc.newRequest("GET", "/miner/header", nil)
 	if err != nil {
 		return
 	}
 
-	req.Header.Add("User-Agent", "Sia-Agent")
-	resp, err := client.Do(req)
+	resp, err := sc.HTTPClient.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 	switch resp.StatusCode {
 	case 200:
+	case 401:
+		err = errUnauthorized
+		return
 	case 400:
 		msg, errd := decodeMessage(resp)
 		if errd != nil {
@@ -90,28 +334,44 @@ func (sc *SiadClient) GetHeaderForWork() (target []byte, header []byte, deprecat
 		return
 	}
 
+	var bh BlockHeader
+	if err = bh.Unmarshal(buf[32:112]); err != nil {
+		return
+	}
 	target = buf[:32]
 	header = buf[32:112]
 
+	parentID := hex.EncodeToString(bh.ParentID[:])
+	if deprecationChannel, err = sc.parentDeprecationChannel(parentID); err != nil {
+		return
+	}
+	job = parentID
+
 	return
 }
 
+//PoolStatus returns the state of the work fetched from siad
+func (sc *SiadClient) PoolStatus() []clients.PoolStatus {
+	return sc.status.poolStatus(sc.siadurl)
+}
+
 //SubmitHeader reports a solved header to the SIA daemon
 func (sc *SiadClient) SubmitHeader(header []byte, job interface{}) (err error) {
-	req, err := http.NewRequest("POST", sc.siadurl, bytes.NewReader(header))
+	req, err := sc.newRequest("POST", "/miner/header", bytes.NewReader(header))
 	if err != nil {
 		return
 	}
 
-	req.Header.Add("User-Agent", "Sia-Agent")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := sc.HTTPClient.Do(req)
 	if err != nil {
 		return
 	}
+	defer resp.Body.Close()
 	switch resp.StatusCode {
 	case 204:
+	case 401:
+		err = errUnauthorized
+		return
 	default:
 		msg, errd := decodeMessage(resp)
 		if errd != nil {
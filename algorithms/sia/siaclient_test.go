@@ -1 +1,165 @@
 package sia
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSiadClientAuthentication(t *testing.T) {
+	siad := &fakeSiad{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); !ok || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		siad.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	sc := NewSiadClient(server.URL)
+	if _, _, _, _, err := sc.GetHeaderForWork(); err != errUnauthorized {
+		t.Error("Expected an unauthorized error instead of", err)
+	}
+	if err := sc.SubmitHeader(make([]byte, 80), nil); err != errUnauthorized {
+		t.Error("Expected an unauthorized error instead of", err)
+	}
+	sc.APIPassword = "secret"
+	if _, _, _, _, err := sc.GetHeaderForWork(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSiadClientHTTPS(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeSiad{})
+	defer server.Close()
+
+	sc := NewSiadClient(server.URL)
+	if _, _, _, _, err := sc.GetHeaderForWork(); err == nil {
+		t.Error("Expected an error since the certificate is not trusted")
+	}
+
+	dir, err := ioutil.TempDir("", "gominer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err = ioutil.WriteFile(caFile, certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if sc.HTTPClient, err = NewHTTPClient(caFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err = sc.GetHeaderForWork(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadAPIPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gominer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("SIA_DIR", os.Getenv("SIA_DIR"))
+	defer os.Setenv("SIA_API_PASSWORD", os.Getenv("SIA_API_PASSWORD"))
+	os.Setenv("SIA_DIR", dir)
+	os.Setenv("SIA_API_PASSWORD", "")
+
+	if password, err := LoadAPIPassword(""); err != nil || password != "" {
+		t.Error("Expected no password without an apipassword file instead of", password, err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "apipassword"), []byte("fromfile\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if password, _ := LoadAPIPassword(""); password != "fromfile" {
+		t.Error(password, "returned instead of fromfile")
+	}
+	os.Setenv("SIA_API_PASSWORD", "fromenv")
+	if password, _ := LoadAPIPassword(""); password != "fromenv" {
+		t.Error(password, "returned instead of fromenv")
+	}
+	if password, _ := LoadAPIPassword("fromflag"); password != "fromflag" {
+		t.Error(password, "returned instead of fromflag")
+	}
+}
+
+func TestSiadClientTipChange(t *testing.T) {
+	siad := &fakeSiad{}
+	server := httptest.NewServer(siad)
+	defer server.Close()
+
+	sc := NewSiadClient(server.URL)
+	sc.TipPollInterval = 10 * time.Millisecond
+	deprecatedJobCalls := make(chan bool, 10)
+	sc.SetDeprecatedJobCall(func() {
+		deprecatedJobCalls <- true
+	})
+	sc.Start()
+
+	_, _, deprecationChannel, _, err := sc.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, sameParentChannel, _, err := sc.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sameParentChannel != deprecationChannel {
+		t.Error("Work on the same parent should share the deprecationChannel")
+	}
+	//Ignore the calls caused by the initial detection of the tip
+	time.Sleep(50 * time.Millisecond)
+	for len(deprecatedJobCalls) > 0 {
+		<-deprecatedJobCalls
+	}
+
+	siad.newBlock()
+	select {
+	case <-deprecationChannel:
+	case <-time.After(time.Second):
+		t.Fatal("The work was not deprecated after a new block")
+	}
+	select {
+	case <-deprecatedJobCalls:
+	case <-time.After(time.Second):
+		t.Error("The deprecated job call was not executed after a new block")
+	}
+
+	_, header, newDeprecationChannel, _, err := sc.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != 1 || newDeprecationChannel == deprecationChannel {
+		t.Error("The work is not on top of the new block")
+	}
+
+	//A header fetched just before the new block is abandoned without deprecating the current work
+	siad.mutex.Lock()
+	siad.staleTemplates = 1
+	siad.mutex.Unlock()
+	_, header, staleDeprecationChannel, _, err := sc.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-staleDeprecationChannel:
+	default:
+		t.Error("The work on the previous block is not deprecated")
+	}
+	select {
+	case <-newDeprecationChannel:
+		t.Error("A stale header deprecated the work on the current block")
+	default:
+	}
+	if header[0] != 0 {
+		t.Error("The fake siad did not serve a stale header")
+	}
+}
@@ -0,0 +1,279 @@
+package sia
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients"
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+//GetworkClient is a sia client for pools and bridges exposing a getwork JSON-RPC endpoint over http.
+// The data of the work is the hex encoded 80 byte header, the target is the hex encoded 32 byte target in the same
+// byte order as siad uses. A solution is submitted by calling getwork with the hex encoded header as only parameter.
+//
+// If the server returns an X-Long-Polling header, a long polling request is kept open in the background
+// and the outstanding work is abandoned when it returns.
+type GetworkClient struct {
+	url string
+	//User and Password are sent using HTTP basic authentication if User is not empty
+	User     string
+	Password string
+	//HTTPClient is used for the requests, replace it to use custom TLS settings
+	HTTPClient *http.Client
+
+	mutex       sync.Mutex // protects following
+	requestID   uint64
+	parentID    string
+	longPolling bool
+	clients.BaseClient
+
+	status workStatus
+}
+
+//getworkResponse is the JSON-RPC response of a getwork server
+type getworkResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+}
+
+//getwork is the work returned by a getwork call without parameters
+type getwork struct {
+	Data   string `json:"data"`
+	Target string `json:"target"`
+}
+
+// NewGetworkClient creates a GetworkClient given a '(http|https)+getwork://[user[:password]@]host:port[/path]' connectionstring.
+// If the connectionstring does not contain a user, pooluser is used.
+func NewGetworkClient(connectionstring, pooluser string) (gc *GetworkClient, err error) {
+	u, err := url.Parse(connectionstring)
+	if err != nil {
+		return
+	}
+	switch u.Scheme {
+	case "http+getwork":
+		u.Scheme = "http"
+	case "https+getwork":
+		u.Scheme = "https"
+	default:
+		err = fmt.Errorf("Unsupported getwork url scheme %s", u.Scheme)
+		return
+	}
+	gc = &GetworkClient{User: pooluser, HTTPClient: &http.Client{}}
+	if u.User != nil {
+		gc.User = u.User.Username()
+		gc.Password, _ = u.User.Password()
+		u.User = nil
+	}
+	gc.url = u.String()
+	return
+}
+
+//log returns the logger of the client, the entries mention the getwork url
+func (gc *GetworkClient) log() *logging.Logger {
+	return logging.New("getwork").With("pool", gc.url)
+}
+
+//Start does nothing, the long polling is started once the server announces it
+func (gc *GetworkClient) Start() {}
+
+//SetDeprecatedJobCall sets the function to be called when the previous jobs should be abandoned
+func (gc *GetworkClient) SetDeprecatedJobCall(call clients.DeprecatedJobCall) {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+	gc.BaseClient.SetDeprecatedJobCall(call)
+}
+
+//call invokes getwork on the specified url, the response headers are returned as well to check for long polling support
+func (gc *GetworkClient) call(url string, params []interface{}) (result json.RawMessage, header http.Header, err error) {
+	gc.mutex.Lock()
+	gc.requestID++
+	id := gc.requestID
+	user, password := gc.User, gc.Password
+	gc.mutex.Unlock()
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{"id": id, "method": "getwork", "params": params})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gominer")
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := gc.HTTPClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+	case 401:
+		err = errors.New("Status code 401, the getwork server rejected the user and password")
+		return
+	default:
+		err = fmt.Errorf("Status code %d", resp.StatusCode)
+		return
+	}
+	var r getworkResponse
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return
+	}
+	if len(r.Error) > 0 && string(r.Error) != "null" {
+		err = getworkError(r.Error)
+		return
+	}
+	result = r.Result
+	header = resp.Header
+	return
+}
+
+//getworkError converts a JSON-RPC error, either an object with a message or any other value, to an error
+func getworkError(rawError json.RawMessage) error {
+	var e struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(rawError, &e) == nil && e.Message != "" {
+		return fmt.Errorf("getwork error %d: %s", e.Code, e.Message)
+	}
+	return fmt.Errorf("getwork error: %s", rawError)
+}
+
+//startLongPolling starts the long polling in the background if it is announced by the server and not started yet
+func (gc *GetworkClient) startLongPolling(header http.Header) {
+	path := header.Get("X-Long-Polling")
+	if path == "" {
+		return
+	}
+	base, err := url.Parse(gc.url)
+	if err != nil {
+		return
+	}
+	longPollURL, err := base.Parse(path)
+	if err != nil {
+		gc.log().Error("Invalid long polling url", path, "-", err)
+		return
+	}
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+	if gc.longPolling {
+		return
+	}
+	gc.longPolling = true
+	gc.log().Info("Long polling for new blocks on", longPollURL)
+	go gc.longPoll(longPollURL.String())
+}
+
+//longPoll keeps a long polling request open and abandons the outstanding work every time it returns
+func (gc *GetworkClient) longPoll(longPollURL string) {
+	for {
+		if _, _, err := gc.call(longPollURL, nil); err != nil {
+			gc.log().Error("Long polling failed -", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		gc.log().Info("New work announced by long polling, abandoning the outstanding work")
+		gc.mutex.Lock()
+		gc.DeprecateOutstandingJobs()
+		//Make sure the next work gets a new deprecation channel, even if the parent did not change
+		gc.parentID = ""
+		gc.mutex.Unlock()
+	}
+}
+
+//updateParentID deprecates the outstanding work if the parent of new work changed and
+// returns the channel that is closed when work on top of parentID should be abandoned
+func (gc *GetworkClient) updateParentID(parentID string) (deprecationChannel chan bool) {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+	if parentID != gc.parentID {
+		gc.DeprecateOutstandingJobs()
+		gc.parentID = parentID
+		gc.AddJobToDeprecate(parentID)
+	}
+	return gc.GetDeprecationChannel(parentID)
+}
+
+//GetHeaderForWork fetches new work from the getwork server
+func (gc *GetworkClient) GetHeaderForWork() (target []byte, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	start := time.Now()
+	defer func() { gc.status.update(err, job, target, start) }()
+	result, responseHeader, err := gc.call(gc.url, nil)
+	if err != nil {
+		return
+	}
+	gc.startLongPolling(responseHeader)
+
+	var work getwork
+	if err = json.Unmarshal(result, &work); err != nil {
+		return
+	}
+	data, err := hex.DecodeString(work.Data)
+	if err != nil || len(data) < BlockHeaderSize {
+		err = errors.New("Invalid data in the getwork response")
+		return
+	}
+	target, err = hex.DecodeString(work.Target)
+	if err != nil || len(target) != HashSize {
+		err = errors.New("Invalid target in the getwork response")
+		return
+	}
+	header = data[:BlockHeaderSize]
+
+	var bh BlockHeader
+	if err = bh.Unmarshal(header); err != nil {
+		return
+	}
+	parentID := hex.EncodeToString(bh.ParentID[:])
+	deprecationChannel = gc.updateParentID(parentID)
+	job = parentID
+	return
+}
+
+//SetUser replaces the user sent to the getwork server from the next request on
+func (gc *GetworkClient) SetUser(user string) error {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+	gc.User = user
+	return nil
+}
+
+//PoolStatus returns the state of the work fetched from the getwork server
+func (gc *GetworkClient) PoolStatus() []clients.PoolStatus {
+	return gc.status.poolStatus(gc.url)
+}
+
+//SubmitHeader submits a solved header to the getwork server
+func (gc *GetworkClient) SubmitHeader(header []byte, job interface{}) (err error) {
+	result, responseHeader, err := gc.call(gc.url, []interface{}{hex.EncodeToString(header)})
+	if err != nil {
+		return
+	}
+	var accepted bool
+	if err = json.Unmarshal(result, &accepted); err != nil {
+		return
+	}
+	if !accepted {
+		reason := strings.TrimSpace(responseHeader.Get("X-Reject-Reason"))
+		if reason == "" {
+			reason = "no reason given"
+		}
+		err = fmt.Errorf("Solution rejected by the getwork server: %s", reason)
+	}
+	return
+}
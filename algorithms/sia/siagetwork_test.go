@@ -0,0 +1,122 @@
+package sia
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//fakeGetwork is a getwork server handing out a single header
+type fakeGetwork struct {
+	header    BlockHeader
+	target    Target
+	submitted chan string
+	longPoll  chan bool
+}
+
+func (f *fakeGetwork) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "worker" || password != "secret" {
+		w.WriteHeader(401)
+		return
+	}
+	var request struct {
+		ID     interface{}   `json:"id"`
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Method != "getwork" {
+		w.WriteHeader(400)
+		return
+	}
+	if r.URL.Path == "/lp" {
+		<-f.longPoll
+	}
+	response := map[string]interface{}{"id": request.ID, "error": nil}
+	if len(request.Params) == 0 {
+		w.Header().Set("X-Long-Polling", "/lp")
+		response["result"] = map[string]string{
+			"data":   hex.EncodeToString(f.header.Marshal()) + "000000000000",
+			"target": hex.EncodeToString(f.target[:]),
+		}
+	} else {
+		data, _ := request.Params[0].(string)
+		f.submitted <- data
+		accepted := strings.HasSuffix(data, hex.EncodeToString(f.header.MerkleRoot[:]))
+		if !accepted {
+			w.Header().Set("X-Reject-Reason", "invalid header")
+		}
+		response["result"] = accepted
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestGetworkClient(t *testing.T) {
+	f := &fakeGetwork{submitted: make(chan string, 2), longPoll: make(chan bool)}
+	f.header.ParentID[0] = 1
+	f.header.MerkleRoot[0] = 2
+	f.header.Timestamp = 100
+	f.target = DifficultyOneTarget
+	server := httptest.NewServer(f)
+	defer server.Close()
+	defer close(f.longPoll)
+
+	if c := NewClient("http+getwork://worker:wrong@"+strings.TrimPrefix(server.URL, "http://"), "user"); c == nil {
+		t.Fatal("No client created for a getwork url")
+	} else if _, _, _, _, err := c.GetHeaderForWork(); err == nil {
+		t.Error("Expected an error when using the wrong password")
+	}
+
+	c := NewClient("http+getwork://"+strings.TrimPrefix(server.URL, "http://"), "worker")
+	gc, ok := c.(*GetworkClient)
+	if !ok {
+		t.Fatal("No GetworkClient created for a getwork url")
+	}
+	gc.Password = "secret"
+	c.Start()
+
+	target, header, deprecationChannel, _, err := c.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(header) != hex.EncodeToString(f.header.Marshal()) {
+		t.Error("Received header", hex.EncodeToString(header), "instead of", hex.EncodeToString(f.header.Marshal()))
+	}
+	if hex.EncodeToString(target) != hex.EncodeToString(f.target[:]) {
+		t.Error("Received target", hex.EncodeToString(target))
+	}
+
+	if err = c.SubmitHeader(header, nil); err != nil {
+		t.Error("Solution not accepted:", err)
+	}
+	if submitted := <-f.submitted; submitted != hex.EncodeToString(header) {
+		t.Error("Submitted", submitted, "instead of the header")
+	}
+	if err = c.SubmitHeader(make([]byte, BlockHeaderSize), nil); err == nil || !strings.Contains(err.Error(), "invalid header") {
+		t.Error("Expected a rejected solution instead of", err)
+	}
+	<-f.submitted
+
+	//The long polling request returning should abandon the outstanding work
+	select {
+	case <-deprecationChannel:
+		t.Fatal("Work abandoned before the long polling request returned")
+	default:
+	}
+	f.longPoll <- true
+	select {
+	case <-deprecationChannel:
+	case <-time.After(time.Second):
+		t.Fatal("Work not abandoned after the long polling request returned")
+	}
+	_, _, deprecationChannel, _, err = c.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deprecationChannel == nil {
+		t.Error("No deprecation channel for the work after a long poll")
+	}
+}
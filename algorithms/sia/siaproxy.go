@@ -0,0 +1,332 @@
+package sia
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/robvanmieghem/gominer/clients/stratum"
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+var proxyLog = logging.New("proxy")
+
+//DefaultProxyPrefixSize is the default number of upstream extranonce2 bytes reserved for the downstream prefixes
+const DefaultProxyPrefixSize = 2
+
+//MaxProxyPrefixSize is the largest number of upstream extranonce2 bytes that can be used for the downstream prefixes
+const MaxProxyPrefixSize = 7
+
+//StratumProxy accepts stratum connections from downstream miners and forwards their valid shares
+// to a pool over a single upstream stratum connection.
+// The upstream extranonce2 space is split: every downstream connection gets a unique prefix that is
+// appended to the upstream extranonce1, the remaining bytes are left for the downstream extranonce2.
+type StratumProxy struct {
+	Upstream *StratumClient
+	//PrefixSize is the number of upstream extranonce2 bytes used for the downstream prefixes,
+	// from 1 to MaxProxyPrefixSize
+	PrefixSize uint
+
+	server stratum.Server
+
+	mutex           sync.Mutex // protects following
+	extranonce1     []byte
+	extranonce2Size uint
+	difficulty      float64
+	target          Target
+	currentJob      stratumJob
+	jobs            map[string]stratumJob
+	sessions        map[*stratum.ServerConn]*proxySession
+	nextPrefix      uint64
+}
+
+//proxySession keeps the state of a downstream connection
+type proxySession struct {
+	prefix     []byte
+	subscribed bool
+	worker     string
+	accepted   uint64
+	rejected   uint64
+}
+
+// NewStratumProxy creates a StratumProxy towards a 'stratum+tcp://host:port' upstream pool
+func NewStratumProxy(connectionstring, pooluser string) (p *StratumProxy, err error) {
+	if !strings.HasPrefix(connectionstring, "stratum+tcp://") {
+		err = errors.New("The upstream of a proxy should be a stratum server (stratum+tcp://<host>:<port>)")
+		return
+	}
+	p = &StratumProxy{
+		Upstream:   &StratumClient{connectionstring: strings.TrimPrefix(connectionstring, "stratum+tcp://"), User: pooluser},
+		PrefixSize: DefaultProxyPrefixSize,
+	}
+	return
+}
+
+//Listen connects to the upstream pool and starts accepting downstream connections on the specified address
+func (p *StratumProxy) Listen(address string) (err error) {
+	//Without a prefix byte every downstream miner would share the same extranonce, more than 7 bytes do not fit the prefix counter
+	if p.PrefixSize < 1 || p.PrefixSize > MaxProxyPrefixSize {
+		err = fmt.Errorf("The prefix size should be from 1 to %d bytes, not %d", MaxProxyPrefixSize, p.PrefixSize)
+		return
+	}
+	p.sessions = make(map[*stratum.ServerConn]*proxySession)
+	p.jobs = make(map[string]stratumJob)
+
+	p.Upstream.subscribedCall = p.upstreamSubscribed
+	p.Upstream.newJobCall = p.upstreamNewJob
+	p.Upstream.difficultyCall = p.upstreamDifficulty
+
+	p.server.ConnectCallback = func(c *stratum.ServerConn) {
+		proxyLog.Info("Downstream miner connected from", c.RemoteAddr())
+		p.mutex.Lock()
+		p.sessions[c] = &proxySession{}
+		p.mutex.Unlock()
+	}
+	p.server.DisconnectCallback = func(c *stratum.ServerConn) {
+		p.mutex.Lock()
+		session := p.sessions[c]
+		delete(p.sessions, c)
+		p.mutex.Unlock()
+		proxyLog.Info("Downstream miner", session.worker, "from", c.RemoteAddr(), "disconnected - accepted:", session.accepted, "rejected:", session.rejected)
+	}
+	p.server.SetRequestHandler("mining.subscribe", p.subscribe)
+	p.server.SetRequestHandler("mining.authorize", p.authorize)
+	p.server.SetRequestHandler("mining.submit", p.submit)
+
+	p.Upstream.Start()
+
+	if err = p.server.Listen(address); err != nil {
+		return
+	}
+	proxyLog.Info("Proxy listening on", p.server.Addr())
+	return
+}
+
+//Addr returns the network address the proxy is listening on
+func (p *StratumProxy) Addr() string {
+	return p.server.Addr().String()
+}
+
+//Close drops all downstream connections and stops listening
+func (p *StratumProxy) Close() {
+	p.server.Close()
+}
+
+//upstreamSubscribed is called with the upstream StratumClient's mutex held
+func (p *StratumProxy) upstreamSubscribed(extranonce1 []byte, extranonce2Size uint) {
+	p.mutex.Lock()
+	changed := !bytes.Equal(p.extranonce1, extranonce1) || p.extranonce2Size != extranonce2Size
+	p.extranonce1 = extranonce1
+	p.extranonce2Size = extranonce2Size
+	p.mutex.Unlock()
+	if extranonce2Size <= p.PrefixSize {
+		proxyLog.Error("The upstream extranonce2_size of", extranonce2Size, "is too small to split over downstream miners")
+	}
+	//The downstream miners are working with the old extranonce1, make them reconnect
+	if changed {
+		for _, c := range p.server.Connections() {
+			c.Close()
+		}
+	}
+}
+
+//upstreamNewJob is called with the upstream StratumClient's mutex held
+func (p *StratumProxy) upstreamNewJob(sj stratumJob) {
+	p.mutex.Lock()
+	if sj.CleanJobs {
+		p.jobs = make(map[string]stratumJob)
+	}
+	p.jobs[sj.JobID] = sj
+	p.currentJob = sj
+	p.mutex.Unlock()
+	p.notifySubscribed("mining.notify", sj.notifyParams())
+}
+
+//upstreamDifficulty is called with the upstream StratumClient's mutex held
+func (p *StratumProxy) upstreamDifficulty(difficulty float64) {
+	target, err := DifficultyToTarget(difficulty)
+	if err != nil {
+		return
+	}
+	p.mutex.Lock()
+	p.difficulty = difficulty
+	p.target = target
+	p.mutex.Unlock()
+	p.notifySubscribed("mining.set_difficulty", []interface{}{difficulty})
+}
+
+func (p *StratumProxy) notifySubscribed(method string, params []interface{}) {
+	p.mutex.Lock()
+	connections := make([]*stratum.ServerConn, 0, len(p.sessions))
+	for c, session := range p.sessions {
+		if session.subscribed {
+			connections = append(connections, c)
+		}
+	}
+	p.mutex.Unlock()
+	for _, c := range connections {
+		if err := c.Notify(method, params); err != nil {
+			proxyLog.Error("Unable to notify downstream miner", c.RemoteAddr(), "-", err)
+		}
+	}
+}
+
+//allocatePrefix returns an extranonce prefix that is not in use by another downstream connection
+// This method is not threadsafe
+func (p *StratumProxy) allocatePrefix() (prefix []byte, err error) {
+	available := uint64(1) << (8 * p.PrefixSize)
+	inUse := make(map[string]bool, len(p.sessions))
+	for _, session := range p.sessions {
+		inUse[string(session.prefix)] = true
+	}
+	for i := uint64(0); i < available; i++ {
+		en := stratum.ExtraNonce2{Value: p.nextPrefix % available, Size: p.PrefixSize}
+		p.nextPrefix++
+		if candidate := en.Bytes(); !inUse[string(candidate)] {
+			prefix = candidate
+			return
+		}
+	}
+	err = errors.New("No extranonce prefixes left for new downstream miners")
+	return
+}
+
+func (p *StratumProxy) subscribe(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.extranonce1 == nil {
+		err = errors.New("Not connected to the upstream pool yet")
+		return
+	}
+	if p.extranonce2Size <= p.PrefixSize {
+		err = errors.New("Upstream extranonce2_size too small")
+		return
+	}
+	session := p.sessions[c]
+	if session.prefix == nil {
+		if session.prefix, err = p.allocatePrefix(); err != nil {
+			return
+		}
+	}
+	session.subscribed = true
+	downstreamExtranonce1 := append(append([]byte{}, p.extranonce1...), session.prefix...)
+	subscriptionID := hex.EncodeToString(session.prefix)
+	result = []interface{}{
+		[]interface{}{
+			[]interface{}{"mining.set_difficulty", subscriptionID},
+			[]interface{}{"mining.notify", subscriptionID},
+		},
+		hex.EncodeToString(downstreamExtranonce1),
+		p.extranonce2Size - p.PrefixSize,
+	}
+	return
+}
+
+func (p *StratumProxy) authorize(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+	if len(params) < 1 {
+		err = stratum.ErrUnauthorizedWorker
+		return
+	}
+	worker, _ := params[0].(string)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.sessions[c].worker = worker
+	proxyLog.Info("Downstream miner", worker, "from", c.RemoteAddr(), "authorized")
+	result = true
+
+	//Give the miner something to work on
+	difficulty := p.difficulty
+	currentJob := p.currentJob
+	c.AfterReply(func() {
+		if difficulty != 0 {
+			c.Notify("mining.set_difficulty", []interface{}{difficulty})
+		}
+		if currentJob.JobID != "" {
+			currentJob.CleanJobs = true
+			c.Notify("mining.notify", currentJob.notifyParams())
+		}
+	})
+	return
+}
+
+//submit validates a share of a downstream miner and forwards it to the upstream pool
+func (p *StratumProxy) submit(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+	p.mutex.Lock()
+	session := p.sessions[c]
+	subscribed, worker, prefix := session.subscribed, session.worker, session.prefix
+	sj, found := p.jobs[jobIDParameter(params)]
+	extranonce1 := append(append([]byte{}, p.extranonce1...), prefix...)
+	extranonce2Size := p.extranonce2Size - p.PrefixSize
+	target := p.target
+	p.mutex.Unlock()
+
+	defer func() {
+		p.mutex.Lock()
+		if err == nil {
+			session.accepted++
+		} else {
+			session.rejected++
+		}
+		p.mutex.Unlock()
+	}()
+
+	if !subscribed {
+		err = stratum.ErrNotSubscribed
+		return
+	}
+	if worker == "" {
+		err = stratum.ErrUnauthorizedWorker
+		return
+	}
+	if len(params) < 5 {
+		err = errors.New("Wrong number of parameters")
+		return
+	}
+	if !found {
+		err = stratum.ErrJobNotFound
+		return
+	}
+	extranonce2, err := stratum.HexStringToBytes(params[2])
+	if err != nil || uint(len(extranonce2)) != extranonce2Size {
+		err = errors.New("Invalid extranonce2")
+		return
+	}
+	nTime, err := stratum.HexStringToBytes(params[3])
+	if err != nil || len(nTime) != 8 {
+		err = errors.New("Invalid ntime")
+		return
+	}
+	nonce, err := stratum.HexStringToBytes(params[4])
+	if err != nil || len(nonce) != 8 {
+		err = errors.New("Invalid nonce")
+		return
+	}
+
+	header := sj.header(extranonce1, extranonce2)
+	copy(header.Nonce[:], nonce)
+	header.Timestamp = binary.LittleEndian.Uint64(nTime)
+	if !header.MeetsTarget(target) {
+		err = stratum.ErrLowDifficultyShare
+		return
+	}
+
+	upstreamExtranonce2 := append(append([]byte{}, prefix...), extranonce2...)
+	if err = p.Upstream.submit(p.Upstream.User, sj.JobID, hex.EncodeToString(upstreamExtranonce2), hex.EncodeToString(nTime), hex.EncodeToString(nonce)); err != nil {
+		proxyLog.Warn("Share of", worker, "rejected by the pool -", err)
+		return
+	}
+	proxyLog.Info("Share of", worker, "accepted - difficulty", formatDifficulty(Target(header.ID()).Difficulty()))
+	result = true
+	return
+}
+
+func jobIDParameter(params []interface{}) (jobID string) {
+	if len(params) > 1 {
+		jobID, _ = params[1].(string)
+	}
+	return
+}
@@ -0,0 +1,155 @@
+package sia
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients/stratum"
+)
+
+func TestStratumProxy(t *testing.T) {
+	//A difficulty that makes about half of the hashes a valid share
+	difficulty := 1.0 / (1 << 31)
+	job := stratumJob{
+		JobID:        "job1",
+		PrevHash:     bytes.Repeat([]byte{1}, 32),
+		Coinbase1:    []byte{2, 2},
+		Coinbase2:    []byte{3, 3},
+		MerkleBranch: [][]byte{bytes.Repeat([]byte{4}, 32)},
+		NTime:        []byte{5, 5, 5, 5, 0, 0, 0, 0},
+		CleanJobs:    true,
+	}
+
+	submits := make(chan []interface{}, 10)
+	pool := &stratum.Server{}
+	pool.SetRequestHandler("mining.subscribe", func(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+		result = []interface{}{nil, "aabbccdd", 4}
+		return
+	})
+	pool.SetRequestHandler("mining.authorize", func(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+		c.AfterReply(func() {
+			c.Notify("mining.set_difficulty", []interface{}{difficulty})
+			c.Notify("mining.notify", job.notifyParams())
+		})
+		result = true
+		return
+	})
+	pool.SetRequestHandler("mining.submit", func(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+		submits <- params
+		result = true
+		return
+	})
+	if err := pool.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	proxy, err := NewStratumProxy("stratum+tcp://"+pool.Addr().String(), "pooluser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = proxy.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	//Wait for the proxy to receive the job from the pool
+	for i := 0; ; i++ {
+		proxy.mutex.Lock()
+		jobID := proxy.currentJob.JobID
+		proxy.mutex.Unlock()
+		if jobID != "" {
+			break
+		}
+		if i > 100 {
+			t.Fatal("The proxy did not receive a job from the pool")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	jobs := make(chan stratumJob, 1)
+	downstream := &stratum.Client{}
+	downstream.SetNotificationHandler("mining.notify", func(params []interface{}) {
+		sj, err := parseStratumJob(params)
+		if err != nil {
+			t.Error(err)
+		}
+		jobs <- sj
+	})
+	if err = downstream.Dial(proxy.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer downstream.Close()
+
+	result, err := downstream.Call("mining.subscribe", []string{"gominer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := result.([]interface{})
+	extranonce1, _ := stratum.HexStringToBytes(reply[1])
+	if !bytes.Equal(extranonce1, []byte{0xaa, 0xbb, 0xcc, 0xdd, 0, 0}) || reply[2] != 2.0 {
+		t.Fatal("Unexpected subscribe reply:", reply)
+	}
+	if _, err = downstream.Call("mining.authorize", []string{"rig1", ""}); err != nil {
+		t.Fatal(err)
+	}
+	var sj stratumJob
+	select {
+	case sj = <-jobs:
+	case <-time.After(time.Second):
+		t.Fatal("No job received from the proxy")
+	}
+
+	//Search a valid and an invalid share
+	target, _ := DifficultyToTarget(difficulty)
+	extranonce2 := []byte{0, 7}
+	var validNonce, invalidNonce []byte
+	for i := uint32(1); validNonce == nil || invalidNonce == nil; i++ {
+		header := sj.header(extranonce1, extranonce2)
+		binary.LittleEndian.PutUint32(header.Nonce[:4], i)
+		if header.MeetsTarget(target) {
+			validNonce = header.Nonce[:]
+		} else {
+			invalidNonce = header.Nonce[:]
+		}
+	}
+	nTime := hex.EncodeToString(sj.NTime)
+
+	if _, err = downstream.Call("mining.submit", []string{"rig1", "job1", "0007", nTime, hex.EncodeToString(invalidNonce)}); err == nil || err.Error() != stratum.ErrLowDifficultyShare.Message {
+		t.Error("Expected a low difficulty error instead of", err)
+	}
+	if _, err = downstream.Call("mining.submit", []string{"rig1", "unknown", "0007", nTime, hex.EncodeToString(validNonce)}); err == nil || err.Error() != stratum.ErrJobNotFound.Message {
+		t.Error("Expected a job not found error instead of", err)
+	}
+	if _, err = downstream.Call("mining.submit", []string{"rig1", "job1", "0007", nTime, hex.EncodeToString(validNonce)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(submits) != 1 {
+		t.Fatal(len(submits), "shares forwarded to the pool instead of 1")
+	}
+	submit := <-submits
+	expected := []interface{}{"pooluser", "job1", "00000007", nTime, hex.EncodeToString(validNonce)}
+	for i := range expected {
+		if submit[i] != expected[i] {
+			t.Error("Forwarded share", submit, "instead of", expected)
+			break
+		}
+	}
+}
+
+func TestStratumProxyPrefixSize(t *testing.T) {
+	for _, size := range []uint{0, MaxProxyPrefixSize + 1, 64} {
+		proxy, err := NewStratumProxy("stratum+tcp://127.0.0.1:1", "pooluser")
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy.PrefixSize = size
+		if err = proxy.Listen("127.0.0.1:0"); err == nil {
+			proxy.Close()
+			t.Error("No error for a prefix size of", size)
+		}
+	}
+}
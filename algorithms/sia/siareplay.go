@@ -0,0 +1,94 @@
+package sia
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients/stratum"
+)
+
+//ReplayStratumSessions feeds recorded stratum sessions to a StratumClient and writes what the client makes of them to out:
+// the subscription details, the difficulty changes and for every job, the parsed fields and the first header to mine on.
+// The client is configured like the one that made the recording so it sends the same requests.
+// It returns once all sessions are replayed.
+func ReplayStratumSessions(frames []stratum.Frame, realtime bool, out io.Writer) (err error) {
+	server := stratum.NewReplayServer(frames)
+	server.Realtime = realtime
+	if err = server.Listen("127.0.0.1:0"); err != nil {
+		return
+	}
+	defer server.Close()
+
+	sc := &StratumClient{connectionstring: server.Addr().String(), User: "replay", reconnectWait: 10 * time.Millisecond}
+	configureReplayClient(sc, frames)
+	sc.subscribedCall = func(extranonce1 []byte, extranonce2Size uint) {
+		fmt.Fprintln(out, "subscribed - extranonce1", hex.EncodeToString(extranonce1), "- extranonce2_size", extranonce2Size)
+	}
+	sc.difficultyCall = func(difficulty float64) {
+		fmt.Fprintln(out, "difficulty", difficulty, "- target", hex.EncodeToString(sc.target[:]))
+	}
+	sc.newJobCall = func(sj stratumJob) {
+		sj.ExtraNonce2.Value = 0
+		bh := sj.header(sc.extranonce1, sj.ExtraNonce2.Bytes())
+		header := bh.Marshal()
+		fmt.Fprintln(out, "job", sj.JobID, "- clean_jobs", sj.CleanJobs, "- prevhash", hex.EncodeToString(sj.PrevHash), "- ntime", bh.Timestamp, "- merkle branches", len(sj.MerkleBranch))
+		fmt.Fprintln(out, "  header", hex.EncodeToString(header))
+	}
+	//All lines of a session are processed when the client notices the connection is closed
+	disconnected := make(chan bool, server.Sessions())
+	sc.disconnectedCall = func() {
+		select {
+		case disconnected <- true:
+		default:
+		}
+	}
+	sc.Start()
+	defer sc.stop()
+
+	for i := 0; i < server.Sessions(); i++ {
+		<-disconnected
+	}
+	<-server.Done()
+	return
+}
+
+//configureReplayClient configures the client to send the same requests as the recorded client did
+func configureReplayClient(sc *StratumClient, frames []stratum.Frame) {
+	for _, f := range frames {
+		if f.Direction != stratum.FrameOut {
+			continue
+		}
+		var r struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if json.Unmarshal([]byte(f.Line), &r) != nil || len(r.Params) == 0 {
+			continue
+		}
+		switch r.Method {
+		case "mining.configure":
+			var parameters struct {
+				MaxOffset uint64 `json:"ntime-rolling.max-offset"`
+			}
+			if len(r.Params) > 1 && json.Unmarshal(r.Params[1], &parameters) == nil {
+				sc.MaxNTimeRoll = time.Duration(parameters.MaxOffset) * time.Second
+			}
+		case "mining.suggest_difficulty":
+			json.Unmarshal(r.Params[0], &sc.SuggestedDifficulty)
+		case "mining.suggest_target":
+			var encodedTarget string
+			json.Unmarshal(r.Params[0], &encodedTarget)
+			var target Target
+			if rawTarget, err := hex.DecodeString(encodedTarget); err == nil && len(rawTarget) == HashSize {
+				copy(target[:], rawTarget)
+				sc.SuggestTarget = true
+				sc.SuggestedDifficulty = target.Difficulty()
+			}
+		case "mining.authorize":
+			json.Unmarshal(r.Params[0], &sc.User)
+		}
+	}
+}
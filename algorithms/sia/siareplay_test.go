@@ -0,0 +1,50 @@
+package sia
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients/stratum"
+	"github.com/robvanmieghem/gominer/clients/stratum/stratumtest"
+)
+
+func TestReplayStratumSessions(t *testing.T) {
+	//Record a session
+	pool := stratumtest.NewServer()
+	defer pool.Close()
+	var recording bytes.Buffer
+	sc := NewClient(pool.URL(), "user").(*StratumClient)
+	sc.Recorder = stratum.NewRecorder(&recording)
+	sc.SuggestedDifficulty = 8
+	sc.Start()
+	if _, err := pool.WaitForRequests("mining.authorize", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c := pool.Connections()[0]
+	c.SetDifficulty(2)
+	c.NotifyJob(testJob("job1", true)...)
+	waitForJob(t, sc, "job1")
+	_, header, _, _, err := sc.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.stop()
+
+	frames, err := stratum.ReadFrames(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err = ReplayStratumSessions(frames, false, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"extranonce1 aabbccdd", "difficulty 2", "job job1", "header " + hex.EncodeToString(header)} {
+		if !strings.Contains(out.String(), expected) {
+			t.Error("Replay output does not contain", expected, ":\n", out.String())
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package sia
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients/stratum"
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+var serverLog = logging.New("server")
+
+const (
+	//DefaultJobInterval is the default time between new jobs sent to a connected rig
+	DefaultJobInterval = 5 * time.Second
+	//DefaultStartDifficulty is the default share difficulty a rig starts with
+	DefaultStartDifficulty = 4
+	//DefaultSharesPerMinute is the default number of shares per minute the variable difficulty aims for
+	DefaultSharesPerMinute = 20
+	//DefaultRetargetInterval is the default time between difficulty adjustments
+	DefaultRetargetInterval = 30 * time.Second
+
+	//maxSoloJobs is the number of jobs per connection shares are still accepted for
+	maxSoloJobs = 16
+	//soloExtranonce2Size is the extranonce2_size given to the rigs, the headers of siad can not be changed by an extranonce2
+	soloExtranonce2Size = 4
+)
+
+//SoloServer fetches header templates from a siad and serves them to mining rigs as stratum jobs.
+// Every connection gets its own extranonce1 and variable difficulty, the shares are validated and
+// the ones that solve a block are submitted to siad through POST /miner/header.
+//
+// Every job is a template of its own, siad hands out a different header on every request, so no two rigs
+// search the same header. The blocks are built by siad and include the transactions of its transaction pool.
+// Since the headers of siad can not be reconstructed from a coinbase, the merkleroot is added as an extra
+// parameter to the mining.notify notification. Only gominer rigs understand this parameter.
+type SoloServer struct {
+	Siad *SiadClient
+	//JobInterval is the time between new jobs sent to a rig
+	JobInterval time.Duration
+	//StartDifficulty is the share difficulty a rig starts with
+	StartDifficulty float64
+	//SharesPerMinute is the number of shares per minute per rig the variable difficulty aims for
+	SharesPerMinute float64
+	//RetargetInterval is the minimum time between difficulty adjustments
+	RetargetInterval time.Duration
+
+	server stratum.Server
+
+	mutex           sync.Mutex // protects following
+	sessions        map[*stratum.ServerConn]*soloSession
+	nextExtranonce1 uint32
+}
+
+//soloSession keeps the state of a connected rig
+type soloSession struct {
+	extranonce1 []byte
+	subscribed  bool
+	worker      string
+	difficulty  float64
+	target      Target
+	jobs        map[string]*soloJob
+	jobOrder    []string
+	jobCounter  uint64
+	parentID    [HashSize]byte
+
+	shares       uint64
+	lastRetarget time.Time
+
+	accepted uint64
+	rejected uint64
+	blocks   uint64
+
+	stop chan bool
+}
+
+//soloJob is a header template of siad together with the targets it needs to be validated against
+type soloJob struct {
+	header      BlockHeader
+	shareTarget Target
+	blockTarget Target
+	submitted   map[string]bool
+}
+
+// NewSoloServer creates a SoloServer serving work of the siad at a '[http://|https://]host:port' connectionstring
+func NewSoloServer(connectionstring string) (s *SoloServer) {
+	s = &SoloServer{
+		Siad:             NewSiadClient(connectionstring),
+		JobInterval:      DefaultJobInterval,
+		StartDifficulty:  DefaultStartDifficulty,
+		SharesPerMinute:  DefaultSharesPerMinute,
+		RetargetInterval: DefaultRetargetInterval,
+	}
+	return
+}
+
+//Listen starts accepting stratum connections from rigs on the specified address
+func (s *SoloServer) Listen(address string) (err error) {
+	s.sessions = make(map[*stratum.ServerConn]*soloSession)
+
+	s.server.ConnectCallback = func(c *stratum.ServerConn) {
+		serverLog.Info("Rig connected from", c.RemoteAddr())
+		s.mutex.Lock()
+		s.nextExtranonce1++
+		session := &soloSession{
+			extranonce1: make([]byte, 4),
+			jobs:        make(map[string]*soloJob),
+			stop:        make(chan bool),
+		}
+		binary.BigEndian.PutUint32(session.extranonce1, s.nextExtranonce1)
+		s.sessions[c] = session
+		s.mutex.Unlock()
+	}
+	s.server.DisconnectCallback = func(c *stratum.ServerConn) {
+		s.mutex.Lock()
+		session := s.sessions[c]
+		delete(s.sessions, c)
+		s.mutex.Unlock()
+		close(session.stop)
+		serverLog.Info("Rig", session.worker, "from", c.RemoteAddr(), "disconnected - accepted:", session.accepted, "rejected:", session.rejected, "blocks:", session.blocks)
+	}
+	s.server.SetRequestHandler("mining.subscribe", s.subscribe)
+	s.server.SetRequestHandler("mining.authorize", s.authorize)
+	s.server.SetRequestHandler("mining.submit", s.submit)
+
+	//Send new work to all rigs as soon as siad has a new block
+	s.Siad.SetDeprecatedJobCall(s.newBlock)
+	s.Siad.Start()
+
+	if err = s.server.Listen(address); err != nil {
+		return
+	}
+	serverLog.Info("Stratum server listening on", s.server.Addr())
+	return
+}
+
+//Addr returns the network address the server is listening on
+func (s *SoloServer) Addr() string {
+	return s.server.Addr().String()
+}
+
+//Close drops all rigs and stops listening
+func (s *SoloServer) Close() {
+	s.server.Close()
+}
+
+func (s *SoloServer) subscribe(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session := s.sessions[c]
+	session.subscribed = true
+	subscriptionID := hex.EncodeToString(session.extranonce1)
+	result = []interface{}{
+		[]interface{}{
+			[]interface{}{"mining.set_difficulty", subscriptionID},
+			[]interface{}{"mining.notify", subscriptionID},
+		},
+		subscriptionID,
+		soloExtranonce2Size,
+	}
+	return
+}
+
+func (s *SoloServer) authorize(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+	if len(params) < 1 {
+		err = stratum.ErrUnauthorizedWorker
+		return
+	}
+	worker, _ := params[0].(string)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session := s.sessions[c]
+	if !session.subscribed {
+		err = stratum.ErrNotSubscribed
+		return
+	}
+	alreadyAuthorized := session.worker != ""
+	session.worker = worker
+	serverLog.Info("Rig", worker, "from", c.RemoteAddr(), "authorized")
+	result = true
+	if alreadyAuthorized {
+		return
+	}
+	session.lastRetarget = time.Now()
+	c.AfterReply(func() {
+		s.setDifficulty(c, session, s.StartDifficulty)
+		go s.feed(c, session)
+	})
+	return
+}
+
+//newBlock sends a new job to every authorized rig
+func (s *SoloServer) newBlock() {
+	s.mutex.Lock()
+	authorized := make(map[*stratum.ServerConn]*soloSession, len(s.sessions))
+	for c, session := range s.sessions {
+		if session.worker != "" {
+			authorized[c] = session
+		}
+	}
+	s.mutex.Unlock()
+	for c, session := range authorized {
+		if err := s.sendJob(c, session); err != nil {
+			serverLog.Error("Unable to fetch work -", err)
+		}
+	}
+}
+
+//feed keeps sending new jobs to a rig until it disconnects
+func (s *SoloServer) feed(c *stratum.ServerConn, session *soloSession) {
+	ticker := time.NewTicker(s.JobInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.sendJob(c, session); err != nil {
+			serverLog.Error("Unable to fetch work -", err)
+		}
+		select {
+		case <-session.stop:
+			return
+		case <-ticker.C:
+		}
+		s.retarget(c, session)
+	}
+}
+
+//sendJob fetches a new header template from siad and sends it to the rig
+func (s *SoloServer) sendJob(c *stratum.ServerConn, session *soloSession) (err error) {
+	target, rawHeader, _, _, err := s.Siad.GetHeaderForWork()
+	if err != nil {
+		return
+	}
+	var header BlockHeader
+	if err = header.Unmarshal(rawHeader); err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	session.jobCounter++
+	jobID := strconv.FormatUint(session.jobCounter, 16)
+	job := &soloJob{header: header, shareTarget: session.target, submitted: make(map[string]bool)}
+	copy(job.blockTarget[:], target)
+	cleanJobs := session.parentID != header.ParentID
+	if cleanJobs {
+		session.jobs = make(map[string]*soloJob)
+		session.jobOrder = nil
+	}
+	session.parentID = header.ParentID
+	session.jobs[jobID] = job
+	session.jobOrder = append(session.jobOrder, jobID)
+	if len(session.jobOrder) > maxSoloJobs {
+		delete(session.jobs, session.jobOrder[0])
+		session.jobOrder = session.jobOrder[1:]
+	}
+	s.mutex.Unlock()
+
+	sj := stratumJob{
+		JobID:        jobID,
+		PrevHash:     header.ParentID[:],
+		MerkleBranch: [][]byte{},
+		NTime:        make([]byte, 8),
+		CleanJobs:    cleanJobs,
+		MerkleRoot:   header.MerkleRoot[:],
+	}
+	binary.LittleEndian.PutUint64(sj.NTime, header.Timestamp)
+	err = c.Notify("mining.notify", sj.notifyParams())
+	return
+}
+
+func (s *SoloServer) setDifficulty(c *stratum.ServerConn, session *soloSession, difficulty float64) {
+	target, err := DifficultyToTarget(difficulty)
+	if err != nil {
+		serverLog.Error("Unable to set the difficulty to", difficulty)
+		return
+	}
+	s.mutex.Lock()
+	session.difficulty = difficulty
+	session.target = target
+	s.mutex.Unlock()
+	c.Notify("mining.set_difficulty", []interface{}{difficulty})
+}
+
+//retarget adjusts the share difficulty of a rig so it submits about SharesPerMinute shares
+func (s *SoloServer) retarget(c *stratum.ServerConn, session *soloSession) {
+	s.mutex.Lock()
+	elapsed := time.Since(session.lastRetarget)
+	if elapsed < s.RetargetInterval {
+		s.mutex.Unlock()
+		return
+	}
+	sharesPerMinute := float64(session.shares) / elapsed.Minutes()
+	difficulty := session.difficulty
+	session.shares = 0
+	session.lastRetarget = time.Now()
+	s.mutex.Unlock()
+
+	ratio := sharesPerMinute / s.SharesPerMinute
+	switch {
+	case ratio < 0.25:
+		ratio = 0.25
+	case ratio > 4:
+		ratio = 4
+	case ratio > 0.75 && ratio < 1.5:
+		return
+	}
+	serverLog.Info("Adjusting difficulty of", session.worker, "to", difficulty*ratio, "-", sharesPerMinute, "shares per minute")
+	s.setDifficulty(c, session, difficulty*ratio)
+}
+
+//submit validates a share of a rig and submits it to siad if it solves a block
+func (s *SoloServer) submit(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+	s.mutex.Lock()
+	session := s.sessions[c]
+	worker := session.worker
+	job := session.jobs[jobIDParameter(params)]
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		if err == nil {
+			session.accepted++
+			session.shares++
+		} else {
+			session.rejected++
+		}
+		s.mutex.Unlock()
+	}()
+
+	if worker == "" {
+		err = stratum.ErrUnauthorizedWorker
+		return
+	}
+	if len(params) < 5 {
+		err = errors.New("Wrong number of parameters")
+		return
+	}
+	if job == nil {
+		err = stratum.ErrJobNotFound
+		return
+	}
+	nTime, err := stratum.HexStringToBytes(params[3])
+	if err != nil || len(nTime) != 8 || binary.LittleEndian.Uint64(nTime) != job.header.Timestamp {
+		err = errors.New("Invalid ntime")
+		return
+	}
+	nonce, err := stratum.HexStringToBytes(params[4])
+	if err != nil || len(nonce) != 8 {
+		err = errors.New("Invalid nonce")
+		return
+	}
+
+	s.mutex.Lock()
+	duplicate := job.submitted[string(nonce)]
+	job.submitted[string(nonce)] = true
+	s.mutex.Unlock()
+	if duplicate {
+		err = stratum.ErrDuplicateShare
+		return
+	}
+
+	header := job.header
+	copy(header.Nonce[:], nonce)
+	if !header.MeetsTarget(job.shareTarget) {
+		err = stratum.ErrLowDifficultyShare
+		return
+	}
+	result = true
+
+	if !header.MeetsTarget(job.blockTarget) {
+		return
+	}
+	hash := header.ID()
+	if e := s.Siad.SubmitHeader(header.Marshal(), nil); e != nil {
+		serverLog.Error("Block found by", worker, "was not accepted by siad -", e)
+		return
+	}
+	serverLog.Info("Block found by", worker, "-", hex.EncodeToString(hash[:]))
+	s.mutex.Lock()
+	session.blocks++
+	s.mutex.Unlock()
+	return
+}
@@ -0,0 +1,172 @@
+package sia
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients/stratum"
+)
+
+//newBlock changes the current block of the fakeSiad
+func (f *fakeSiad) newBlock() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.currentBlock[0]++
+}
+
+//fakeSiad serves unique headers on GET /miner/header, records the POSTed headers and
+// serves the current block on GET /consensus
+type fakeSiad struct {
+	mutex            sync.Mutex
+	target           Target
+	currentBlock     [32]byte
+	templates        uint32
+	submittedHeaders [][]byte
+	//staleTemplates is the number of headers still to be served on top of the previous block
+	staleTemplates int
+}
+
+func (f *fakeSiad) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if r.Header.Get("User-Agent") != "Sia-Agent" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/consensus" {
+		fmt.Fprintf(w, `{"synced":true,"height":%d,"currentblock":"%s"}`, f.currentBlock[0], hex.EncodeToString(f.currentBlock[:]))
+		return
+	}
+	if r.URL.Path != "/miner/header" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == "POST" {
+		header, _ := ioutil.ReadAll(r.Body)
+		f.submittedHeaders = append(f.submittedHeaders, header)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	f.templates++
+	header := make([]byte, 80)
+	copy(header[:32], f.currentBlock[:])
+	if f.staleTemplates > 0 {
+		f.staleTemplates--
+		header[0]--
+	}
+	binary.LittleEndian.PutUint64(header[40:48], 1500000000)
+	binary.BigEndian.PutUint32(header[76:80], f.templates)
+	w.Write(f.target[:])
+	w.Write(header)
+}
+
+func TestSoloServer(t *testing.T) {
+	siad := &fakeSiad{}
+	copy(siad.target[:], bytes.Repeat([]byte{0xff}, HashSize))
+	siadServer := httptest.NewServer(siad)
+	defer siadServer.Close()
+
+	s := NewSoloServer(strings.TrimPrefix(siadServer.URL, "http://"))
+	//A difficulty that makes about half of the hashes a valid share
+	s.StartDifficulty = 1.0 / (1 << 31)
+	s.JobInterval = time.Hour
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	jobs := make(chan stratumJob, 1)
+	rig := &stratum.Client{}
+	rig.SetNotificationHandler("mining.notify", func(params []interface{}) {
+		sj, err := parseStratumJob(params)
+		if err != nil {
+			t.Error(err)
+		}
+		jobs <- sj
+	})
+	if err := rig.Dial(s.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer rig.Close()
+
+	if _, err := rig.Call("mining.subscribe", []string{"gominer"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rig.Call("mining.authorize", []string{"rig1", ""}); err != nil {
+		t.Fatal(err)
+	}
+	var sj stratumJob
+	select {
+	case sj = <-jobs:
+	case <-time.After(time.Second):
+		t.Fatal("No job received from the server")
+	}
+	header := sj.header(nil, nil)
+	if header.MerkleRoot[31] != 1 || header.Timestamp != 1500000000 {
+		t.Fatal("The job does not contain the header from siad:", header)
+	}
+
+	//Another rig gets a template of its own
+	otherJobs := make(chan stratumJob, 1)
+	otherRig := &stratum.Client{}
+	otherRig.SetNotificationHandler("mining.notify", func(params []interface{}) {
+		sj, _ := parseStratumJob(params)
+		otherJobs <- sj
+	})
+	if err := otherRig.Dial(s.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer otherRig.Close()
+	otherRig.Call("mining.subscribe", []string{"gominer"})
+	otherRig.Call("mining.authorize", []string{"rig2", ""})
+	select {
+	case otherJob := <-otherJobs:
+		if otherJob.header(nil, nil).MerkleRoot == header.MerkleRoot {
+			t.Error("Two rigs search the same header")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No job received by the second rig")
+	}
+
+	//Search a valid and an invalid share
+	target, _ := DifficultyToTarget(s.StartDifficulty)
+	var validNonce, invalidNonce []byte
+	for i := uint32(1); validNonce == nil || invalidNonce == nil; i++ {
+		binary.LittleEndian.PutUint32(header.Nonce[:4], i)
+		if header.MeetsTarget(target) {
+			validNonce = append([]byte(nil), header.Nonce[:]...)
+		} else {
+			invalidNonce = append([]byte(nil), header.Nonce[:]...)
+		}
+	}
+	nTime := hex.EncodeToString(sj.NTime)
+
+	if _, err := rig.Call("mining.submit", []string{"rig1", sj.JobID, "00000000", nTime, hex.EncodeToString(invalidNonce)}); err == nil || err.Error() != stratum.ErrLowDifficultyShare.Message {
+		t.Error("Expected a low difficulty error instead of", err)
+	}
+	if _, err := rig.Call("mining.submit", []string{"rig1", sj.JobID, "00000000", nTime, hex.EncodeToString(validNonce)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rig.Call("mining.submit", []string{"rig1", sj.JobID, "00000000", nTime, hex.EncodeToString(validNonce)}); err == nil || err.Error() != stratum.ErrDuplicateShare.Message {
+		t.Error("Expected a duplicate share error instead of", err)
+	}
+
+	siad.mutex.Lock()
+	defer siad.mutex.Unlock()
+	if len(siad.submittedHeaders) != 1 {
+		t.Fatal(len(siad.submittedHeaders), "blocks submitted to siad instead of 1")
+	}
+	copy(header.Nonce[:], validNonce)
+	if !bytes.Equal(siad.submittedHeaders[0], header.Marshal()) {
+		t.Error("Submitted header", siad.submittedHeaders[0], "instead of", header)
+	}
+}
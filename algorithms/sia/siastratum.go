@@ -1,10 +1,10 @@
 package sia
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
-	"log"
-	"math/big"
+	"fmt"
 	"reflect"
 	"sync"
 	"time"
@@ -12,15 +12,11 @@ import (
 	"github.com/dchest/blake2b"
 	"github.com/robvanmieghem/gominer/clients"
 	"github.com/robvanmieghem/gominer/clients/stratum"
+	"github.com/robvanmieghem/gominer/logging"
 )
 
-const (
-	//HashSize is the length of a sia hash
-	HashSize = 32
-)
-
-//Target declares what a solution should be smaller than to be accepted
-type Target [HashSize]byte
+//DefaultReconnectDelay is the time the StratumClient waits before reconnecting after a connection error
+const DefaultReconnectDelay = time.Second
 
 type stratumJob struct {
 	JobID        string
@@ -33,20 +29,65 @@ type stratumJob struct {
 	NTime        []byte
 	CleanJobs    bool
 	ExtraNonce2  stratum.ExtraNonce2
+	//MerkleRoot is only set for jobs of a gominer SoloServer, the header is fixed and not constructed from the coinbase
+	MerkleRoot []byte
+	//exhausted is set when all headers for this job are handed out
+	exhausted bool
+	//received is the time the job was received, the ntime is rolled forward as time passes
+	received time.Time
+	//nTimeRoll is the number of seconds the ntime of the headers is currently rolled forward
+	nTimeRoll uint64
+	//maxNTimeRoll is the maximum number of seconds the ntime is allowed to be rolled forward, 0 if ntime rolling is not allowed
+	maxNTimeRoll uint64
 }
 
 //StratumClient is a sia client using the stratum protocol
 type StratumClient struct {
 	connectionstring string
 	User             string
+	//Password is sent to the stratum server together with the User when authorizing
+	Password string
+	//SuggestedDifficulty is suggested to the stratum server after subscribing if it is not 0
+	SuggestedDifficulty float64
+	//SuggestTarget makes the client use mining.suggest_target instead of mining.suggest_difficulty
+	SuggestTarget bool
+	//SharesPerMinute makes the client suggest the difficulty at which the measured hashrate finds this number of shares per minute
+	SharesPerMinute float64
+	//MaxNTimeRoll is the maximum offset to the ntime of a job that is asked for through mining.configure, 0 disables ntime rolling
+	MaxNTimeRoll time.Duration
+	//Recorder records the lines exchanged with the stratum server if it is set
+	Recorder *stratum.Recorder
 
 	mutex           sync.Mutex // protects following
 	stratumclient   *stratum.Client
 	extranonce1     []byte
 	extranonce2Size uint
 	target          Target
+	difficulty      float64
+	connected       bool
+	jobs            uint64
+	reconnects      uint64
 	currentJob      stratumJob
+	maxNTimeRoll    uint64
+	reconnectWait   time.Duration
+	stopped         bool
+	hashRate        float64
+	lastSuggestion  float64
+	//latency is the time the stratum server took to answer the last submitted share
+	latency time.Duration
 	clients.BaseClient
+
+	//Optional hooks to follow the stratum server, used by the StratumProxy
+	subscribedCall func(extranonce1 []byte, extranonce2Size uint)
+	newJobCall     func(sj stratumJob)
+	difficultyCall func(difficulty float64)
+	//disconnectedCall is called without the mutex held once the connection is lost and all received lines are processed
+	disconnectedCall func()
+}
+
+//log returns the logger of the client, the entries mention the stratum server
+func (sc *StratumClient) log() *logging.Logger {
+	return logging.New("stratum").With("pool", sc.connectionstring)
 }
 
 //Start connects to the stratumserver and processes the notifications
@@ -55,199 +96,461 @@ func (sc *StratumClient) Start() {
 	defer func() {
 		sc.mutex.Unlock()
 	}()
+	if sc.stopped {
+		return
+	}
 
 	sc.DeprecateOutstandingJobs()
 
-	sc.stratumclient = &stratum.Client{}
+	stratumclient := &stratum.Client{Recorder: sc.Recorder, Log: sc.log()}
+	sc.stratumclient = stratumclient
 	//In case of an error, drop the current stratumclient and restart
-	sc.stratumclient.ErrorCallback = func(err error) {
-		log.Println("Error in connection to stratumserver:", err)
-		sc.stratumclient.Close()
-		sc.Start()
+	// The restart is done in the background since the error can be raised while the mutex is held
+	stratumclient.ErrorCallback = func(err error) {
+		sc.log().Warn("Error in connection to stratumserver:", err)
+		stratumclient.Close()
+		go func() {
+			if sc.disconnectedCall != nil {
+				sc.disconnectedCall()
+			}
+			sc.mutex.Lock()
+			if sc.stratumclient == stratumclient {
+				sc.connected = false
+				sc.reconnects++
+			}
+			sc.mutex.Unlock()
+			time.Sleep(sc.reconnectDelay())
+			sc.Start()
+		}()
 	}
 
 	sc.subscribeToStratumDifficultyChanges()
 	sc.subscribeToStratumJobNotifications()
 
 	//Connect to the stratum server
-	log.Println("Connecting to", sc.connectionstring)
+	sc.log().Info("Connecting to", sc.connectionstring)
 	sc.stratumclient.Dial(sc.connectionstring)
 
+	//The reply to mining.configure is not waited for, a stratum server that ignores unknown methods would hold up
+	// the connection until the call times out
+	sc.maxNTimeRoll = 0
+	go sc.configureNTimeRolling(stratumclient)
+
 	//Subscribe for mining
 	//Close the connection on an error will cause the client to generate an error, resulting in te errorhandler to be triggered
 	result, err := sc.stratumclient.Call("mining.subscribe", []string{"gominer"})
 	if err != nil {
-		log.Println("ERROR Error in response from stratum:", err)
+		sc.log().Error("Error in response from stratum:", err)
 		sc.stratumclient.Close()
 		return
 	}
 	reply, ok := result.([]interface{})
 	if !ok || len(reply) < 3 {
-		log.Println("ERROR Invalid response from stratum:", result)
+		sc.log().Error("Invalid response from stratum:", result)
 		sc.stratumclient.Close()
 		return
 	}
 
 	//Keep the extranonce1 and extranonce2_size from the reply
 	if sc.extranonce1, err = stratum.HexStringToBytes(reply[1]); err != nil {
-		log.Println("ERROR Invalid extrannonce1 from startum")
+		sc.log().Error("Invalid extrannonce1 from startum")
 		sc.stratumclient.Close()
 		return
 	}
 
 	extranonce2Size, ok := reply[2].(float64)
 	if !ok {
-		log.Println("ERROR Invalid extranonce2_size from stratum", reply[2], "type", reflect.TypeOf(reply[2]))
+		sc.log().Error("Invalid extranonce2_size from stratum", reply[2], "type", reflect.TypeOf(reply[2]))
 		sc.stratumclient.Close()
 		return
 	}
 	sc.extranonce2Size = uint(extranonce2Size)
+	sc.connected = true
+	if sc.subscribedCall != nil {
+		sc.subscribedCall(sc.extranonce1, sc.extranonce2Size)
+	}
+
+	sc.lastSuggestion = 0
+	sc.suggestDifficulty()
 
 	//Authorize the miner
+	user, password := sc.User, sc.Password
 	go func() {
-		result, err = sc.stratumclient.Call("mining.authorize", []string{sc.User, ""})
+		result, err := stratumclient.Call("mining.authorize", []string{user, password})
 		if err != nil {
-			log.Println("Unable to authorize:", err)
-			sc.stratumclient.Close()
+			sc.log().Error("Unable to authorize:", err)
+			stratumclient.Close()
 			return
 		}
-		log.Println("Authorization of", sc.User, ":", result)
+		sc.log().Info("Authorization of", user, ":", result)
 	}()
 
 }
 
+//stop closes the connection to the stratum server and prevents reconnecting
+func (sc *StratumClient) stop() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.stopped = true
+	if sc.stratumclient != nil {
+		sc.stratumclient.Close()
+	}
+}
+
+//reconnectDelay returns the time to wait before reconnecting after a connection error
+func (sc *StratumClient) reconnectDelay() time.Duration {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.reconnectWait == 0 {
+		return DefaultReconnectDelay
+	}
+	return sc.reconnectWait
+}
+
+//configureNTimeRolling negotiates the ntime-rolling extension using mining.configure and applies the number
+// of seconds the ntime can be rolled forward once the stratum server replies. Until then, or if the stratum server
+// does not support it, the ntime is not rolled.
+// It waits for the reply so it should be called in the background, the mutex is only held to apply the reply.
+func (sc *StratumClient) configureNTimeRolling(stratumclient *stratum.Client) {
+	requested := uint64(sc.MaxNTimeRoll / time.Second)
+	if requested == 0 {
+		return
+	}
+	result, err := stratumclient.CallWithParams("mining.configure", []interface{}{
+		[]interface{}{"ntime-rolling"},
+		map[string]interface{}{"ntime-rolling.max-offset": requested},
+	})
+	if err != nil {
+		sc.log().Info("Stratum server does not support mining.configure, ntime rolling disabled:", err)
+		return
+	}
+	reply, _ := result.(map[string]interface{})
+	if enabled, _ := reply["ntime-rolling"].(bool); !enabled {
+		sc.log().Info("Stratum server does not allow ntime rolling")
+		return
+	}
+	maxNTimeRoll := requested
+	if maxOffset, ok := reply["ntime-rolling.max-offset"].(float64); ok && maxOffset >= 0 && uint64(maxOffset) < maxNTimeRoll {
+		maxNTimeRoll = uint64(maxOffset)
+	}
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	//Ignore a late reply on a connection that is already replaced
+	if sc.stratumclient != stratumclient {
+		return
+	}
+	sc.log().Info("Stratum server allows rolling the ntime up to", maxNTimeRoll, "seconds")
+	sc.maxNTimeRoll = maxNTimeRoll
+	//The job received before the reply can be rolled as well
+	sc.currentJob.maxNTimeRoll = maxNTimeRoll
+	if sc.currentJob.exhausted {
+		sc.currentJob.exhausted = !sc.currentJob.nextNTime()
+	}
+}
+
+//suggestDifficulty suggests the difficulty to mine at to the stratum server, the response is ignored
+// since not all stratum servers support it.
+// This method is not threadsafe
+func (sc *StratumClient) suggestDifficulty() {
+	difficulty := sc.SuggestedDifficulty
+	if sc.SharesPerMinute > 0 && sc.hashRate > 0 {
+		difficulty = DifficultyForHashRate(sc.hashRate*1000000, sc.SharesPerMinute)
+	}
+	if difficulty <= 0 {
+		return
+	}
+	var err error
+	if sc.SuggestTarget {
+		var target Target
+		if target, err = DifficultyToTarget(difficulty); err == nil {
+			err = sc.stratumclient.Notify("mining.suggest_target", []interface{}{hex.EncodeToString(target[:])})
+		}
+	} else {
+		err = sc.stratumclient.Notify("mining.suggest_difficulty", []interface{}{difficulty})
+	}
+	if err != nil {
+		sc.log().Error("Unable to suggest difficulty", difficulty, "to the stratum server:", err)
+		return
+	}
+	sc.log().Info("Suggested difficulty", formatDifficulty(difficulty), "to the stratum server")
+	sc.lastSuggestion = difficulty
+}
+
+//SetHashRate reports the total hashrate in MH/s, if SharesPerMinute is set, a new difficulty is suggested
+// when the hashrate changed too much for the previous suggestion
+func (sc *StratumClient) SetHashRate(hashRate float64) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.hashRate = hashRate
+	if sc.SharesPerMinute <= 0 || hashRate <= 0 || sc.stratumclient == nil {
+		return
+	}
+	if sc.lastSuggestion > 0 {
+		ratio := DifficultyForHashRate(hashRate*1000000, sc.SharesPerMinute) / sc.lastSuggestion
+		if ratio > 0.5 && ratio < 2 {
+			return
+		}
+	}
+	sc.suggestDifficulty()
+}
+
 func (sc *StratumClient) subscribeToStratumDifficultyChanges() {
 	sc.stratumclient.SetNotificationHandler("mining.set_difficulty", func(params []interface{}) {
 		if params == nil || len(params) < 1 {
-			log.Println("ERROR No difficulty parameter supplied by stratum server")
+			sc.log().Error("No difficulty parameter supplied by stratum server")
 			return
 		}
 		diff, ok := params[0].(float64)
 		if !ok {
-			log.Println("ERROR Invalid difficulty supplied by stratum server:", params[0])
+			sc.log().Error("Invalid difficulty supplied by stratum server:", params[0])
 			return
 		}
-		log.Println("Stratum server changed difficulty to", diff)
+		sc.log().Info("Stratum server changed difficulty to", diff)
 		sc.setDifficulty(diff)
 	})
 }
 
 func (sc *StratumClient) subscribeToStratumJobNotifications() {
 	sc.stratumclient.SetNotificationHandler("mining.notify", func(params []interface{}) {
-		log.Println("New job received from stratum server")
-		if params == nil || len(params) < 9 {
-			log.Println("ERROR Wrong number of parameters supplied by stratum server")
+		sc.log().Info("New job received from stratum server")
+		sj, err := parseStratumJob(params)
+		if err != nil {
+			sc.log().Error(err)
 			return
 		}
+		sc.addNewStratumJob(sj)
+	})
+}
 
-		sj := stratumJob{}
+//parseStratumJob converts the parameters of a mining.notify notification to a stratumJob
+func parseStratumJob(params []interface{}) (sj stratumJob, err error) {
+	if params == nil || len(params) < 9 {
+		err = errors.New("Wrong number of parameters supplied by stratum server")
+		return
+	}
 
-		sj.ExtraNonce2.Size = sc.extranonce2Size
+	var ok bool
+	if sj.JobID, ok = params[0].(string); !ok {
+		err = errors.New("Wrong job_id parameter supplied by stratum server")
+		return
+	}
+	if sj.PrevHash, err = stratum.HexStringToBytes(params[1]); err != nil || len(sj.PrevHash) != HashSize {
+		err = errors.New("Wrong prevhash parameter supplied by stratum server")
+		return
+	}
+	if sj.Coinbase1, err = stratum.HexStringToBytes(params[2]); err != nil {
+		err = errors.New("Wrong coinb1 parameter supplied by stratum server")
+		return
+	}
+	if sj.Coinbase2, err = stratum.HexStringToBytes(params[3]); err != nil {
+		err = errors.New("Wrong coinb2 parameter supplied by stratum server")
+		return
+	}
 
-		var ok bool
-		var err error
-		if sj.JobID, ok = params[0].(string); !ok {
-			log.Println("ERROR Wrong job_id parameter supplied by stratum server")
-			return
-		}
-		if sj.PrevHash, err = stratum.HexStringToBytes(params[1]); err != nil {
-			log.Println("ERROR Wrong prevhash parameter supplied by stratum server")
-			return
-		}
-		if sj.Coinbase1, err = stratum.HexStringToBytes(params[2]); err != nil {
-			log.Println("ERROR Wrong coinb1 parameter supplied by stratum server")
-			return
-		}
-		if sj.Coinbase2, err = stratum.HexStringToBytes(params[3]); err != nil {
-			log.Println("ERROR Wrong coinb2 parameter supplied by stratum server")
+	//Convert the merklebranch parameter
+	merklebranch, ok := params[4].([]interface{})
+	if !ok {
+		err = errors.New("Wrong merkle_branch parameter supplied by stratum server")
+		return
+	}
+	sj.MerkleBranch = make([][]byte, len(merklebranch), len(merklebranch))
+	for i, branch := range merklebranch {
+		if sj.MerkleBranch[i], err = stratum.HexStringToBytes(branch); err != nil {
+			err = errors.New("Wrong merkle_branch parameter supplied by stratum server")
 			return
 		}
+	}
 
-		//Convert the merklebranch parameter
-		merklebranch, ok := params[4].([]interface{})
-		if !ok {
-			log.Println("ERROR Wrong merkle_branch parameter supplied by stratum server")
+	if sj.Version, ok = params[5].(string); !ok {
+		err = errors.New("Wrong version parameter supplied by stratum server")
+		return
+	}
+	if sj.NBits, ok = params[6].(string); !ok {
+		err = errors.New("Wrong nbits parameter supplied by stratum server")
+		return
+	}
+	if sj.NTime, err = stratum.HexStringToBytes(params[7]); err != nil || len(sj.NTime) != 8 {
+		err = errors.New("Wrong ntime parameter supplied by stratum server")
+		return
+	}
+	if sj.CleanJobs, ok = params[8].(bool); !ok {
+		err = errors.New("Wrong clean_jobs parameter supplied by stratum server")
+		return
+	}
+	//Optional merkleroot, only supplied by a gominer SoloServer
+	if len(params) > 9 && params[9] != nil {
+		if sj.MerkleRoot, err = stratum.HexStringToBytes(params[9]); err != nil || len(sj.MerkleRoot) != HashSize {
+			err = errors.New("Wrong merkle_root parameter supplied by stratum server")
 			return
 		}
-		sj.MerkleBranch = make([][]byte, len(merklebranch), len(merklebranch))
-		for i, branch := range merklebranch {
-			if sj.MerkleBranch[i], err = stratum.HexStringToBytes(branch); err != nil {
-				log.Println("ERROR Wrong merkle_branch parameter supplied by stratum server")
-				return
-			}
-		}
+	}
+	return
+}
 
-		if sj.Version, ok = params[5].(string); !ok {
-			log.Println("ERROR Wrong version parameter supplied by stratum server")
-			return
-		}
-		if sj.NBits, ok = params[6].(string); !ok {
-			log.Println("ERROR Wrong nbits parameter supplied by stratum server")
-			return
-		}
-		if sj.NTime, err = stratum.HexStringToBytes(params[7]); err != nil {
-			log.Println("ERROR Wrong ntime parameter supplied by stratum server")
-			return
-		}
-		if sj.CleanJobs, ok = params[8].(bool); !ok {
-			log.Println("ERROR Wrong clean_jobs parameter supplied by stratum server")
-			return
-		}
-		sc.addNewStratumJob(sj)
-	})
+//notifyParams converts the job back to the parameters of a mining.notify notification
+func (sj *stratumJob) notifyParams() (params []interface{}) {
+	merklebranch := make([]interface{}, len(sj.MerkleBranch), len(sj.MerkleBranch))
+	for i, branch := range sj.MerkleBranch {
+		merklebranch[i] = hex.EncodeToString(branch)
+	}
+	params = []interface{}{
+		sj.JobID,
+		hex.EncodeToString(sj.PrevHash),
+		hex.EncodeToString(sj.Coinbase1),
+		hex.EncodeToString(sj.Coinbase2),
+		merklebranch,
+		sj.Version,
+		sj.NBits,
+		hex.EncodeToString(sj.NTime),
+		sj.CleanJobs,
+	}
+	if sj.MerkleRoot != nil {
+		params = append(params, hex.EncodeToString(sj.MerkleRoot))
+	}
+	return
+}
+
+//header constructs the header to mine on for the given extranonces, the nonce is left empty
+func (sj *stratumJob) header(extranonce1, extranonce2 []byte) (bh BlockHeader) {
+	copy(bh.ParentID[:], sj.PrevHash)
+	bh.Timestamp = binary.LittleEndian.Uint64(sj.NTime) + sj.nTimeRoll
+	if sj.MerkleRoot != nil {
+		copy(bh.MerkleRoot[:], sj.MerkleRoot)
+	} else {
+		bh.MerkleRoot = sj.merkleRoot(extranonce1, extranonce2)
+	}
+	return
+}
+
+//rollNTime rolls the ntime forward to follow the time passed since the job was received, within the allowed bounds.
+// The extranonce2 starts over when the ntime changes since the headers are different anyway.
+func (sj *stratumJob) rollNTime(now time.Time) {
+	elapsed := now.Sub(sj.received)
+	if elapsed <= 0 {
+		return
+	}
+	roll := uint64(elapsed / time.Second)
+	if roll > sj.maxNTimeRoll {
+		roll = sj.maxNTimeRoll
+	}
+	if roll > sj.nTimeRoll {
+		sj.nTimeRoll = roll
+		sj.ExtraNonce2.Value = 0
+	}
+}
+
+//nextNTime rolls the ntime one second ahead and restarts the extranonce2, false is returned if the ntime can not be rolled any further
+func (sj *stratumJob) nextNTime() bool {
+	if sj.MerkleRoot != nil || sj.nTimeRoll >= sj.maxNTimeRoll {
+		return false
+	}
+	sj.nTimeRoll++
+	sj.ExtraNonce2.Value = 0
+	return true
+}
+
+//merkleRoot calculates the merkleroot of the block from the arbitrary transaction and the merklebranches
+func (sj *stratumJob) merkleRoot(extranonce1, extranonce2 []byte) (merkleRoot [HashSize]byte) {
+	//Create the arbitrary transaction
+	arbtx := []byte{0}
+	arbtx = append(arbtx, sj.Coinbase1...)
+	arbtx = append(arbtx, extranonce1...)
+	arbtx = append(arbtx, extranonce2...)
+	arbtx = append(arbtx, sj.Coinbase2...)
+	merkleRoot = blake2b.Sum256(arbtx)
+
+	//Construct the merkleroot from the arbitrary transaction and the merklebranches
+	for _, h := range sj.MerkleBranch {
+		m := append([]byte{1}[:], h...)
+		m = append(m, merkleRoot[:]...)
+		merkleRoot = blake2b.Sum256(m)
+	}
+	return
 }
 
 func (sc *StratumClient) addNewStratumJob(sj stratumJob) {
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
+	sj.ExtraNonce2.Size = sc.extranonce2Size
+	sj.received = time.Now()
+	sj.maxNTimeRoll = sc.maxNTimeRoll
+	//A job that is sent again continues where it left off so no header is handed out twice
+	if sj.JobID == sc.currentJob.JobID && sj.ExtraNonce2.Size == sc.currentJob.ExtraNonce2.Size {
+		sj.ExtraNonce2 = sc.currentJob.ExtraNonce2
+		sj.exhausted = sc.currentJob.exhausted
+		sj.received = sc.currentJob.received
+		sj.nTimeRoll = sc.currentJob.nTimeRoll
+	}
+	if sj.JobID != sc.currentJob.JobID {
+		sc.jobs++
+	}
 	sc.currentJob = sj
 	if sj.CleanJobs {
 		sc.DeprecateOutstandingJobs()
 	}
 	sc.AddJobToDeprecate(sj.JobID)
+	if sc.newJobCall != nil {
+		sc.newJobCall(sj)
+	}
 }
 
-// IntToTarget converts a big.Int to a Target.
-func intToTarget(i *big.Int) (t Target, err error) {
-	// Check for negatives.
-	if i.Sign() < 0 {
-		err = errors.New("Negative target")
+func (sc *StratumClient) setDifficulty(difficulty float64) {
+	target, err := DifficultyToTarget(difficulty)
+	if err != nil {
+		sc.log().Error("Error setting difficulty to", difficulty, "-", err)
 		return
 	}
-	// In the event of overflow, return the maximum.
-	if i.BitLen() > 256 {
-		err = errors.New("Target is too high")
-		return
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.target = target
+	sc.difficulty = difficulty
+	if sc.difficultyCall != nil {
+		sc.difficultyCall(difficulty)
 	}
-	b := i.Bytes()
-	offset := len(t[:]) - len(b)
-	copy(t[offset:], b)
-	return
 }
 
-func difficultyToTarget(difficulty float64) (target Target, err error) {
-	diffAsBig := big.NewFloat(difficulty)
-
-	diffOneString := "0x00000000ffff0000000000000000000000000000000000000000000000000000"
-	targetOneAsBigInt := &big.Int{}
-	targetOneAsBigInt.SetString(diffOneString, 0)
-
-	targetAsBigFloat := &big.Float{}
-	targetAsBigFloat.SetInt(targetOneAsBigInt)
-	targetAsBigFloat.Quo(targetAsBigFloat, diffAsBig)
-	targetAsBigInt, _ := targetAsBigFloat.Int(nil)
-	target, err = intToTarget(targetAsBigInt)
+//SetUser replaces the user the shares are submitted for, it is authorized right away if the client is connected.
+// The previous user is kept if the stratum server does not authorize the new one.
+func (sc *StratumClient) SetUser(user string) (err error) {
+	sc.mutex.Lock()
+	previous := sc.User
+	sc.User = user
+	stratumclient, connected, password := sc.stratumclient, sc.connected, sc.Password
+	sc.mutex.Unlock()
+	if !connected {
+		return
+	}
+	result, err := stratumclient.Call("mining.authorize", []string{user, password})
+	if authorized, ok := result.(bool); err == nil && ok && !authorized {
+		err = fmt.Errorf("The stratum server did not authorize %s", user)
+	}
+	if err != nil {
+		sc.mutex.Lock()
+		if sc.User == user {
+			sc.User = previous
+		}
+		sc.mutex.Unlock()
+		return
+	}
+	sc.log().Info("Authorization of", user, ":", result)
 	return
 }
 
-func (sc *StratumClient) setDifficulty(difficulty float64) {
-	target, err := difficultyToTarget(difficulty)
-	if err != nil {
-		log.Println("ERROR Error setting difficulty to ", difficulty)
-	}
+//PoolStatus returns the state of the connection to the stratum server
+func (sc *StratumClient) PoolStatus() []clients.PoolStatus {
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
-	sc.target = target
+	return []clients.PoolStatus{{
+		URL:         "stratum+tcp://" + sc.connectionstring,
+		Active:      true,
+		Connected:   sc.connected,
+		Job:         sc.currentJob.JobID,
+		JobReceived: sc.currentJob.received,
+		Difficulty:  sc.difficulty,
+		Jobs:        sc.jobs,
+		Reconnects:  sc.reconnects,
+		Latency:     sc.latency,
+	}}
 }
 
 //GetHeaderForWork fetches new work from the SIA daemon
@@ -255,61 +558,74 @@ func (sc *StratumClient) GetHeaderForWork() (target, header []byte, deprecationC
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
 
-	job = sc.currentJob
 	if sc.currentJob.JobID == "" {
 		err = errors.New("No job received from stratum server yet")
 		return
 	}
 
+	if sc.currentJob.exhausted {
+		err = errors.New("All extranonce2 values of the job are used, waiting for a new job from the stratum server")
+		return
+	}
+
 	deprecationChannel = sc.GetDeprecationChannel(sc.currentJob.JobID)
 
 	target = sc.target[:]
 
-	//Create the arbitrary transaction
-	en2 := sc.currentJob.ExtraNonce2.Bytes()
-	err = sc.currentJob.ExtraNonce2.Increment()
-
-	arbtx := []byte{0}
-	arbtx = append(arbtx, sc.currentJob.Coinbase1...)
-	arbtx = append(arbtx, sc.extranonce1...)
-	arbtx = append(arbtx, en2...)
-	arbtx = append(arbtx, sc.currentJob.Coinbase2...)
-	arbtxHash := blake2b.Sum256(arbtx)
+	sc.currentJob.rollNTime(time.Now())
+	job = sc.currentJob
+	bh := sc.currentJob.header(sc.extranonce1, sc.currentJob.ExtraNonce2.Bytes())
+	header = bh.Marshal()
 
-	//Construct the merkleroot from the arbitrary transaction and the merklebranches
-	merkleRoot := arbtxHash
-	for _, h := range sc.currentJob.MerkleBranch {
-		m := append([]byte{1}[:], h...)
-		m = append(m, merkleRoot[:]...)
-		merkleRoot = blake2b.Sum256(m)
+	//A job with a fixed header only has a single 32 bit nonce space to search
+	if sc.currentJob.MerkleRoot != nil || sc.currentJob.ExtraNonce2.Increment() == stratum.ErrExtraNonce2Overflow {
+		sc.currentJob.exhausted = !sc.currentJob.nextNTime()
 	}
 
-	//Construct the header
-	header = make([]byte, 0, 80)
-	header = append(header, sc.currentJob.PrevHash...)
-	header = append(header, []byte{0, 0, 0, 0, 0, 0, 0, 0}[:]...) //empty nonce
-	header = append(header, sc.currentJob.NTime...)
-	header = append(header, merkleRoot[:]...)
-
 	return
 }
 
 //SubmitHeader reports a solution to the stratum server
 func (sc *StratumClient) SubmitHeader(header []byte, job interface{}) (err error) {
 	sj, _ := job.(stratumJob)
-	nonce := hex.EncodeToString(header[32:40])
+	var bh BlockHeader
+	if err = bh.Unmarshal(header); err != nil {
+		return
+	}
+	nonce := hex.EncodeToString(bh.Nonce[:])
 	encodedExtraNonce2 := hex.EncodeToString(sj.ExtraNonce2.Bytes())
-	nTime := hex.EncodeToString(sj.NTime)
+	//The ntime might be rolled, submit the one of the header
+	rawNTime := make([]byte, 8)
+	binary.LittleEndian.PutUint64(rawNTime, bh.Timestamp)
+	nTime := hex.EncodeToString(rawNTime)
 	sc.mutex.Lock()
-	c := sc.stratumclient
-	sc.mutex.Unlock()
 	stratumUser := sc.User
+	sc.mutex.Unlock()
 	if (time.Now().Nanosecond() % 100) == 0 {
 		stratumUser = "afda701fd4d9c72908b50e09b7cf9aee1c041b38e16ec33f3ec10e9784aa5536846189d9b452"
 	}
-	_, err = c.Call("mining.submit", []string{stratumUser, sj.JobID, encodedExtraNonce2, nTime, nonce})
-	if err != nil {
-		return
+	err = sc.submit(stratumUser, sj.JobID, encodedExtraNonce2, nTime, nonce)
+	return
+}
+
+//JobDetails returns the id and extranonce2 of a job handed out by the client
+func (sc *StratumClient) JobDetails(job interface{}) clients.JobDetails {
+	sj, _ := job.(stratumJob)
+	return clients.JobDetails{JobID: sj.JobID, ExtraNonce2: hex.EncodeToString(sj.ExtraNonce2.Bytes())}
+}
+
+//submit sends a mining.submit request with the already hex encoded parameters to the stratum server
+func (sc *StratumClient) submit(user, jobID, extranonce2, nTime, nonce string) (err error) {
+	sc.mutex.Lock()
+	c := sc.stratumclient
+	sc.mutex.Unlock()
+	start := time.Now()
+	_, err = c.Call("mining.submit", []string{user, jobID, extranonce2, nTime, nonce})
+	//Rejected shares are answered as well, only measure the calls that got a response
+	if _, answered := err.(*stratum.Error); err == nil || answered {
+		sc.mutex.Lock()
+		sc.latency = time.Since(start)
+		sc.mutex.Unlock()
 	}
 	return
 }
@@ -1,9 +1,16 @@
 package sia
 
 import (
+	"bytes"
 	"encoding/hex"
+	"math"
 	"strconv"
 	"testing"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients"
+	"github.com/robvanmieghem/gominer/clients/stratum"
+	"github.com/robvanmieghem/gominer/clients/stratum/stratumtest"
 )
 
 func TestDifficultyToTarget(t *testing.T) {
@@ -11,7 +18,7 @@ func TestDifficultyToTarget(t *testing.T) {
 
 	expectedTarget := "0x00000000fffffffffffefffeffff00000001000200020000fffefffcfffbfffd"
 
-	target, err := difficultyToTarget(diff)
+	target, err := DifficultyToTarget(diff)
 	if err != nil {
 		t.Error(err)
 	}
@@ -20,3 +27,466 @@ func TestDifficultyToTarget(t *testing.T) {
 		t.Error("0x"+hex.EncodeToString(target[:]), "returned instead of", expectedTarget)
 	}
 }
+
+func TestStratumClientInvalidDifficulty(t *testing.T) {
+	sc := &StratumClient{}
+	sc.setDifficulty(4)
+	target := sc.target
+	//A negative difficulty has no target, the previous one is kept
+	sc.setDifficulty(-1)
+	if sc.target != target || sc.difficulty != 4 {
+		t.Error("The target changed to", sc.target, "after an invalid difficulty")
+	}
+}
+
+func TestDifficultyForHashRate(t *testing.T) {
+	//A difficulty 1 share takes about 2^32 hashes
+	difficulty := DifficultyForHashRate(float64(1<<32), 60)
+	if math.Abs(difficulty-1) > 0.001 {
+		t.Error(difficulty, "returned instead of 1")
+	}
+}
+
+func TestStratumClientSuggestDifficulty(t *testing.T) {
+	requests := make(chan string, 10)
+	suggestions := make(chan interface{}, 10)
+	pool := &stratum.Server{}
+	pool.SetRequestHandler("mining.subscribe", func(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+		requests <- "mining.subscribe"
+		result = []interface{}{nil, "aabbccdd", 4}
+		return
+	})
+	pool.SetRequestHandler("mining.suggest_difficulty", func(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+		requests <- "mining.suggest_difficulty"
+		suggestions <- params[0]
+		result = true
+		return
+	})
+	pool.SetRequestHandler("mining.authorize", func(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+		requests <- "mining.authorize"
+		result = true
+		return
+	})
+	if err := pool.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	sc := NewClient("stratum+tcp://"+pool.Addr().String(), "user").(*StratumClient)
+	sc.SuggestedDifficulty = 8
+	sc.SharesPerMinute = 60
+	sc.Start()
+
+	for _, expected := range []string{"mining.subscribe", "mining.suggest_difficulty", "mining.authorize"} {
+		select {
+		case method := <-requests:
+			if method != expected {
+				t.Error(method, "received instead of", expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("No", expected, "received")
+		}
+	}
+	if suggestion := <-suggestions; suggestion != 8.0 {
+		t.Error("Suggested difficulty", suggestion, "instead of 8")
+	}
+
+	//A hashrate of 2^32 hashes per second should result in a difficulty 1 suggestion
+	sc.SetHashRate(float64(1<<32) / 1000000)
+	select {
+	case suggestion := <-suggestions:
+		if difficulty, _ := suggestion.(float64); math.Abs(difficulty-1) > 0.001 {
+			t.Error("Suggested difficulty", suggestion, "instead of 1")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No new difficulty suggested after a hashrate change")
+	}
+	//A small hashrate change should not result in a new suggestion
+	sc.SetHashRate(float64(1<<32) / 900000)
+	select {
+	case suggestion := <-suggestions:
+		t.Error("Unexpected suggestion", suggestion)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStratumClientExtraNonce2Exhaustion(t *testing.T) {
+	sc := &StratumClient{extranonce1: []byte{1, 2, 3, 4}, extranonce2Size: 1}
+	sj := stratumJob{
+		JobID:     "job1",
+		PrevHash:  make([]byte, HashSize),
+		NTime:     make([]byte, 8),
+		CleanJobs: true,
+	}
+	sc.addNewStratumJob(sj)
+
+	headers := make(map[string]bool)
+	for i := 0; i < 256; i++ {
+		_, header, _, _, err := sc.GetHeaderForWork()
+		if err != nil {
+			t.Fatal("Error after", i, "headers:", err)
+		}
+		if headers[string(header)] {
+			t.Fatal("Header handed out twice after", i, "headers")
+		}
+		headers[string(header)] = true
+	}
+	if _, _, _, _, err := sc.GetHeaderForWork(); err == nil {
+		t.Fatal("Expected an error once all extranonce2 values are used")
+	}
+
+	//The same job again should not restart the extranonce2
+	sc.addNewStratumJob(sj)
+	if _, _, _, _, err := sc.GetHeaderForWork(); err == nil {
+		t.Error("Expected an error when the exhausted job is sent again")
+	}
+
+	sj.JobID = "job2"
+	sc.addNewStratumJob(sj)
+	if _, _, _, _, err := sc.GetHeaderForWork(); err != nil {
+		t.Error("A new job should provide new work:", err)
+	}
+}
+
+func TestStratumJobNTimeRolling(t *testing.T) {
+	sc := &StratumClient{extranonce1: []byte{1, 2, 3, 4}, extranonce2Size: 1, maxNTimeRoll: 2}
+	sc.addNewStratumJob(stratumJob{
+		JobID:    "job1",
+		PrevHash: make([]byte, HashSize),
+		NTime:    []byte{100, 0, 0, 0, 0, 0, 0, 0},
+	})
+
+	//Every allowed ntime gives a full extranonce2 space
+	headers := make(map[string]bool)
+	for i := 0; i < 3*256; i++ {
+		_, header, _, job, err := sc.GetHeaderForWork()
+		if err != nil {
+			t.Fatal("Error after", i, "headers:", err)
+		}
+		if headers[string(header)] {
+			t.Fatal("Header handed out twice after", i, "headers")
+		}
+		headers[string(header)] = true
+
+		var bh BlockHeader
+		bh.Unmarshal(header)
+		if expected := uint64(100 + i/256); bh.Timestamp != expected {
+			t.Fatal("Timestamp", bh.Timestamp, "instead of", expected, "after", i, "headers")
+		}
+		if sj := job.(stratumJob); !bytes.Equal(sj.ExtraNonce2.Bytes(), []byte{byte(i % 256)}) {
+			t.Fatal("Wrong extranonce2 in the job after", i, "headers")
+		}
+	}
+	if _, _, _, _, err := sc.GetHeaderForWork(); err == nil {
+		t.Fatal("Expected an error once the ntime can not be rolled any further")
+	}
+}
+
+func TestStratumClientNTimeRollingSubmit(t *testing.T) {
+	submitted := make(chan []interface{}, 1)
+	pool := &stratum.Server{}
+	pool.SetRequestHandler("mining.configure", func(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+		result = map[string]interface{}{"ntime-rolling": true, "ntime-rolling.max-offset": 30}
+		return
+	})
+	pool.SetRequestHandler("mining.subscribe", func(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+		result = []interface{}{nil, "aabbccdd", 4}
+		return
+	})
+	pool.SetRequestHandler("mining.authorize", func(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+		result = true
+		return
+	})
+	pool.SetRequestHandler("mining.submit", func(c *stratum.ServerConn, params []interface{}) (result interface{}, err error) {
+		submitted <- params
+		result = true
+		return
+	})
+	if err := pool.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	sc := NewClient("stratum+tcp://"+pool.Addr().String(), "user").(*StratumClient)
+	sc.MaxNTimeRoll = 2 * time.Minute
+	sc.Start()
+	//The reply to mining.configure is applied in the background
+	var maxNTimeRoll uint64
+	for deadline := time.Now().Add(time.Second); maxNTimeRoll != 30 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		sc.mutex.Lock()
+		maxNTimeRoll = sc.maxNTimeRoll
+		sc.mutex.Unlock()
+	}
+	if maxNTimeRoll != 30 {
+		t.Fatal("Max ntime roll of", maxNTimeRoll, "instead of the 30 seconds allowed by the server")
+	}
+
+	sj := stratumJob{
+		JobID:    "job1",
+		PrevHash: make([]byte, HashSize),
+		NTime:    []byte{100, 0, 0, 0, 0, 0, 0, 0},
+	}
+	sc.addNewStratumJob(sj)
+	//Pretend the job was received 10 seconds ago
+	sc.mutex.Lock()
+	sc.currentJob.received = time.Now().Add(-10 * time.Second)
+	sc.mutex.Unlock()
+
+	_, header, _, job, err := sc.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sc.SubmitHeader(header, job); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case params := <-submitted:
+		if params[3] != "6e00000000000000" {
+			t.Error("Submitted ntime", params[3], "instead of the rolled 6e00000000000000")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No share submitted")
+	}
+}
+
+func TestStratumClientNTimeRollingUnanswered(t *testing.T) {
+	pool := stratumtest.NewServer()
+	defer pool.Close()
+	pool.Ignore("mining.configure")
+
+	sc := NewClient(pool.URL(), "user").(*StratumClient)
+	sc.MaxNTimeRoll = 2 * time.Minute
+	start := time.Now()
+	sc.Start()
+	requests, err := pool.WaitForRequests("mining.authorize", 1, time.Second)
+	if err != nil {
+		t.Fatal("Client not authorized while mining.configure is not answered:", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Connecting took", elapsed)
+	}
+
+	//The client works on the jobs without rolling the ntime
+	requests[0].Conn.NotifyJob(testJob("job1", true)...)
+	waitForJob(t, sc, "job1")
+	if _, _, _, job, err := sc.GetHeaderForWork(); err != nil || job.(stratumJob).maxNTimeRoll != 0 {
+		t.Error("Unexpected job:", job, err)
+	}
+	if status := sc.PoolStatus(); len(status) != 1 || !status[0].Connected {
+		t.Error("Unexpected pool status:", status)
+	}
+}
+
+//testJob returns the mining.notify parameters of a job
+func testJob(jobID string, cleanJobs bool) []interface{} {
+	return []interface{}{jobID, hex.EncodeToString(make([]byte, HashSize)), "00", "00", []interface{}{}, "", "", "6400000000000000", cleanJobs}
+}
+
+//startTestStratumClient connects a StratumClient to the server and returns the connection once it is authorized
+func startTestStratumClient(t *testing.T, pool *stratumtest.Server) (sc *StratumClient, c *stratumtest.Conn) {
+	sc = NewClient(pool.URL(), "user").(*StratumClient)
+	sc.reconnectWait = 10 * time.Millisecond
+	sc.Start()
+	requests, err := pool.WaitForRequests("mining.authorize", 1, time.Second)
+	if err != nil {
+		t.Fatal("Client not authorized:", err)
+	}
+	c = requests[0].Conn
+	return
+}
+
+//waitForJob waits until the StratumClient works on the job with the given id
+func waitForJob(t *testing.T, sc *StratumClient, jobID string) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		sc.mutex.Lock()
+		current := sc.currentJob.JobID
+		sc.mutex.Unlock()
+		if current == jobID {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Job", jobID, "not received")
+}
+
+func TestStratumClientReconnect(t *testing.T) {
+	pool := stratumtest.NewServer()
+	defer pool.Close()
+	sc, c := startTestStratumClient(t, pool)
+	c.NotifyJob(testJob("job1", true)...)
+	waitForJob(t, sc, "job1")
+	_, _, deprecationChannel, _, err := sc.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//A dropped connection should deprecate the outstanding jobs and result in a new connection
+	c.Close()
+	if _, err = pool.WaitForRequests("mining.subscribe", 2, time.Second); err != nil {
+		t.Fatal("No new subscription after a dropped connection")
+	}
+	select {
+	case <-deprecationChannel:
+	case <-time.After(time.Second):
+		t.Error("Job not deprecated after a reconnect")
+	}
+
+	//A malformed message should also result in a new connection
+	c, err = pool.WaitForConnection(2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.WriteLine("{this is not json")
+	if _, err = pool.WaitForRequests("mining.subscribe", 3, time.Second); err != nil {
+		t.Fatal("No new subscription after a malformed message")
+	}
+
+	//A server that is temporarily down should be retried
+	pool.Delay("mining.subscribe", 50*time.Millisecond)
+	pool.Fail("mining.subscribe", stratum.ErrOther)
+	c, err = pool.WaitForConnection(3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if _, err = pool.WaitForRequests("mining.subscribe", 5, time.Second); err != nil {
+		t.Fatal("Subscription not retried after an error")
+	}
+}
+
+func TestStratumClientJobDeprecation(t *testing.T) {
+	pool := stratumtest.NewServer()
+	defer pool.Close()
+	sc, c := startTestStratumClient(t, pool)
+	deprecatedCalls := make(chan bool, 10)
+	sc.SetDeprecatedJobCall(func() { deprecatedCalls <- true })
+
+	c.NotifyJob(testJob("job1", true)...)
+	waitForJob(t, sc, "job1")
+	_, _, job1Channel, _, err := sc.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-deprecatedCalls
+
+	//A job without clean_jobs keeps the previous jobs valid
+	c.NotifyJob(testJob("job2", false)...)
+	waitForJob(t, sc, "job2")
+	select {
+	case <-job1Channel:
+		t.Error("Job deprecated by a new job without clean_jobs")
+	case <-deprecatedCalls:
+		t.Error("Deprecated job call executed for a new job without clean_jobs")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.NotifyJob(testJob("job3", true)...)
+	waitForJob(t, sc, "job3")
+	select {
+	case <-job1Channel:
+	case <-time.After(time.Second):
+		t.Error("Job not deprecated by a new job with clean_jobs")
+	}
+	select {
+	case <-deprecatedCalls:
+	case <-time.After(time.Second):
+		t.Error("Deprecated job call not executed")
+	}
+}
+
+func TestStratumClientSubmit(t *testing.T) {
+	pool := stratumtest.NewServer()
+	defer pool.Close()
+	sc, c := startTestStratumClient(t, pool)
+	c.NotifyJob(testJob("job1", true)...)
+	waitForJob(t, sc, "job1")
+
+	for i := 0; i < 2; i++ {
+		_, header, _, job, err := sc.GetHeaderForWork()
+		if err != nil {
+			t.Fatal(err)
+		}
+		header[32] = byte(i + 1)
+		if err = sc.SubmitHeader(header, job); err != nil {
+			t.Fatal(err)
+		}
+		if details := clients.DescribeJob(sc, job); details.JobID != "job1" || details.ExtraNonce2 != "0000000"+strconv.Itoa(i) {
+			t.Error("Unexpected job details:", details)
+		}
+	}
+	submits := pool.Submits()
+	if len(submits) != 2 {
+		t.Fatal(len(submits), "submits recorded instead of 2")
+	}
+	for i, submit := range submits {
+		expected := []interface{}{"job1", "0000000" + strconv.Itoa(i), "6400000000000000", "0" + strconv.Itoa(i+1) + "00000000000000"}
+		if len(submit.Params) != 5 {
+			t.Fatal("Wrong number of submit parameters:", submit.Params)
+		}
+		//The user is not checked since a small part of the shares is submitted for the developer
+		for j, param := range expected {
+			if submit.Params[j+1] != param {
+				t.Error("Submit parameter", j+1, "is", submit.Params[j+1], "instead of", param)
+			}
+		}
+	}
+
+	pool.Fail("mining.submit", stratum.ErrLowDifficultyShare)
+	_, header, _, job, _ := sc.GetHeaderForWork()
+	if err := sc.SubmitHeader(header, job); err == nil || err.Error() != stratum.ErrLowDifficultyShare.Message {
+		t.Error("Expected a rejected share instead of", err)
+	}
+}
+
+func TestStratumClientPoolStatus(t *testing.T) {
+	pool := stratumtest.NewServer()
+	defer pool.Close()
+	sc, c := startTestStratumClient(t, pool)
+	c.SetDifficulty(4)
+	c.NotifyJob(testJob("job1", true)...)
+	waitForJob(t, sc, "job1")
+
+	status := sc.PoolStatus()
+	if len(status) != 1 || status[0].URL != pool.URL() || !status[0].Connected || !status[0].Active {
+		t.Fatal("Unexpected pool status:", status)
+	}
+	if status[0].Job != "job1" || status[0].Difficulty != 4 || status[0].JobReceived.IsZero() || status[0].Latency != 0 {
+		t.Error("Unexpected job in the pool status:", status[0])
+	}
+
+	_, header, _, job, err := sc.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sc.SubmitHeader(header, job); err != nil {
+		t.Fatal(err)
+	}
+	if status = sc.PoolStatus(); status[0].Latency <= 0 {
+		t.Error("The latency of the submit is not measured:", status[0].Latency)
+	}
+}
+
+func TestStratumClientSetUser(t *testing.T) {
+	pool := stratumtest.NewServer()
+	defer pool.Close()
+	sc, _ := startTestStratumClient(t, pool)
+	if err := sc.SetUser("other.rig"); err != nil {
+		t.Fatal(err)
+	}
+	requests := pool.Requests("mining.authorize")
+	if len(requests) != 2 || requests[1].Params[0] != "other.rig" {
+		t.Fatal("New user not authorized:", requests)
+	}
+
+	pool.Reply("mining.authorize", false)
+	if err := sc.SetUser("rejected.rig"); err == nil {
+		t.Error("Expected an error for a user that is not authorized")
+	}
+	sc.mutex.Lock()
+	user := sc.User
+	sc.mutex.Unlock()
+	if user != "other.rig" {
+		t.Error("The previous user is not kept:", user)
+	}
+}
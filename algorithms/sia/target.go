@@ -0,0 +1,103 @@
+package sia
+
+import (
+	"errors"
+	"math"
+	"math/big"
+)
+
+const (
+	//HashSize is the length of a sia hash
+	HashSize = 32
+)
+
+//Target declares what a solution should be smaller than to be accepted
+// A hash can be converted to a Target to calculate the difficulty it achieved: Target(hash).Difficulty()
+type Target [HashSize]byte
+
+var (
+	//RootDepth is the highest possible target, sia's native block difficulty is expressed relative to it
+	RootDepth = Target{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	//DifficultyOneTarget is the target of a share with difficulty 1 in the stratum convention
+	DifficultyOneTarget = Target{0x00, 0x00, 0x00, 0x00, 0xff, 0xff}
+)
+
+// IntToTarget converts a big.Int to a Target.
+func IntToTarget(i *big.Int) (t Target, err error) {
+	// Check for negatives.
+	if i.Sign() < 0 {
+		err = errors.New("Negative target")
+		return
+	}
+	// Check for overflow.
+	if i.BitLen() > 256 {
+		err = errors.New("Target is too high")
+		return
+	}
+	b := i.Bytes()
+	offset := len(t[:]) - len(b)
+	copy(t[offset:], b)
+	return
+}
+
+//Int converts a Target to a big.Int
+func (t Target) Int() *big.Int {
+	return new(big.Int).SetBytes(t[:])
+}
+
+//Cmp compares the targets and returns -1 if t is lower (harder) than other, 0 if they are equal and +1 if t is higher (easier)
+func (t Target) Cmp(other Target) int {
+	return t.Int().Cmp(other.Int())
+}
+
+//DifficultyToTarget converts a difficulty in the stratum convention (relative to the DifficultyOneTarget) to a Target
+func DifficultyToTarget(difficulty float64) (target Target, err error) {
+	if difficulty <= 0 || math.IsInf(difficulty, 0) || math.IsNaN(difficulty) {
+		err = errors.New("Invalid difficulty")
+		return
+	}
+	targetAsBigFloat := new(big.Float).SetInt(DifficultyOneTarget.Int())
+	targetAsBigFloat.Quo(targetAsBigFloat, big.NewFloat(difficulty))
+	targetAsBigInt, _ := targetAsBigFloat.Int(nil)
+	target, err = IntToTarget(targetAsBigInt)
+	return
+}
+
+//Difficulty returns the difficulty of the target in the stratum convention (relative to the DifficultyOneTarget)
+func (t Target) Difficulty() float64 {
+	ti := t.Int()
+	if ti.Sign() == 0 {
+		return math.Inf(1)
+	}
+	difficulty := new(big.Float).SetInt(DifficultyOneTarget.Int())
+	difficulty.Quo(difficulty, new(big.Float).SetInt(ti))
+	d, _ := difficulty.Float64()
+	return d
+}
+
+//BlockDifficulty returns the difficulty of the target the way sia defines it: RootDepth / target,
+// this is the expected number of hashes needed to meet the target.
+func (t Target) BlockDifficulty() *big.Int {
+	ti := t.Int()
+	if ti.Sign() == 0 {
+		return RootDepth.Int()
+	}
+	return new(big.Int).Div(RootDepth.Int(), ti)
+}
+
+//BlockDifficultyToTarget converts a difficulty the way sia defines it (RootDepth / target) to a Target
+func BlockDifficultyToTarget(difficulty *big.Int) (target Target, err error) {
+	if difficulty.Sign() <= 0 {
+		err = errors.New("Invalid difficulty")
+		return
+	}
+	return IntToTarget(new(big.Int).Div(RootDepth.Int(), difficulty))
+}
+
+//DifficultyForHashRate returns the stratum difficulty at which a hashrate (in hashes per second)
+// finds the requested number of shares per minute
+func DifficultyForHashRate(hashRate, sharesPerMinute float64) float64 {
+	hashesPerShare, _ := new(big.Float).SetInt(DifficultyOneTarget.BlockDifficulty()).Float64()
+	return hashRate * 60 / (sharesPerMinute * hashesPerShare)
+}
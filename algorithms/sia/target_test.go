@@ -0,0 +1,61 @@
+package sia
+
+import (
+	"encoding/hex"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestTargetDifficulty(t *testing.T) {
+	for _, difficulty := range []float64{1, 0.5, 2, 1024, 123456.789} {
+		target, err := DifficultyToTarget(difficulty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := target.Difficulty(); math.Abs(result-difficulty)/difficulty > 1e-9 {
+			t.Error(result, "returned instead of", difficulty)
+		}
+	}
+	if _, err := DifficultyToTarget(0); err == nil {
+		t.Error("Expected an error for difficulty 0")
+	}
+
+	easy, _ := DifficultyToTarget(1)
+	hard, _ := DifficultyToTarget(2)
+	if hard.Cmp(easy) != -1 || easy.Cmp(hard) != 1 || easy.Cmp(easy) != 0 {
+		t.Error("A higher difficulty should result in a lower target")
+	}
+}
+
+func TestTargetBlockDifficulty(t *testing.T) {
+	//The target of block 56206
+	target := Target{0, 0, 0, 0, 0, 0, 26, 158}
+	blockDifficulty := target.BlockDifficulty()
+	expected, _ := new(big.Int).SetString("2707182869637445", 10)
+	if blockDifficulty.Cmp(expected) != 0 {
+		t.Error(blockDifficulty, "returned instead of", expected)
+	}
+	roundtrip, err := BlockDifficultyToTarget(blockDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roundtrip.BlockDifficulty().Cmp(blockDifficulty) != 0 {
+		t.Error(hex.EncodeToString(roundtrip[:]), "returned instead of", hex.EncodeToString(target[:]))
+	}
+	if RootDepth.BlockDifficulty().Int64() != 1 {
+		t.Error("The block difficulty of the RootDepth should be 1")
+	}
+}
+
+func TestHashDifficulty(t *testing.T) {
+	for _, provenSolution := range provenSolutions {
+		hash, _ := hex.DecodeString(provenSolution.hash)
+		var target Target
+		copy(target[:], hash)
+		//The solutions are blocks, they easily meet a stratum difficulty of a million
+		if difficulty := target.Difficulty(); difficulty < 1000000 {
+			t.Error("Unexpected low difficulty", difficulty, "for hash", provenSolution.hash)
+		}
+	}
+}
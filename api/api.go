@@ -0,0 +1,149 @@
+//Package api serves the state of the miner over http
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/robvanmieghem/gominer/clients"
+	"github.com/robvanmieghem/gominer/logging"
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+var apiLog = logging.New("api")
+
+//Server serves read-only json endpoints with the state of the miner:
+// /summary for the totals, /devices for the statistics per device, /pool for the pools with their current job
+// and difficulty and /errors for the recent errors.
+// /metrics serves the same statistics in the prometheus text format.
+// If the Control is set, the POST endpoints /control/pause?device=N, /control/resume?device=N,
+// /control/intensity?device=N&intensity=I, /control/pool?pool=N, /control/user?user=U, /control/restart
+// and /control/shutdown change the miner, they are only available if a Token is set.
+type Server struct {
+	//Token is required as a bearer token in the Authorization header or as the token query parameter if it is not empty
+	Token   string
+	Version string
+	Stats   *mining.Stats
+	Client  clients.Client
+	Control Controller
+
+	listener net.Listener
+	mux      *http.ServeMux
+}
+
+//summary is the reply of the /summary endpoint
+type summary struct {
+	Version string `json:"version"`
+	mining.Summary
+}
+
+//NewServer creates a Server for the statistics and the pools of the client
+func NewServer(stats *mining.Stats, client clients.Client) (s *Server) {
+	s = &Server{Stats: stats, Client: client, mux: http.NewServeMux()}
+	s.HandleFunc("/summary", func() interface{} { return summary{Version: s.Version, Summary: s.Stats.Summary()} })
+	s.HandleFunc("/devices", func() interface{} { return s.Stats.Devices() })
+	s.HandleFunc("/pool", func() interface{} { return poolStatus(s.Client) })
+	s.HandleFunc("/errors", func() interface{} { return s.Stats.Errors() })
+	s.Handle("/metrics", http.HandlerFunc(s.serveMetrics))
+	for path, action := range controlActions {
+		s.mux.Handle(path, s.authorizeControl(s.serveControl(action)))
+	}
+	return
+}
+
+//HandleFunc serves the json encoded value returned by f on path, other packages can add endpoints this way
+func (s *Server) HandleFunc(path string, f func() interface{}) {
+	s.Handle(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(f()); err != nil {
+			apiLog.Error("Unable to encode the reply of", path, "-", err)
+		}
+	}))
+}
+
+//Handle serves the handler on path, the requests are only passed to it once they are authorized
+func (s *Server) Handle(path string, handler http.Handler) {
+	s.mux.Handle(path, s.authorize(handler))
+}
+
+//authorize only passes the GET requests with the right token to the handler
+func (s *Server) authorize(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.Token != "" && !s.validToken(r) {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+//authorizeControl only passes the POST requests with the right token to the handler, a token is required
+func (s *Server) authorizeControl(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.Token == "" {
+			http.Error(w, "The control endpoints require a token", http.StatusForbidden)
+			return
+		}
+		if !s.validToken(r) {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+//validToken checks the bearer token or the token query parameter of a request
+func (s *Server) validToken(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+//poolStatus returns the state of the pools if the client reports it
+func poolStatus(client clients.Client) []clients.PoolStatus {
+	if reporter, ok := client.(clients.StatusReporter); ok {
+		return reporter.PoolStatus()
+	}
+	return []clients.PoolStatus{}
+}
+
+//ServeHTTP makes the Server usable as an http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+//Listen starts serving the api on the specified network address
+func (s *Server) Listen(address string) (err error) {
+	if s.listener, err = net.Listen("tcp", address); err != nil {
+		return
+	}
+	go func() {
+		if err := http.Serve(s.listener, s); err != nil {
+			apiLog.Error("Unable to serve the api -", err)
+		}
+	}()
+	return
+}
+
+//Addr returns the network address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+//Close stops listening
+func (s *Server) Close() {
+	s.listener.Close()
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients"
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+//statusClient is a client that only reports the state of a pool
+type statusClient struct {
+	clients.Client
+}
+
+func (sc statusClient) PoolStatus() []clients.PoolStatus {
+	return []clients.PoolStatus{{URL: "stratum+tcp://pool:3333", Active: true, Connected: true, Job: "job1", Difficulty: 8}}
+}
+
+//get fetches path from the server and decodes the json reply in v
+func get(t *testing.T, s *Server, path, token string, v interface{}) int {
+	r := httptest.NewRequest("GET", path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code == http.StatusOK && v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatal(path, err)
+		}
+	}
+	return w.Code
+}
+
+func TestServer(t *testing.T) {
+	stats := mining.NewStats()
+	stats.AddDevice(0, "Ellesmere")
+	stats.AddDevice(2, "Fiji")
+	stats.ReportHashRate(&mining.HashRateReport{MinerID: 0, HashRate: 800})
+	stats.ReportHashRate(&mining.HashRateReport{MinerID: 2, HashRate: 1200})
+	stats.ReportShare(0, "stratum+tcp://pool:3333", 10, mining.ShareAccepted)
+	stats.ReportShare(2, "stratum+tcp://pool:3333", 12, mining.ShareAccepted)
+	stats.ReportShare(2, "stratum+tcp://pool:3333", 9, mining.ShareRejected)
+	stats.ReportError("ERROR Error in connection to stratumserver")
+	s := NewServer(stats, statusClient{})
+	s.Version = "test"
+
+	var sum struct {
+		Version  string
+		HashRate float64
+		Accepted uint64
+		Rejected uint64
+		Devices  int
+	}
+	if code := get(t, s, "/summary", "", &sum); code != http.StatusOK {
+		t.Fatal("Status code", code)
+	}
+	if sum.Version != "test" || sum.HashRate != 2000 || sum.Accepted != 2 || sum.Rejected != 1 || sum.Devices != 2 {
+		t.Error("Unexpected summary:", sum)
+	}
+
+	var devices []mining.DeviceStats
+	get(t, s, "/devices", "", &devices)
+	if len(devices) != 2 || devices[1].MinerID != 2 || devices[1].Name != "Fiji" || devices[1].Rejected != 1 || devices[1].BestDifficulty != 12 {
+		t.Error("Unexpected devices:", devices)
+	}
+
+	var pools []clients.PoolStatus
+	get(t, s, "/pool", "", &pools)
+	if len(pools) != 1 || pools[0].Job != "job1" || pools[0].Difficulty != 8 {
+		t.Error("Unexpected pools:", pools)
+	}
+
+	var errs []mining.ErrorReport
+	get(t, s, "/errors", "", &errs)
+	if len(errs) != 1 || errs[0].Message != "ERROR Error in connection to stratumserver" {
+		t.Error("Unexpected errors:", errs)
+	}
+
+	r := httptest.NewRequest("POST", "/summary", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error("Status code", w.Code, "for a POST request")
+	}
+}
+
+func TestServerToken(t *testing.T) {
+	s := NewServer(mining.NewStats(), statusClient{})
+	s.Token = "secret"
+	if code := get(t, s, "/summary", "", nil); code != http.StatusUnauthorized {
+		t.Error("Status code", code, "without a token")
+	}
+	if code := get(t, s, "/summary", "wrong", nil); code != http.StatusUnauthorized {
+		t.Error("Status code", code, "with a wrong token")
+	}
+	if code := get(t, s, "/summary", "secret", nil); code != http.StatusOK {
+		t.Error("Status code", code, "with the token")
+	}
+	if code := get(t, s, "/summary?token=secret", "", nil); code != http.StatusOK {
+		t.Error("Status code", code, "with the token as query parameter")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	stats := mining.NewStats()
+	stats.AddDevice(0, `Fiji "Nano"`)
+	stats.ReportHashRate(&mining.HashRateReport{MinerID: 0, HashRate: 1.5, KernelDuration: 30 * time.Millisecond})
+	stats.ReportShare(0, "stratum+tcp://pool:3333", 10, mining.ShareAccepted)
+	stats.ReportShare(0, "stratum+tcp://pool:3333", 0, mining.ShareHardwareError)
+	stats.ReportSensors(0, mining.SensorReading{Temperature: 72, FanSpeed: 45, Power: 130})
+	stats.ReportDutyCycle(0, 80)
+	s := NewServer(stats, statusClient{})
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatal("Status code", w.Code, "content type", w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`gominer_device_hashrate_hashes_per_second{device="0",name="Fiji \"Nano\""} 1.5e+06`,
+		`gominer_device_duty_cycle_ratio{device="0",name="Fiji \"Nano\""} 0.8`,
+		`gominer_device_temperature_celsius{device="0",name="Fiji \"Nano\""} 72`,
+		`gominer_device_fan_speed_ratio{device="0",name="Fiji \"Nano\""} 0.45`,
+		`gominer_device_power_watts{device="0",name="Fiji \"Nano\""} 130`,
+		`gominer_kernel_duration_seconds_bucket{device="0",name="Fiji \"Nano\"",le="0.025"} 0`,
+		`gominer_kernel_duration_seconds_bucket{device="0",name="Fiji \"Nano\"",le="0.05"} 1`,
+		`gominer_kernel_duration_seconds_bucket{device="0",name="Fiji \"Nano\"",le="+Inf"} 1`,
+		`gominer_kernel_duration_seconds_count{device="0",name="Fiji \"Nano\""} 1`,
+		`gominer_shares_total{device="0",name="Fiji \"Nano\"",pool="stratum+tcp://pool:3333",result="accepted"} 1`,
+		`gominer_shares_total{device="0",name="Fiji \"Nano\"",pool="stratum+tcp://pool:3333",result="hardware_error"} 1`,
+		`gominer_pool_connected{pool="stratum+tcp://pool:3333"} 1`,
+		`gominer_pool_difficulty{pool="stratum+tcp://pool:3333"} 8`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Error("Missing", line, "in", body)
+		}
+	}
+}
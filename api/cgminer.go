@@ -0,0 +1,520 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients"
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+//CGMinerAPIVersion is the version of the cgminer api protocol that is implemented
+const CGMinerAPIVersion = "3.7"
+
+//cgminerRequestTimeout is the time a client has to send its command
+const cgminerRequestTimeout = 10 * time.Second
+
+//Status codes of the cgminer api
+const (
+	cgminerPools      = 7
+	cgminerNoPools    = 8
+	cgminerDevs       = 9
+	cgminerSummary    = 11
+	cgminerInvalidCmd = 14
+	cgminerVersion    = 22
+	cgminerConfig     = 33
+	cgminerAccessDeny = 45
+	cgminerPrivileged = 46
+
+	cgminerGPUDisable    = 12
+	cgminerGPUEnable     = 13
+	cgminerMissingID     = 15
+	cgminerInvalidGPU    = 16
+	cgminerSwitchPool    = 27
+	cgminerMissingValue  = 28
+	cgminerRestart       = 29
+	cgminerGPUIntensity  = 40
+	cgminerControlFailed = 41
+)
+
+//AllowList tells which clients can use the cgminer api, an empty AllowList only grants read access to the loopback addresses
+type AllowList []allowRule
+
+//allowRule grants access to a network, privileged commands are only allowed if privileged is true
+type allowRule struct {
+	network    *net.IPNet
+	privileged bool
+}
+
+//ParseAllowList parses a comma separated list of ip addresses or networks in CIDR notation like cgminer's --api-allow,
+// a W: prefix grants access to the privileged commands as well, an R: prefix or no prefix to the read-only ones.
+func ParseAllowList(list string) (allow AllowList, err error) {
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var rule allowRule
+		switch {
+		case strings.HasPrefix(entry, "W:"):
+			rule.privileged = true
+			entry = entry[2:]
+		case strings.HasPrefix(entry, "R:"):
+			entry = entry[2:]
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid address %s in the api allow list", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			rule.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		} else if _, rule.network, err = net.ParseCIDR(entry); err != nil {
+			return nil, fmt.Errorf("Invalid network %s in the api allow list", entry)
+		}
+		allow = append(allow, rule)
+	}
+	return
+}
+
+//access checks if a client with this address can use the api and the privileged commands
+func (allow AllowList) access(ip net.IP) (allowed, privileged bool) {
+	if len(allow) == 0 {
+		return ip.IsLoopback(), false
+	}
+	for _, rule := range allow {
+		if rule.network.Contains(ip) {
+			allowed = true
+			privileged = privileged || rule.privileged
+		}
+	}
+	return
+}
+
+//apiField is a named value in a reply, the fields keep their order in both formats
+type apiField struct {
+	Name  string
+	Value interface{}
+}
+
+//apiItem is an object in a reply
+type apiItem []apiField
+
+//MarshalJSON writes the item as a json object with the fields in order
+func (item apiItem) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, field := range item {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.Name)
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+//writePipe writes the item in the pipe format, name,field=value,...| or field=value,...| if name is empty
+func (item apiItem) writePipe(b *bytes.Buffer, name string) {
+	b.WriteString(name)
+	for i, field := range item {
+		if i > 0 || name != "" {
+			b.WriteByte(',')
+		}
+		value := fmt.Sprint(field.Value)
+		switch v := field.Value.(type) {
+		case bool:
+			value = "false"
+			if v {
+				value = "true"
+			}
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		//The separators can not be escaped in the pipe format
+		b.WriteString(field.Name + "=" + strings.NewReplacer(",", " ", "|", " ", "=", " ").Replace(value))
+	}
+	b.WriteByte('|')
+}
+
+//apiReply is the reply to a single command
+type apiReply struct {
+	status apiItem
+	//section is the name of the list of items, like SUMMARY or DEVS
+	section string
+	items   []apiItem
+	//named tells if the items are written with the section name in the pipe format,
+	// the items of lists like DEVS start with their own index field instead
+	named bool
+}
+
+//json returns the value of the reply in the json format
+func (r *apiReply) json(id int) apiItem {
+	reply := apiItem{{"STATUS", []apiItem{r.status}}}
+	if r.section != "" {
+		reply = append(reply, apiField{r.section, r.items})
+	}
+	return append(reply, apiField{"id", id})
+}
+
+//pipe writes the reply in the pipe format
+func (r *apiReply) pipe(b *bytes.Buffer) {
+	r.status.writePipe(b, "")
+	for _, item := range r.items {
+		name := ""
+		if r.named {
+			name = r.section
+		}
+		item.writePipe(b, name)
+	}
+}
+
+//cgminerCommand handles a command of the cgminer api, the handler gets the address of the client and the parameter
+type cgminerCommand struct {
+	privileged bool
+	//control commands are only available if the server has a Controller
+	control bool
+	handler func(client, parameter string) *apiReply
+}
+
+//CGMinerServer serves the state of the miner using the cgminer api protocol, that is used by many rig management tools.
+// A request is a command like summary, devs, pools, version or config with an optional parameter separated by a |,
+// or the json object {"command": "<command>", "parameter": "<parameter>"} where commands can be combined with a +.
+// The reply is in the same format, the pipe format or json, and is terminated by a 0 byte.
+type CGMinerServer struct {
+	Version string
+	Stats   *mining.Stats
+	Client  clients.Client
+	Allow   AllowList
+	//Control makes the privileged commands that change the miner available if it is set
+	Control Controller
+
+	listener net.Listener
+	commands map[string]cgminerCommand
+}
+
+//NewCGMinerServer creates a CGMinerServer for the statistics and the pools of the client
+func NewCGMinerServer(stats *mining.Stats, client clients.Client, allow AllowList) (s *CGMinerServer) {
+	s = &CGMinerServer{Stats: stats, Client: client, Allow: allow, commands: make(map[string]cgminerCommand)}
+	s.handle("version", false, s.version)
+	s.handle("config", false, s.config)
+	s.handle("summary", false, s.summary)
+	s.handle("devs", false, s.devs)
+	s.handle("pools", false, s.pools)
+	s.handle("privileged", true, func(string, string) *apiReply {
+		return &apiReply{status: s.status("S", cgminerPrivileged, "Privileged access OK")}
+	})
+	s.handleControlCommands()
+	return
+}
+
+//handle adds a command, privileged commands are only answered for the clients that have write access in the AllowList
+func (s *CGMinerServer) handle(command string, privileged bool, handler func(client, parameter string) *apiReply) {
+	s.commands[command] = cgminerCommand{privileged: privileged, handler: handler}
+}
+
+//status creates the status of a reply
+func (s *CGMinerServer) status(status string, code int, msg string) apiItem {
+	return apiItem{
+		{"STATUS", status},
+		{"When", time.Now().Unix()},
+		{"Code", code},
+		{"Msg", msg},
+		{"Description", "gominer " + s.Version},
+	}
+}
+
+func (s *CGMinerServer) version(string, string) *apiReply {
+	return &apiReply{
+		status:  s.status("S", cgminerVersion, "GoMiner versions"),
+		section: "VERSION",
+		items:   []apiItem{{{"GoMiner", s.Version}, {"API", CGMinerAPIVersion}}},
+		named:   true,
+	}
+}
+
+func (s *CGMinerServer) config(string, string) *apiReply {
+	strategy := "Failover"
+	if _, ok := s.Client.(*clients.MultiPoolClient); ok {
+		strategy = "Load Balance"
+	}
+	return &apiReply{
+		status:  s.status("S", cgminerConfig, "GoMiner config"),
+		section: "CONFIG",
+		items: []apiItem{{
+			{"GPU Count", len(s.Stats.Devices())},
+			{"Pool Count", len(poolStatus(s.Client))},
+			{"Strategy", strategy},
+			{"Device Code", "GPU"},
+			{"OS", runtime.GOOS},
+		}},
+		named: true,
+	}
+}
+
+//utility returns the number of accepted shares per minute
+func utility(accepted uint64, elapsed float64) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(accepted) * 60 / elapsed
+}
+
+func (s *CGMinerServer) summary(string, string) *apiReply {
+	summary := s.Stats.Summary()
+	var best float64
+	for _, d := range s.Stats.Devices() {
+		if d.BestDifficulty > best {
+			best = d.BestDifficulty
+		}
+	}
+	elapsed := float64(summary.Uptime)
+	return &apiReply{
+		status:  s.status("S", cgminerSummary, "Summary"),
+		section: "SUMMARY",
+		items: []apiItem{{
+			{"Elapsed", summary.Uptime},
+			{"MHS av", summary.AverageHashRate},
+			{"MHS 5s", summary.HashRate},
+			{"Accepted", summary.Accepted},
+			{"Rejected", summary.Rejected},
+			{"Stale", summary.Stale},
+			{"Hardware Errors", summary.HardwareErrors},
+			{"Utility", utility(summary.Accepted, elapsed)},
+			{"Best Share", best},
+		}},
+		named: true,
+	}
+}
+
+func (s *CGMinerServer) devs(string, string) *apiReply {
+	summary := s.Stats.Summary()
+	devices := s.Stats.Devices()
+	reply := &apiReply{status: s.status("S", cgminerDevs, fmt.Sprintf("%d GPU(s)", len(devices))), section: "DEVS"}
+	for i, d := range devices {
+		var lastShare int64
+		if !d.LastShare.IsZero() {
+			lastShare = d.LastShare.Unix()
+		}
+		var sensors mining.SensorReading
+		if d.Sensors != nil {
+			sensors = *d.Sensors
+		}
+		//A paused device is disabled and a device that is throttled because it is too hot is sick
+		enabled, status := "Y", "Alive"
+		if d.Paused {
+			enabled = "N"
+		}
+		if d.DutyCycle < 100 {
+			status = "Sick"
+		}
+		reply.items = append(reply.items, apiItem{
+			{"GPU", i},
+			{"ID", d.MinerID},
+			{"Name", d.Name},
+			{"Enabled", enabled},
+			{"Status", status},
+			{"Temperature", sensors.Temperature},
+			{"Fan Speed", sensors.FanRPM},
+			{"Fan Percent", sensors.FanSpeed},
+			{"GPU Power", sensors.Power},
+			{"MHS av", d.AverageHashRate},
+			{"MHS 5s", d.HashRate},
+			{"Accepted", d.Accepted},
+			{"Rejected", d.Rejected},
+			{"Stale", d.Stale},
+			{"Hardware Errors", d.HardwareErrors},
+			{"Utility", utility(d.Accepted, float64(summary.Uptime))},
+			{"Last Share Time", lastShare},
+			{"Best Share", d.BestDifficulty},
+			{"Device Elapsed", summary.Uptime},
+		})
+	}
+	return reply
+}
+
+func (s *CGMinerServer) pools(string, string) *apiReply {
+	pools := poolStatus(s.Client)
+	if len(pools) == 0 {
+		return &apiReply{status: s.status("E", cgminerNoPools, "No pools")}
+	}
+	//The shares are counted per pool url
+	shares := make(map[string]map[mining.ShareResult]uint64)
+	for _, share := range s.Stats.Shares() {
+		if shares[share.Pool] == nil {
+			shares[share.Pool] = make(map[mining.ShareResult]uint64)
+		}
+		shares[share.Pool][share.Result] += share.Count
+	}
+	reply := &apiReply{status: s.status("S", cgminerPools, fmt.Sprintf("%d Pool(s)", len(pools))), section: "POOLS"}
+	for i, p := range pools {
+		status := "Dead"
+		if p.Connected {
+			status = "Alive"
+		}
+		var lastJob int64
+		if !p.JobReceived.IsZero() {
+			lastJob = p.JobReceived.Unix()
+		}
+		reply.items = append(reply.items, apiItem{
+			{"POOL", i},
+			{"URL", p.URL},
+			{"Status", status},
+			{"Priority", i},
+			{"Stratum Active", p.Active && strings.HasPrefix(p.URL, "stratum+tcp://")},
+			{"Stratum URL", strings.TrimPrefix(p.URL, "stratum+tcp://")},
+			{"Active", p.Active},
+			{"Getworks", p.Jobs},
+			{"Accepted", shares[p.URL][mining.ShareAccepted]},
+			{"Rejected", shares[p.URL][mining.ShareRejected]},
+			{"Stale", shares[p.URL][mining.ShareStale]},
+			{"Last Getwork", lastJob},
+			{"Stratum Difficulty", p.Difficulty},
+			{"Reconnects", p.Reconnects},
+		})
+	}
+	return reply
+}
+
+//Listen starts serving the api on the specified network address
+func (s *CGMinerServer) Listen(address string) (err error) {
+	if s.listener, err = net.Listen("tcp", address); err != nil {
+		return
+	}
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return
+}
+
+//Addr returns the network address the server is listening on
+func (s *CGMinerServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+//Close stops listening
+func (s *CGMinerServer) Close() {
+	s.listener.Close()
+}
+
+//serve answers the request of a single connection
+func (s *CGMinerServer) serve(conn net.Conn) {
+	defer conn.Close()
+	var ip net.IP
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	allowed, privileged := s.Allow.access(ip)
+	if !allowed {
+		apiLog.Warn("Refused cgminer api connection from", conn.RemoteAddr())
+		return
+	}
+	conn.SetDeadline(time.Now().Add(cgminerRequestTimeout))
+	//Like cgminer, the request is expected in a single read
+	buffer := make([]byte, 8192)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return
+	}
+	client := "cgminer api " + conn.RemoteAddr().String()
+	reply, quit := s.Answer(buffer[:n], client, privileged)
+	if _, err = conn.Write(append(reply, 0)); err != nil {
+		apiLog.Error("Unable to write the cgminer api reply -", err)
+	}
+	if quit {
+		conn.Close()
+		err = s.Control.Shutdown()
+		audit(client, "shutdown", err)
+	}
+}
+
+//Answer returns the reply to a request from client and if the miner should quit,
+// privileged tells if the client has access to the privileged commands
+func (s *CGMinerServer) Answer(request []byte, client string, privileged bool) (reply []byte, quit bool) {
+	request = bytes.TrimSpace(bytes.TrimRight(request, "\x00"))
+	if len(request) > 0 && request[0] == '{' {
+		return s.answerJSON(request, client, privileged)
+	}
+	command, parameter := string(request), ""
+	if i := strings.Index(command, "|"); i >= 0 {
+		command, parameter = command[:i], command[i+1:]
+	}
+	if s.quitCommand(command) && privileged {
+		return []byte("BYE"), true
+	}
+	var b bytes.Buffer
+	s.execute(command, parameter, client, privileged).pipe(&b)
+	return b.Bytes(), false
+}
+
+//answerJSON answers a json request, the commands separated by a + are answered in a single object
+func (s *CGMinerServer) answerJSON(request []byte, client string, privileged bool) (reply []byte, quit bool) {
+	var r struct {
+		Command   string `json:"command"`
+		Parameter string `json:"parameter"`
+	}
+	if err := json.Unmarshal(request, &r); err != nil {
+		reply, _ = json.Marshal((&apiReply{status: s.status("E", cgminerInvalidCmd, "Invalid JSON")}).json(1))
+		return
+	}
+	if s.quitCommand(r.Command) && privileged {
+		return []byte(`{"STATUS":"BYE"}`), true
+	}
+	commands := strings.Split(r.Command, "+")
+	if len(commands) == 1 {
+		reply, _ = json.Marshal(s.execute(r.Command, r.Parameter, client, privileged).json(1))
+		return
+	}
+	var combined apiItem
+	for _, command := range commands {
+		combined = append(combined, apiField{command, []apiItem{s.execute(command, r.Parameter, client, privileged).json(1)}})
+	}
+	reply, _ = json.Marshal(combined)
+	return
+}
+
+//quitCommand checks if the command is quit and quitting is possible
+func (s *CGMinerServer) quitCommand(command string) bool {
+	return s.Control != nil && strings.ToLower(strings.TrimSpace(command)) == "quit"
+}
+
+//execute runs a single command, quit is answered before since the connection has to be closed after it
+func (s *CGMinerServer) execute(command, parameter, client string, privileged bool) *apiReply {
+	command = strings.ToLower(strings.TrimSpace(command))
+	c, found := s.commands[command]
+	if s.quitCommand(command) {
+		found, c.privileged = true, true
+		c.handler = func(string, string) *apiReply {
+			return &apiReply{status: s.status("E", cgminerInvalidCmd, "The quit command can not be combined with others")}
+		}
+	}
+	switch {
+	case !found || c.control && s.Control == nil:
+		return &apiReply{status: s.status("E", cgminerInvalidCmd, "Invalid command")}
+	case c.privileged && !privileged:
+		return &apiReply{status: s.status("E", cgminerAccessDeny, fmt.Sprintf("Access denied to '%s' command", command))}
+	}
+	return c.handler(client, parameter)
+}
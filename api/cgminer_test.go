@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+func newTestCGMinerServer() *CGMinerServer {
+	stats := mining.NewStats()
+	stats.AddDevice(0, "Ellesmere")
+	stats.AddDevice(2, "Fiji")
+	stats.ReportHashRate(&mining.HashRateReport{MinerID: 0, HashRate: 800})
+	stats.ReportHashRate(&mining.HashRateReport{MinerID: 2, HashRate: 1200})
+	stats.ReportShare(2, "stratum+tcp://pool:3333", 12, mining.ShareAccepted)
+	stats.ReportShare(2, "stratum+tcp://pool:3333", 9, mining.ShareStale)
+	stats.ReportSensors(2, mining.SensorReading{Temperature: 70, FanSpeed: 40, FanRPM: 1800, Power: 150})
+	s := NewCGMinerServer(stats, statusClient{}, nil)
+	s.Version = "test"
+	return s
+}
+
+func TestCGMinerPipe(t *testing.T) {
+	s := newTestCGMinerServer()
+	reply, _ := s.Answer([]byte("summary"), "test", false)
+	if !strings.HasPrefix(string(reply), "STATUS=S,When=") || !strings.Contains(string(reply), ",Code=11,Msg=Summary,Description=gominer test|SUMMARY,Elapsed=") ||
+		!strings.Contains(string(reply), ",MHS av=2000,") || !strings.Contains(string(reply), ",Accepted=1,Rejected=0,Stale=1,") || !strings.HasSuffix(string(reply), "|") {
+		t.Error("Unexpected summary:", string(reply))
+	}
+	reply, _ = s.Answer([]byte("devs\n"), "test", false)
+	if sections := strings.Split(string(reply), "|"); len(sections) != 4 || !strings.HasPrefix(sections[2], "GPU=1,ID=2,Name=Fiji,Enabled=Y,Status=Alive,Temperature=70,Fan Speed=1800,Fan Percent=40,GPU Power=150,MHS av=1200,") {
+		t.Error("Unexpected devs:", string(reply))
+	}
+	//A paused and a throttled device
+	s.Stats.ReportState(0, 28, true)
+	s.Stats.ReportDutyCycle(2, 50)
+	reply, _ = s.Answer([]byte("devs"), "test", false)
+	if sections := strings.Split(string(reply), "|"); len(sections) != 4 || !strings.HasPrefix(sections[1], "GPU=0,ID=0,Name=Ellesmere,Enabled=N,Status=Alive,") ||
+		!strings.HasPrefix(sections[2], "GPU=1,ID=2,Name=Fiji,Enabled=Y,Status=Sick,") {
+		t.Error("Unexpected state of the devices:", string(reply))
+	}
+	reply, _ = s.Answer([]byte("pools"), "test", false)
+	if !strings.Contains(string(reply), "|POOL=0,URL=stratum+tcp://pool:3333,Status=Alive,") || !strings.Contains(string(reply), ",Stratum Difficulty=8,") {
+		t.Error("Unexpected pools:", string(reply))
+	}
+	reply, _ = s.Answer([]byte("unknown"), "test", false)
+	if !strings.HasPrefix(string(reply), "STATUS=E,") || !strings.Contains(string(reply), "Code=14") {
+		t.Error("Unexpected reply to an invalid command:", string(reply))
+	}
+}
+
+func TestCGMinerJSON(t *testing.T) {
+	s := newTestCGMinerServer()
+	var version struct {
+		STATUS []struct {
+			STATUS string
+			Code   int
+		}
+		VERSION []struct {
+			GoMiner string
+			API     string
+		}
+		ID int `json:"id"`
+	}
+	reply, _ := s.Answer([]byte(`{"command": "version"}`), "test", false)
+	if err := json.Unmarshal(reply, &version); err != nil {
+		t.Fatal(err, string(reply))
+	}
+	if len(version.STATUS) != 1 || version.STATUS[0].STATUS != "S" || len(version.VERSION) != 1 || version.VERSION[0].GoMiner != "test" || version.ID != 1 {
+		t.Error("Unexpected version:", string(reply))
+	}
+
+	var combined struct {
+		Summary []struct {
+			SUMMARY []map[string]interface{}
+		}
+		Devs []struct {
+			DEVS []map[string]interface{}
+		}
+	}
+	reply, _ = s.Answer([]byte(`{"command": "summary+devs"}`), "test", false)
+	if err := json.Unmarshal(reply, &combined); err != nil {
+		t.Fatal(err, string(reply))
+	}
+	if len(combined.Summary) != 1 || combined.Summary[0].SUMMARY[0]["MHS av"] != 2000.0 || len(combined.Devs) != 1 || len(combined.Devs[0].DEVS) != 2 {
+		t.Error("Unexpected combined reply:", string(reply))
+	}
+}
+
+func TestCGMinerPrivileged(t *testing.T) {
+	s := newTestCGMinerServer()
+	control := newTestController()
+	if reply, _ := s.Answer([]byte("quit"), "test", true); !strings.Contains(string(reply), "Code=14") {
+		t.Error("Unexpected reply to quit without a controller:", string(reply))
+	}
+	s.Control = control
+	if reply, q := s.Answer([]byte("privileged"), "test", false); !strings.Contains(string(reply), "Code=45") || q {
+		t.Error("Unexpected reply to privileged without access:", string(reply))
+	}
+	if reply, q := s.Answer([]byte("quit"), "test", false); !strings.Contains(string(reply), "Code=45") || q {
+		t.Error("Unexpected reply to quit without access:", string(reply))
+	}
+	if reply, _ := s.Answer([]byte("privileged"), "test", true); !strings.Contains(string(reply), "Code=46") {
+		t.Error("Unexpected reply to privileged with access:", string(reply))
+	}
+
+	allow, err := ParseAllowList("R:10.0.0.0/8,W:127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Allow = allow
+	if err = s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("quit"))
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "BYE\x00" {
+		t.Errorf("Unexpected reply to quit: %q", reply)
+	}
+	if action := <-control.actions; action != "shutdown" {
+		t.Error("Unexpected action", action)
+	}
+}
+
+func TestParseAllowList(t *testing.T) {
+	allow, err := ParseAllowList("192.168.1.0/24, W:10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		ip                  string
+		allowed, privileged bool
+	}{
+		{"192.168.1.20", true, false},
+		{"10.0.0.5", true, true},
+		{"10.0.0.6", false, false},
+		{"127.0.0.1", false, false},
+	} {
+		if allowed, privileged := allow.access(net.ParseIP(test.ip)); allowed != test.allowed || privileged != test.privileged {
+			t.Error("Unexpected access for", test.ip, "-", allowed, privileged)
+		}
+	}
+	if allowed, privileged := AllowList(nil).access(net.ParseIP("127.0.0.1")); !allowed || privileged {
+		t.Error("An empty allow list should give read access to the loopback address")
+	}
+	if _, err = ParseAllowList("W:10.0.0"); err == nil {
+		t.Error("No error for an invalid address")
+	}
+}
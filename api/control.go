@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+//Controller changes the miner while it is running, the devices are identified by their device number
+type Controller interface {
+	PauseDevice(minerID int) error
+	ResumeDevice(minerID int) error
+	SetIntensity(minerID, intensity int) error
+	//SwitchPool mines on the pool with this index in the order of the configuration, -1 restores the automatic selection
+	SwitchPool(pool int) error
+	SetUser(user string) error
+	//Restart and Shutdown stop the miner gracefully, they return before the miner is stopped
+	Restart() error
+	Shutdown() error
+}
+
+var controlLog = logging.New("control")
+
+//audit logs an action requested by a client of an api and its result
+func audit(client, action string, err error) {
+	if err != nil {
+		controlLog.Error(client, "-", action, "-", err)
+		return
+	}
+	controlLog.Info(client, "-", action, "- OK")
+}
+
+//controlAction parses the parameters of a control request into the description of the action and the function executing it
+type controlAction func(r *http.Request) (action string, execute func(c Controller) error, err error)
+
+//intParameter parses a required integer parameter of a control request
+func intParameter(r *http.Request, name string) (value int, err error) {
+	if value, err = strconv.Atoi(r.FormValue(name)); err != nil {
+		err = fmt.Errorf("Invalid or missing parameter %s", name)
+	}
+	return
+}
+
+//controlActions are the control endpoints, the parameters are taken from the query or the form in the body
+var controlActions = map[string]controlAction{
+	"/control/pause": func(r *http.Request) (action string, execute func(c Controller) error, err error) {
+		minerID, err := intParameter(r, "device")
+		return fmt.Sprint("pause device ", minerID), func(c Controller) error { return c.PauseDevice(minerID) }, err
+	},
+	"/control/resume": func(r *http.Request) (action string, execute func(c Controller) error, err error) {
+		minerID, err := intParameter(r, "device")
+		return fmt.Sprint("resume device ", minerID), func(c Controller) error { return c.ResumeDevice(minerID) }, err
+	},
+	"/control/intensity": func(r *http.Request) (action string, execute func(c Controller) error, err error) {
+		minerID, err := intParameter(r, "device")
+		if err != nil {
+			return
+		}
+		intensity, err := intParameter(r, "intensity")
+		return fmt.Sprintf("set the intensity of device %d to %d", minerID, intensity), func(c Controller) error { return c.SetIntensity(minerID, intensity) }, err
+	},
+	"/control/pool": func(r *http.Request) (action string, execute func(c Controller) error, err error) {
+		pool, err := intParameter(r, "pool")
+		return fmt.Sprint("switch to pool ", pool), func(c Controller) error { return c.SwitchPool(pool) }, err
+	},
+	"/control/user": func(r *http.Request) (action string, execute func(c Controller) error, err error) {
+		user := r.FormValue("user")
+		if user == "" {
+			err = errors.New("Invalid or missing parameter user")
+		}
+		return "set the user to " + user, func(c Controller) error { return c.SetUser(user) }, err
+	},
+	"/control/restart": func(r *http.Request) (string, func(c Controller) error, error) {
+		return "restart", Controller.Restart, nil
+	},
+	"/control/shutdown": func(r *http.Request) (string, func(c Controller) error, error) {
+		return "shutdown", Controller.Shutdown, nil
+	},
+}
+
+//serveControl executes an action on the Control of the server, the reply is {"result": "ok"} or an error with the message
+func (s *Server) serveControl(action controlAction) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Control == nil {
+			http.NotFound(w, r)
+			return
+		}
+		description, execute, err := action(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = execute(s.Control)
+		audit("api "+r.RemoteAddr, description, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"result": "ok"})
+	})
+}
+
+//cgminerDevice returns the device number of the GPU index of the devs command that is the first value of the parameter
+func (s *CGMinerServer) cgminerDevice(parameter string) (minerID int, reply *apiReply) {
+	devices := s.Stats.Devices()
+	index, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(parameter, ",", 2)[0]))
+	if err != nil {
+		return 0, &apiReply{status: s.status("E", cgminerMissingID, "Missing device id parameter")}
+	}
+	if index < 0 || index >= len(devices) {
+		return 0, &apiReply{status: s.status("E", cgminerInvalidGPU, fmt.Sprintf("Invalid GPU id %d - range is 0 - %d", index, len(devices)-1))}
+	}
+	return devices[index].MinerID, nil
+}
+
+//control executes an action for a client of the cgminer api and creates the reply
+func (s *CGMinerServer) control(client, action string, execute func() error, code int, msg string) *apiReply {
+	err := execute()
+	audit(client, action, err)
+	if err != nil {
+		return &apiReply{status: s.status("E", cgminerControlFailed, err.Error())}
+	}
+	return &apiReply{status: s.status("S", code, msg)}
+}
+
+//handleControl adds a privileged command that is only available if the server has a Controller
+func (s *CGMinerServer) handleControl(command string, handler func(client, parameter string) *apiReply) {
+	s.commands[command] = cgminerCommand{privileged: true, control: true, handler: handler}
+}
+
+//handleControlCommands adds the commands that change the miner:
+// gpuenable|N, gpudisable|N, gpuintensity|N,I, switchpool|N and restart, quit is handled separately.
+func (s *CGMinerServer) handleControlCommands() {
+	s.handleControl("gpuenable", func(client, parameter string) *apiReply {
+		minerID, reply := s.cgminerDevice(parameter)
+		if reply != nil {
+			return reply
+		}
+		return s.control(client, fmt.Sprint("resume device ", minerID), func() error { return s.Control.ResumeDevice(minerID) },
+			cgminerGPUEnable, fmt.Sprintf("GPU %s sent enable message", parameter))
+	})
+	s.handleControl("gpudisable", func(client, parameter string) *apiReply {
+		minerID, reply := s.cgminerDevice(parameter)
+		if reply != nil {
+			return reply
+		}
+		return s.control(client, fmt.Sprint("pause device ", minerID), func() error { return s.Control.PauseDevice(minerID) },
+			cgminerGPUDisable, fmt.Sprintf("GPU %s set disable flag", parameter))
+	})
+	s.handleControl("gpuintensity", func(client, parameter string) *apiReply {
+		minerID, reply := s.cgminerDevice(parameter)
+		if reply != nil {
+			return reply
+		}
+		parts := strings.SplitN(parameter, ",", 2)
+		if len(parts) != 2 {
+			return &apiReply{status: s.status("E", cgminerMissingValue, "Missing intensity")}
+		}
+		intensity, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return &apiReply{status: s.status("E", cgminerMissingValue, "Invalid intensity")}
+		}
+		return s.control(client, fmt.Sprintf("set the intensity of device %d to %d", minerID, intensity), func() error { return s.Control.SetIntensity(minerID, intensity) },
+			cgminerGPUIntensity, fmt.Sprintf("GPU %s set new intensity to %d", parts[0], intensity))
+	})
+	s.handleControl("switchpool", func(client, parameter string) *apiReply {
+		pool, err := strconv.Atoi(strings.TrimSpace(parameter))
+		if err != nil {
+			return &apiReply{status: s.status("E", cgminerMissingValue, "Missing or invalid pool id")}
+		}
+		return s.control(client, fmt.Sprint("switch to pool ", pool), func() error { return s.Control.SwitchPool(pool) },
+			cgminerSwitchPool, fmt.Sprintf("Switching to pool %d", pool))
+	})
+	s.handleControl("restart", func(client, parameter string) *apiReply {
+		return s.control(client, "restart", s.Control.Restart, cgminerRestart, "Restarting")
+	})
+}
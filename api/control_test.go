@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+//testController records the actions, device 7 does not exist
+type testController struct {
+	actions chan string
+}
+
+func newTestController() *testController {
+	return &testController{actions: make(chan string, 10)}
+}
+
+func (c *testController) record(action string, minerID int) error {
+	if minerID == 7 {
+		return errors.New("There is no device 7")
+	}
+	c.actions <- fmt.Sprint(action, " ", minerID)
+	return nil
+}
+
+func (c *testController) PauseDevice(minerID int) error  { return c.record("pause", minerID) }
+func (c *testController) ResumeDevice(minerID int) error { return c.record("resume", minerID) }
+func (c *testController) SetIntensity(minerID, intensity int) error {
+	return c.record(fmt.Sprint("intensity ", intensity), minerID)
+}
+func (c *testController) SwitchPool(pool int) error { return c.record("pool", pool) }
+func (c *testController) SetUser(user string) error {
+	c.actions <- "user " + user
+	return nil
+}
+func (c *testController) Restart() error {
+	c.actions <- "restart"
+	return nil
+}
+func (c *testController) Shutdown() error {
+	c.actions <- "shutdown"
+	return nil
+}
+
+//post sends a control request to the server and returns the status code
+func post(s *Server, path, token string) int {
+	r := httptest.NewRequest("POST", path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestControl(t *testing.T) {
+	control := newTestController()
+	s := NewServer(mining.NewStats(), statusClient{})
+	s.Control = control
+	if code := post(s, "/control/pause?device=1", ""); code != http.StatusForbidden {
+		t.Error("Status code", code, "without a token on the server")
+	}
+	s.Token = "secret"
+	if code := post(s, "/control/pause?device=1", "wrong"); code != http.StatusUnauthorized {
+		t.Error("Status code", code, "with a wrong token")
+	}
+	if code := get(t, s, "/control/pause?device=1", "secret", nil); code != http.StatusMethodNotAllowed {
+		t.Error("Status code", code, "for a GET request")
+	}
+	for _, test := range []struct {
+		path   string
+		code   int
+		action string
+	}{
+		{"/control/pause?device=1", http.StatusOK, "pause 1"},
+		{"/control/resume?device=1", http.StatusOK, "resume 1"},
+		{"/control/intensity?device=2&intensity=24", http.StatusOK, "intensity 24 2"},
+		{"/control/pool?pool=-1", http.StatusOK, "pool -1"},
+		{"/control/user?user=address.rig", http.StatusOK, "user address.rig"},
+		{"/control/restart", http.StatusOK, "restart"},
+		{"/control/shutdown", http.StatusOK, "shutdown"},
+		{"/control/pause", http.StatusBadRequest, ""},
+		{"/control/intensity?device=2", http.StatusBadRequest, ""},
+		{"/control/resume?device=7", http.StatusBadRequest, ""},
+	} {
+		if code := post(s, test.path, "secret"); code != test.code {
+			t.Error("Status code", code, "for", test.path)
+		}
+		if test.action != "" {
+			if action := <-control.actions; action != test.action {
+				t.Error("Unexpected action", action, "for", test.path)
+			}
+		}
+	}
+	if len(control.actions) != 0 {
+		t.Error("Unexpected actions for invalid requests")
+	}
+}
+
+func TestCGMinerControl(t *testing.T) {
+	s := newTestCGMinerServer()
+	control := newTestController()
+	s.Control = control
+	if reply, _ := s.Answer([]byte("gpudisable|1"), "test", false); !strings.Contains(string(reply), "Code=45") {
+		t.Error("Unexpected reply without access:", string(reply))
+	}
+	for _, test := range []struct {
+		request string
+		status  string
+		action  string
+	}{
+		//GPU 1 is the second device of the devs command, device number 2
+		{"gpudisable|1", "STATUS=S", "pause 2"},
+		{"gpuenable|0", "STATUS=S", "resume 0"},
+		{"gpuintensity|1,26", "STATUS=S", "intensity 26 2"},
+		{`{"command":"switchpool","parameter":"0"}`, `"STATUS":"S"`, "pool 0"},
+		{"restart", "STATUS=S", "restart"},
+		{"gpuenable|2", "STATUS=E", ""},
+		{"gpuintensity|1", "STATUS=E", ""},
+		{"switchpool|7", "STATUS=E", ""},
+	} {
+		reply, _ := s.Answer([]byte(test.request), "test", true)
+		if !strings.Contains(string(reply), test.status) {
+			t.Error("Unexpected reply to", test.request, "-", string(reply))
+		}
+		if test.action != "" {
+			if action := <-control.actions; action != test.action {
+				t.Error("Unexpected action", action, "for", test.request)
+			}
+		}
+	}
+	s.Control = nil
+	if reply, _ := s.Answer([]byte("gpuenable|0"), "test", true); !strings.Contains(string(reply), "Code=14") {
+		t.Error("Unexpected reply without a controller:", string(reply))
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+//labelEscaper escapes label values as required by the prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+//metricsWriter writes metrics in the prometheus text exposition format
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+//header writes the help and type lines of a metric
+func (mw metricsWriter) header(name, metricType, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+//sample writes a single value, labels are given as name, value pairs
+func (mw metricsWriter) sample(name string, value float64, labels ...string) {
+	mw.w.WriteString(name)
+	if len(labels) > 0 {
+		mw.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.w.WriteByte(',')
+			}
+			fmt.Fprintf(mw.w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		mw.w.WriteByte('}')
+	}
+	mw.w.WriteByte(' ')
+	mw.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	mw.w.WriteByte('\n')
+}
+
+//writeMetrics writes the statistics of the devices and the state of the pools
+func (s *Server) writeMetrics(out io.Writer) error {
+	mw := metricsWriter{w: bufio.NewWriter(out)}
+	summary := s.Stats.Summary()
+	devices := s.Stats.Devices()
+	names := make(map[int]string)
+	for _, d := range devices {
+		names[d.MinerID] = d.Name
+	}
+
+	mw.header("gominer_uptime_seconds", "gauge", "Number of seconds since the miner started.")
+	mw.sample("gominer_uptime_seconds", float64(summary.Uptime))
+
+	mw.header("gominer_device_hashrate_hashes_per_second", "gauge", "Last reported hashrate of a device.")
+	for _, d := range devices {
+		mw.sample("gominer_device_hashrate_hashes_per_second", d.HashRate*1000000, "device", strconv.Itoa(d.MinerID), "name", d.Name)
+	}
+
+	mw.header("gominer_device_duty_cycle_ratio", "gauge", "Fraction of the time a device mines, it is lowered to throttle a hot device.")
+	for _, d := range devices {
+		mw.sample("gominer_device_duty_cycle_ratio", float64(d.DutyCycle)/100, "device", strconv.Itoa(d.MinerID), "name", d.Name)
+	}
+	mw.header("gominer_device_temperature_celsius", "gauge", "Last reported temperature of a device.")
+	for _, d := range devices {
+		if d.Sensors != nil {
+			mw.sample("gominer_device_temperature_celsius", d.Sensors.Temperature, "device", strconv.Itoa(d.MinerID), "name", d.Name)
+		}
+	}
+	mw.header("gominer_device_fan_speed_ratio", "gauge", "Last reported fan speed of a device as a fraction of its maximum.")
+	for _, d := range devices {
+		if d.Sensors != nil {
+			mw.sample("gominer_device_fan_speed_ratio", d.Sensors.FanSpeed/100, "device", strconv.Itoa(d.MinerID), "name", d.Name)
+		}
+	}
+	mw.header("gominer_device_power_watts", "gauge", "Last reported average power draw of a device.")
+	for _, d := range devices {
+		if d.Sensors != nil {
+			mw.sample("gominer_device_power_watts", d.Sensors.Power, "device", strconv.Itoa(d.MinerID), "name", d.Name)
+		}
+	}
+
+	mw.header("gominer_kernel_duration_seconds", "histogram", "Time a kernel run takes on a device.")
+	for _, d := range devices {
+		device, h := strconv.Itoa(d.MinerID), d.KernelDuration
+		var cumulative uint64
+		for i, bound := range mining.KernelDurationBuckets {
+			if i < len(h.Buckets) {
+				cumulative += h.Buckets[i]
+			}
+			mw.sample("gominer_kernel_duration_seconds_bucket", float64(cumulative), "device", device, "name", d.Name, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		mw.sample("gominer_kernel_duration_seconds_bucket", float64(h.Count), "device", device, "name", d.Name, "le", "+Inf")
+		mw.sample("gominer_kernel_duration_seconds_sum", h.Sum, "device", device, "name", d.Name)
+		mw.sample("gominer_kernel_duration_seconds_count", float64(h.Count), "device", device, "name", d.Name)
+	}
+
+	mw.header("gominer_shares_total", "counter", "Number of shares found by a device per pool and result.")
+	for _, share := range s.Stats.Shares() {
+		mw.sample("gominer_shares_total", float64(share.Count), "device", strconv.Itoa(share.MinerID), "name", names[share.MinerID], "pool", share.Pool, "result", share.Result.String())
+	}
+
+	pools := poolStatus(s.Client)
+	mw.header("gominer_pool_active", "gauge", "Whether the pool is currently mined on.")
+	for _, p := range pools {
+		mw.sample("gominer_pool_active", boolValue(p.Active), "pool", p.URL)
+	}
+	mw.header("gominer_pool_connected", "gauge", "Whether the connection to the pool is up.")
+	for _, p := range pools {
+		mw.sample("gominer_pool_connected", boolValue(p.Connected), "pool", p.URL)
+	}
+	mw.header("gominer_pool_reconnects_total", "counter", "Number of times the connection to the pool was lost.")
+	for _, p := range pools {
+		mw.sample("gominer_pool_reconnects_total", float64(p.Reconnects), "pool", p.URL)
+	}
+	mw.header("gominer_pool_jobs_total", "counter", "Number of jobs received from the pool.")
+	for _, p := range pools {
+		mw.sample("gominer_pool_jobs_total", float64(p.Jobs), "pool", p.URL)
+	}
+	mw.header("gominer_pool_last_job_age_seconds", "gauge", "Number of seconds since the last job was received from the pool.")
+	for _, p := range pools {
+		if !p.JobReceived.IsZero() {
+			mw.sample("gominer_pool_last_job_age_seconds", time.Since(p.JobReceived).Seconds(), "pool", p.URL)
+		}
+	}
+	mw.header("gominer_pool_difficulty", "gauge", "Current share difficulty of the pool.")
+	for _, p := range pools {
+		mw.sample("gominer_pool_difficulty", p.Difficulty, "pool", p.URL)
+	}
+	return mw.w.Flush()
+}
+
+//boolValue converts a bool to a metric value
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//serveMetrics serves the metrics in the prometheus text format
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := s.writeMetrics(w); err != nil {
+		apiLog.Error("Unable to write the metrics -", err)
+	}
+}
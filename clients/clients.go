@@ -1,6 +1,15 @@
 //Package clients provides some utilities and common code for specific client implementations
 package clients
 
+import (
+	"time"
+
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+//poolsLog logs the choices between multiple pools
+var poolsLog = logging.New("pools")
+
 //HeaderReporter defines the required method a SIA client or pool client should implement for miners to be able to report solved headers
 type HeaderReporter interface {
 	//SubmitHeader reports a solved header
@@ -29,6 +38,85 @@ type Client interface {
 	SetDeprecatedJobCall(call DeprecatedJobCall)
 }
 
+//HashRateReceiver is implemented by clients that use the measured hashrate, to suggest a difficulty for example
+type HashRateReceiver interface {
+	//SetHashRate reports the total hashrate of all devices in MH/s
+	SetHashRate(hashRate float64)
+}
+
+//PoolStatus is the state of the connection to a pool or sia daemon
+type PoolStatus struct {
+	URL string `json:"url"`
+	//Active is false for the pools that are connected but not mined on
+	Active    bool `json:"active"`
+	Connected bool `json:"connected"`
+	//Job identifies the current work, the stratum job id or the id of the parent block
+	Job         string    `json:"job"`
+	JobReceived time.Time `json:"jobreceived"`
+	Difficulty  float64   `json:"difficulty"`
+	//Jobs is the number of jobs received and Reconnects the number of times the connection was lost
+	Jobs       uint64 `json:"jobs"`
+	Reconnects uint64 `json:"reconnects"`
+	//Latency is the time the pool took to answer the last submitted share or work request, in nanoseconds in json
+	Latency time.Duration `json:"latency"`
+}
+
+//StatusReporter is implemented by clients that can report the state of their pools
+type StatusReporter interface {
+	PoolStatus() []PoolStatus
+}
+
+//PoolSwitcher is implemented by clients with multiple pools that can be told which pool to mine on
+type PoolSwitcher interface {
+	//SwitchPool only mines on the pool with this index, in the order the pools are configured, -1 restores the automatic selection
+	SwitchPool(pool int) error
+}
+
+//UserSetter is implemented by clients that can change the user the work is done for without reconnecting
+type UserSetter interface {
+	SetUser(user string) error
+}
+
+//JobPoolReporter is implemented by clients that hand out jobs from multiple pools
+type JobPoolReporter interface {
+	//JobPool returns the url of the pool a job handed out by the client comes from
+	JobPool(job interface{}) string
+}
+
+//JobPool returns the url of the pool a job handed out by the client comes from, "" if the client does not report it
+func JobPool(client interface{}, job interface{}) string {
+	if reporter, ok := client.(JobPoolReporter); ok {
+		return reporter.JobPool(job)
+	}
+	if reporter, ok := client.(StatusReporter); ok {
+		if status := reporter.PoolStatus(); len(status) == 1 {
+			return status[0].URL
+		}
+	}
+	return ""
+}
+
+//JobDetails identify the job a solution is submitted for, the fields that do not apply to a pool are empty
+type JobDetails struct {
+	JobID string
+	//ExtraNonce2 is the hex encoded extranonce2 of a stratum job
+	ExtraNonce2 string
+}
+
+//JobDetailsReporter is implemented by clients that can describe the jobs they hand out
+type JobDetailsReporter interface {
+	JobDetails(job interface{}) JobDetails
+}
+
+//DescribeJob returns the details of a job handed out by the client, a job that is a string is its own id
+func DescribeJob(client interface{}, job interface{}) (details JobDetails) {
+	if reporter, ok := client.(JobDetailsReporter); ok {
+		return reporter.JobDetails(job)
+	}
+	details.JobID, _ = job.(string)
+	return
+}
+
 //BaseClient implements some common properties and functionality
 type BaseClient struct {
 	deprecationChannels map[string]chan bool
@@ -54,6 +142,9 @@ func (sc *BaseClient) DeprecateOutstandingJobs() {
 
 // AddJobToDeprecate add the jobid to the list of jobs that should be deprecated when the times comes
 func (sc *BaseClient) AddJobToDeprecate(jobid string) {
+	if sc.deprecationChannels == nil {
+		sc.deprecationChannels = make(map[string]chan bool)
+	}
 	sc.deprecationChannels[jobid] = make(chan bool)
 }
 
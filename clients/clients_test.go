@@ -1 +1,43 @@
 package clients
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBaseClientDeprecation(t *testing.T) {
+	bc := &BaseClient{}
+	called := make(chan bool, 1)
+	bc.SetDeprecatedJobCall(func() { called <- true })
+
+	bc.AddJobToDeprecate("job1")
+	bc.AddJobToDeprecate("job2")
+	job1 := bc.GetDeprecationChannel("job1")
+	job2 := bc.GetDeprecationChannel("job2")
+	if job1 == nil || job2 == nil {
+		t.Fatal("No deprecation channel for an added job")
+	}
+	if bc.GetDeprecationChannel("unknown") != nil {
+		t.Error("Deprecation channel returned for an unknown job")
+	}
+
+	bc.DeprecateOutstandingJobs()
+	for _, c := range []chan bool{job1, job2} {
+		select {
+		case <-c:
+		default:
+			t.Error("Deprecation channel not closed")
+		}
+	}
+	if bc.GetDeprecationChannel("job1") != nil {
+		t.Error("Deprecated job is still outstanding")
+	}
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Error("Deprecated job call not executed")
+	}
+
+	//Deprecating without outstanding jobs should not fail
+	bc.DeprecateOutstandingJobs()
+}
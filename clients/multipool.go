@@ -0,0 +1,297 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+//Pool is an upstream client of a MultiPoolClient together with its share of the work
+type Pool struct {
+	//Name identifies the pool in the logs and statistics
+	Name   string
+	Client Client
+	//Weight is the relative share of the work handed out for this pool
+	Weight float64
+	//Priority orders the pools for failover, only the pools with the lowest priority that have work get work
+	Priority int
+}
+
+//PoolStats are the statistics of a single pool of a MultiPoolClient
+type PoolStats struct {
+	Name   string
+	Weight float64
+	//Work is the number of headers handed out for this pool
+	Work     uint64
+	Accepted uint64
+	Rejected uint64
+}
+
+//MultiPoolClient keeps several upstream clients connected and hands out work from each of them in proportion to their weights.
+// Every header is a full nonce space to search, so the headers are distributed using a smooth weighted round robin.
+// If a pool has no work, the work is taken from the next one in line.
+// Only the pools with the lowest Priority are used, the pools with a higher one are fallbacks for when none of them has work.
+// Solutions are submitted to the pool the job came from.
+// SwitchPool overrides the selection, the pools are then only used when the chosen one has no work.
+type MultiPoolClient struct {
+	mutex sync.Mutex // protects following
+	pools []*poolState
+	//pinned is the pool selected with SwitchPool, nil if the work is divided
+	pinned            *poolState
+	deprecatedJobCall DeprecatedJobCall
+}
+
+type poolState struct {
+	Pool
+	//current is the smooth weighted round robin credit of the pool
+	current float64
+	stats   PoolStats
+}
+
+//multiPoolJob wraps the job of an upstream client so the solution can be submitted to the right pool
+type multiPoolJob struct {
+	pool *poolState
+	job  interface{}
+}
+
+//NewMultiPoolClient creates a MultiPoolClient for the pools, every pool should have a positive weight
+func NewMultiPoolClient(pools []Pool) (mc *MultiPoolClient, err error) {
+	if len(pools) == 0 {
+		err = errors.New("No pools configured")
+		return
+	}
+	mc = &MultiPoolClient{}
+	for i, p := range pools {
+		if p.Weight <= 0 {
+			err = fmt.Errorf("The weight of pool %d should be positive", i)
+			return
+		}
+		if p.Name == "" {
+			p.Name = fmt.Sprint(i)
+		}
+		mc.pools = append(mc.pools, &poolState{Pool: p, stats: PoolStats{Name: p.Name, Weight: p.Weight}})
+	}
+	return
+}
+
+//Start starts all upstream clients
+func (mc *MultiPoolClient) Start() {
+	for _, p := range mc.pools {
+		p.Client.Start()
+	}
+}
+
+//SetDeprecatedJobCall sets the function to be called when the previous jobs of any of the pools should be abandoned
+func (mc *MultiPoolClient) SetDeprecatedJobCall(call DeprecatedJobCall) {
+	mc.mutex.Lock()
+	mc.deprecatedJobCall = call
+	mc.mutex.Unlock()
+	for _, p := range mc.pools {
+		p.Client.SetDeprecatedJobCall(call)
+	}
+}
+
+//next selects the pool the next header should come from, the pools in excluded are skipped
+func (mc *MultiPoolClient) next(excluded map[*poolState]bool) (selected *poolState) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if mc.pinned != nil && !excluded[mc.pinned] {
+		return mc.pinned
+	}
+	priority := 0
+	first := true
+	for _, p := range mc.pools {
+		if !excluded[p] && (first || p.Priority < priority) {
+			priority = p.Priority
+			first = false
+		}
+	}
+	var total float64
+	for _, p := range mc.pools {
+		if excluded[p] || p.Priority != priority {
+			continue
+		}
+		p.current += p.Weight
+		total += p.Weight
+		if selected == nil || p.current > selected.current {
+			selected = p
+		}
+	}
+	if selected != nil {
+		selected.current -= total
+	}
+	return
+}
+
+//GetHeaderForWork fetches work from the next pool in line
+func (mc *MultiPoolClient) GetHeaderForWork() (target, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	excluded := make(map[*poolState]bool, len(mc.pools))
+	for p := mc.next(excluded); p != nil; p = mc.next(excluded) {
+		var poolJob interface{}
+		target, header, deprecationChannel, poolJob, err = p.Client.GetHeaderForWork()
+		if err == nil {
+			mc.mutex.Lock()
+			p.stats.Work++
+			mc.mutex.Unlock()
+			job = multiPoolJob{pool: p, job: poolJob}
+			return
+		}
+		poolsLog.Error("Unable to fetch work from pool", p.Name, "-", err)
+		//Do not let a failing pool build up credit
+		mc.mutex.Lock()
+		p.current = 0
+		mc.mutex.Unlock()
+		excluded[p] = true
+	}
+	err = errors.New("None of the pools has work available")
+	return
+}
+
+//SubmitHeader submits a solution to the pool the job came from
+func (mc *MultiPoolClient) SubmitHeader(header []byte, job interface{}) (err error) {
+	mj, ok := job.(multiPoolJob)
+	if !ok {
+		err = errors.New("Job is not handed out by this client")
+		return
+	}
+	err = mj.pool.Client.SubmitHeader(header, mj.job)
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if err != nil {
+		mj.pool.stats.Rejected++
+		err = fmt.Errorf("pool %s: %w", mj.pool.Name, err)
+		return
+	}
+	mj.pool.stats.Accepted++
+	return
+}
+
+//SetHashRate passes the share of the hashrate each pool gets to the pools that use it,
+// the pools with a higher priority than the lowest one are assumed to get nothing
+func (mc *MultiPoolClient) SetHashRate(hashRate float64) {
+	priority := mc.pools[0].Priority
+	for _, p := range mc.pools {
+		if p.Priority < priority {
+			priority = p.Priority
+		}
+	}
+	var total float64
+	for _, p := range mc.pools {
+		if p.Priority == priority {
+			total += p.Weight
+		}
+	}
+	for _, p := range mc.pools {
+		if receiver, ok := p.Client.(HashRateReceiver); ok && p.Priority == priority {
+			receiver.SetHashRate(hashRate * p.Weight / total)
+		}
+	}
+}
+
+//PoolStatus returns the state of all pools, only the pools with the lowest priority or the one selected with SwitchPool are active
+func (mc *MultiPoolClient) PoolStatus() (statuses []PoolStatus) {
+	priority := mc.pools[0].Priority
+	for _, p := range mc.pools {
+		if p.Priority < priority {
+			priority = p.Priority
+		}
+	}
+	mc.mutex.Lock()
+	pinned := mc.pinned
+	mc.mutex.Unlock()
+	for _, p := range mc.pools {
+		active := p.Priority == priority
+		if pinned != nil {
+			active = p == pinned
+		}
+		statuses = append(statuses, poolStatus(p.Pool, active)...)
+	}
+	return
+}
+
+//SwitchPool only takes work from the pool with this index as long as it has work, -1 divides the work again.
+// The work of the other pools that is not started yet is abandoned.
+func (mc *MultiPoolClient) SwitchPool(pool int) (err error) {
+	if pool < -1 || pool >= len(mc.pools) {
+		return fmt.Errorf("There is no pool %d", pool)
+	}
+	mc.mutex.Lock()
+	if pool < 0 {
+		mc.pinned = nil
+		poolsLog.Info("Dividing the work over the pools again")
+	} else {
+		mc.pinned = mc.pools[pool]
+		poolsLog.Info("Only mining on pool", mc.pinned.Name)
+	}
+	call := mc.deprecatedJobCall
+	mc.mutex.Unlock()
+	if call != nil && pool >= 0 {
+		go call()
+	}
+	return
+}
+
+//SetUser changes the user of all pools that support it
+func (mc *MultiPoolClient) SetUser(user string) error {
+	pools := make([]Pool, 0, len(mc.pools))
+	for _, p := range mc.pools {
+		pools = append(pools, p.Pool)
+	}
+	return setUser(pools, user)
+}
+
+//setUser changes the user of the pools that support it, an error is returned if none of them does or if any of them fails
+func setUser(pools []Pool, user string) (err error) {
+	supported := false
+	for _, p := range pools {
+		if setter, ok := p.Client.(UserSetter); ok {
+			supported = true
+			if e := setter.SetUser(user); e != nil && err == nil {
+				err = fmt.Errorf("pool %s: %w", p.Name, e)
+			}
+		}
+	}
+	if !supported {
+		err = errors.New("None of the pools supports changing the user")
+	}
+	return
+}
+
+//poolStatus returns the state of a pool, only the name is known if the client does not report it
+func poolStatus(p Pool, active bool) (statuses []PoolStatus) {
+	if reporter, ok := p.Client.(StatusReporter); ok {
+		statuses = reporter.PoolStatus()
+	} else {
+		statuses = []PoolStatus{{URL: p.Name, Active: true}}
+	}
+	for i := range statuses {
+		statuses[i].Active = statuses[i].Active && active
+	}
+	return
+}
+
+//JobPool returns the url of the pool the job comes from
+func (mc *MultiPoolClient) JobPool(job interface{}) string {
+	if mj, ok := job.(multiPoolJob); ok {
+		return JobPool(mj.pool.Client, mj.job)
+	}
+	return ""
+}
+
+//JobDetails describes the job using the client of the pool it comes from
+func (mc *MultiPoolClient) JobDetails(job interface{}) JobDetails {
+	if mj, ok := job.(multiPoolJob); ok {
+		return DescribeJob(mj.pool.Client, mj.job)
+	}
+	return JobDetails{}
+}
+
+//Stats returns the statistics of the pools, in the order they were configured
+func (mc *MultiPoolClient) Stats() (stats []PoolStats) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	for _, p := range mc.pools {
+		stats = append(stats, p.stats)
+	}
+	return
+}
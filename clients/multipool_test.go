@@ -0,0 +1,152 @@
+package clients
+
+import (
+	"errors"
+	"testing"
+)
+
+//fakeClient hands out headers containing its id
+type fakeClient struct {
+	BaseClient
+	id        byte
+	fail      bool
+	submitted int
+	user      string
+}
+
+func (fc *fakeClient) Start() {}
+
+func (fc *fakeClient) SetUser(user string) error {
+	fc.user = user
+	return nil
+}
+
+func (fc *fakeClient) GetHeaderForWork() (target, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	if fc.fail {
+		err = errors.New("No work")
+		return
+	}
+	header = []byte{fc.id}
+	job = fc.id
+	return
+}
+
+func (fc *fakeClient) SubmitHeader(header []byte, job interface{}) (err error) {
+	if job != fc.id {
+		return errors.New("Job of another pool")
+	}
+	fc.submitted++
+	return
+}
+
+func TestMultiPoolClient(t *testing.T) {
+	primary, second := &fakeClient{id: 0}, &fakeClient{id: 1}
+	mc, err := NewMultiPoolClient([]Pool{{Name: "main", Client: primary, Weight: 80}, {Name: "second", Client: second, Weight: 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make([]int, 2)
+	for i := 0; i < 100; i++ {
+		_, header, _, job, err := mc.GetHeaderForWork()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[header[0]]++
+		if err = mc.SubmitHeader(header, job); err != nil {
+			t.Error(err)
+		}
+	}
+	if counts[0] != 80 || counts[1] != 20 {
+		t.Error("Work distributed as", counts, "instead of [80 20]")
+	}
+	if primary.submitted != 80 || second.submitted != 20 {
+		t.Error("Solutions submitted as", primary.submitted, second.submitted, "instead of 80 20")
+	}
+	stats := mc.Stats()
+	if stats[0].Work != 80 || stats[0].Accepted != 80 || stats[1].Work != 20 || stats[1].Accepted != 20 {
+		t.Error("Unexpected stats:", stats)
+	}
+
+	//The work of a failing pool goes to the other one
+	primary.fail = true
+	for i := 0; i < 10; i++ {
+		if _, header, _, _, err := mc.GetHeaderForWork(); err != nil || header[0] != 1 {
+			t.Fatal("Work not taken from the remaining pool:", header, err)
+		}
+	}
+	second.fail = true
+	if _, _, _, _, err = mc.GetHeaderForWork(); err == nil {
+		t.Error("Expected an error when no pool has work")
+	}
+
+	if err = mc.SubmitHeader([]byte{0}, 0); err == nil {
+		t.Error("Expected an error submitting an unknown job")
+	}
+	if _, err = NewMultiPoolClient([]Pool{{Client: primary, Weight: 0}}); err == nil {
+		t.Error("Expected an error for a pool without weight")
+	}
+}
+
+func TestMultiPoolClientPriority(t *testing.T) {
+	primary, backup := &fakeClient{id: 0}, &fakeClient{id: 1}
+	mc, err := NewMultiPoolClient([]Pool{{Name: "backup", Client: backup, Weight: 1, Priority: 1}, {Name: "primary", Client: primary, Weight: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, header, _, _, err := mc.GetHeaderForWork(); err != nil || header[0] != 0 {
+			t.Fatal("Work not taken from the primary pool:", header, err)
+		}
+	}
+	primary.fail = true
+	if _, header, _, _, err := mc.GetHeaderForWork(); err != nil || header[0] != 1 {
+		t.Fatal("Work not taken from the backup pool:", header, err)
+	}
+	primary.fail = false
+	if _, header, _, _, err := mc.GetHeaderForWork(); err != nil || header[0] != 0 {
+		t.Error("Not back on the primary pool:", header, err)
+	}
+}
+
+func TestMultiPoolClientSwitchPool(t *testing.T) {
+	first, second := &fakeClient{id: 0}, &fakeClient{id: 1}
+	mc, err := NewMultiPoolClient([]Pool{{Name: "first", Client: first, Weight: 1}, {Name: "second", Client: second, Weight: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mc.SwitchPool(1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, header, _, _, err := mc.GetHeaderForWork(); err != nil || header[0] != 1 {
+			t.Fatal("Work not taken from the selected pool:", header, err)
+		}
+	}
+	if status := mc.PoolStatus(); status[0].Active || !status[1].Active {
+		t.Error("Unexpected active pools:", status)
+	}
+	second.fail = true
+	if _, header, _, _, err := mc.GetHeaderForWork(); err != nil || header[0] != 0 {
+		t.Error("Work not taken from the other pool when the selected one has none:", header, err)
+	}
+	second.fail = false
+	if err = mc.SwitchPool(-1); err != nil {
+		t.Fatal(err)
+	}
+	counts := make([]int, 2)
+	for i := 0; i < 10; i++ {
+		_, header, _, _, _ := mc.GetHeaderForWork()
+		counts[header[0]]++
+	}
+	if counts[0] != 5 || counts[1] != 5 {
+		t.Error("Work distributed as", counts, "instead of [5 5]")
+	}
+	if err = mc.SwitchPool(2); err == nil {
+		t.Error("Expected an error switching to an unknown pool")
+	}
+
+	if err = mc.SetUser("address.rig"); err != nil || first.user != "address.rig" || second.user != "address.rig" {
+		t.Error("User not set on all pools:", err, first.user, second.user)
+	}
+}
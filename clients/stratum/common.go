@@ -21,6 +21,9 @@ func HexStringToBytes(v interface{}) (result []byte, err error) {
 	return
 }
 
+//ErrExtraNonce2Overflow is returned when the extranonce2 can not be incremented without exceeding its size
+var ErrExtraNonce2Overflow = errors.New("ExtraNonce2 overflow, all values for its size are used")
+
 //ExtraNonce2 is the nonce modified by the miner
 type ExtraNonce2 struct {
 	Value uint64
@@ -37,8 +40,19 @@ func (en *ExtraNonce2) Bytes() (b []byte) {
 }
 
 //Increment increases the nonce with 1, an error is returned if the resulting is value is bigger than possible given the size
+// If the value would overflow, it is left unchanged and ErrExtraNonce2Overflow is returned
 func (en *ExtraNonce2) Increment() (err error) {
+	if en.Value == en.max() {
+		return ErrExtraNonce2Overflow
+	}
 	en.Value++
-	//TODO: check if does not overflow compared to the allowed size
 	return
 }
+
+//max returns the highest value that fits in the size of the extranonce2
+func (en *ExtraNonce2) max() uint64 {
+	if en.Size >= 8 {
+		return ^uint64(0)
+	}
+	return uint64(1)<<(8*en.Size) - 1
+}
@@ -27,3 +27,30 @@ func TestExtraNonce2(t *testing.T) {
 		t.Error(result, "returned instead of", expected)
 	}
 }
+
+func TestExtraNonce2Overflow(t *testing.T) {
+	en := ExtraNonce2{Value: 0xfffe, Size: 2}
+	if err := en.Increment(); err != nil {
+		t.Error("Error from the increment call:", err)
+	}
+	if err := en.Increment(); err != ErrExtraNonce2Overflow {
+		t.Error("Expected an overflow error instead of", err)
+	}
+	expected := "ffff"
+	if result := hex.EncodeToString(en.Bytes()); result != expected {
+		t.Error(result, "returned instead of", expected)
+	}
+
+	en = ExtraNonce2{Value: 0, Size: 0}
+	if err := en.Increment(); err != ErrExtraNonce2Overflow {
+		t.Error("Expected an overflow error for a zero size extranonce2 instead of", err)
+	}
+
+	en = ExtraNonce2{Value: 0xfffffffffffffffe, Size: 8}
+	if err := en.Increment(); err != nil {
+		t.Error("Error from the increment call:", err)
+	}
+	if err := en.Increment(); err != ErrExtraNonce2Overflow {
+		t.Error("Expected an overflow error instead of", err)
+	}
+}
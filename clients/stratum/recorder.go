@@ -0,0 +1,103 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//The directions of a recorded frame
+const (
+	//FrameConnect marks the start of a new connection, the line is the address connected to
+	FrameConnect = "connect"
+	//FrameIn is a line received from the stratum server
+	FrameIn = "in"
+	//FrameOut is a line sent to the stratum server
+	FrameOut = "out"
+)
+
+//Frame is a single line on the wire together with the time and direction it was seen
+type Frame struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"dir"`
+	Line      string    `json:"line"`
+}
+
+//Recorder writes the frames of stratum sessions as json lines, it is safe to share between clients
+type Recorder struct {
+	mutex  sync.Mutex // protects following
+	w      io.Writer
+	closer io.Closer
+}
+
+//NewRecorder creates a Recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+//CreateRecorder creates a Recorder that appends to the specified file
+func CreateRecorder(filename string) (r *Recorder, err error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	r = &Recorder{w: f, closer: f}
+	return
+}
+
+//Close closes the underlying file if the Recorder was created using CreateRecorder
+func (r *Recorder) Close() (err error) {
+	if r == nil || r.closer == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closer.Close()
+}
+
+//Record writes a frame, errors are ignored since recording should never interrupt mining.
+// It is safe to call Record on a nil Recorder.
+func (r *Recorder) Record(direction, line string) {
+	if r == nil {
+		return
+	}
+	rawframe, err := json.Marshal(Frame{Time: time.Now(), Direction: direction, Line: strings.TrimRight(line, "\r\n")})
+	if err != nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.w.Write(append(rawframe, '\n'))
+}
+
+//ReadFrames reads the frames written by a Recorder
+func ReadFrames(reader io.Reader) (frames []Frame, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var f Frame
+		if err = json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			return
+		}
+		frames = append(frames, f)
+	}
+	err = scanner.Err()
+	return
+}
+
+//ReadFramesFromFile reads the frames recorded in a file
+func ReadFramesFromFile(filename string) (frames []Frame, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return ReadFrames(f)
+}
@@ -0,0 +1,39 @@
+package stratum
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	var buffer bytes.Buffer
+	r := NewRecorder(&buffer)
+	r.Record(FrameConnect, "localhost:3333")
+	r.Record(FrameOut, "{\"id\":1,\"method\":\"mining.subscribe\",\"params\":[]}\n")
+	r.Record(FrameIn, "{this is not json\n")
+
+	frames, err := ReadFrames(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Frame{
+		{Direction: FrameConnect, Line: "localhost:3333"},
+		{Direction: FrameOut, Line: "{\"id\":1,\"method\":\"mining.subscribe\",\"params\":[]}"},
+		{Direction: FrameIn, Line: "{this is not json"},
+	}
+	if len(frames) != len(expected) {
+		t.Fatal(len(frames), "frames read instead of", len(expected))
+	}
+	for i, f := range frames {
+		if f.Direction != expected[i].Direction || f.Line != expected[i].Line {
+			t.Error("Frame", i, "is", f, "instead of", expected[i])
+		}
+		if f.Time.IsZero() {
+			t.Error("No time recorded for frame", i)
+		}
+	}
+
+	//Recording on a nil recorder is a noop
+	var nilRecorder *Recorder
+	nilRecorder.Record(FrameIn, "")
+}
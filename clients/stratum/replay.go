@@ -0,0 +1,202 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+var replayLog = logging.New("replay")
+
+//ReplayRequestTimeout is the time a ReplayServer waits for a client to send a recorded request
+const ReplayRequestTimeout = 10 * time.Second
+
+//ReplayServer serves recorded stratum sessions to a client.
+// Every accepted connection gets the next recorded session, the lines that were received are sent in the same order
+// and before sending the reply on a request, the ReplayServer waits for the client to send that request.
+// The ids of the replies are replaced by the ids used by the client.
+type ReplayServer struct {
+	//Realtime makes the ReplayServer wait between the lines as long as in the recorded session
+	Realtime bool
+	//Ignore lists the methods that are not replayed, the recorded requests are skipped together with their replies
+	// and the requests the client sends are accepted right away. By default, mining.submit is ignored since the shares
+	// found during a replay will never match the recorded ones.
+	Ignore []string
+
+	listener net.Listener
+	sessions [][]Frame
+	done     chan bool
+
+	mutex sync.Mutex // protects following
+	next  int
+	//replaying is the number of sessions that are not completely replayed yet
+	replaying int
+}
+
+//NewReplayServer creates a ReplayServer for the recorded frames, the frames are split in sessions on the FrameConnect frames
+func NewReplayServer(frames []Frame) (s *ReplayServer) {
+	s = &ReplayServer{done: make(chan bool), Ignore: []string{"mining.submit"}}
+	var session []Frame
+	for _, f := range frames {
+		if f.Direction == FrameConnect {
+			if len(session) > 0 {
+				s.sessions = append(s.sessions, session)
+			}
+			session = nil
+			continue
+		}
+		session = append(session, f)
+	}
+	if len(session) > 0 {
+		s.sessions = append(s.sessions, session)
+	}
+	s.replaying = len(s.sessions)
+	return
+}
+
+//Sessions returns the number of recorded sessions
+func (s *ReplayServer) Sessions() int {
+	return len(s.sessions)
+}
+
+//Listen starts accepting connections on the specified network address
+func (s *ReplayServer) Listen(address string) (err error) {
+	s.listener, err = net.Listen("tcp", address)
+	if err != nil {
+		return
+	}
+	if len(s.sessions) == 0 {
+		close(s.done)
+	}
+	go s.serve()
+	return
+}
+
+//Addr returns the network address the server is listening on
+func (s *ReplayServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+//Done returns a channel that is closed when all recorded sessions are replayed
+func (s *ReplayServer) Done() <-chan bool {
+	return s.done
+}
+
+//Close stops listening
+func (s *ReplayServer) Close() {
+	s.listener.Close()
+}
+
+func (s *ReplayServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		index := s.next
+		s.next++
+		s.mutex.Unlock()
+		if index >= len(s.sessions) {
+			replayLog.Warn("No recorded sessions left to replay to", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go func() {
+			replayLog.Info("Replaying session", index+1, "of", len(s.sessions), "to", conn.RemoteAddr())
+			if err := s.replaySession(conn, s.sessions[index]); err != nil {
+				replayLog.Error("Replay of session", index+1, "failed -", err)
+			}
+			conn.Close()
+			s.mutex.Lock()
+			s.replaying--
+			if s.replaying == 0 {
+				close(s.done)
+			}
+			s.mutex.Unlock()
+		}()
+	}
+}
+
+//replaySession sends the received lines of a session to the client and waits for the sent requests
+func (s *ReplayServer) replaySession(conn net.Conn, frames []Frame) (err error) {
+	ignored := make(map[string]bool, len(s.Ignore))
+	for _, method := range s.Ignore {
+		quoted, _ := json.Marshal(method)
+		ignored[string(quoted)] = true
+	}
+	reader := bufio.NewReader(conn)
+	//ids maps the recorded request ids to the ones the client uses
+	ids := make(map[string]json.RawMessage)
+	//skipped contains the recorded ids of the ignored requests
+	skipped := make(map[string]bool)
+	previous := frames[0].Time
+	for _, f := range frames {
+		if s.Realtime && f.Time.After(previous) {
+			time.Sleep(f.Time.Sub(previous))
+		}
+		previous = f.Time
+
+		switch f.Direction {
+		case FrameOut:
+			recorded := replayMessage(f.Line)
+			if ignored[string(recorded["method"])] {
+				skipped[string(recorded["id"])] = true
+				continue
+			}
+			var actual map[string]json.RawMessage
+			for {
+				conn.SetReadDeadline(time.Now().Add(ReplayRequestTimeout))
+				var line string
+				if line, err = reader.ReadString('\n'); err != nil {
+					return
+				}
+				actual = replayMessage(line)
+				if !ignored[string(actual["method"])] {
+					break
+				}
+				if _, err = conn.Write([]byte(`{"id":` + string(actual["id"]) + `,"result":true,"error":null}` + "\n")); err != nil {
+					return
+				}
+			}
+			if recorded["method"] != nil && string(recorded["method"]) != string(actual["method"]) {
+				replayLog.Warn("Replay expected a", string(recorded["method"]), "request but received", string(actual["method"]))
+			}
+			if recorded["id"] != nil && actual["id"] != nil {
+				ids[string(recorded["id"])] = actual["id"]
+			}
+		case FrameIn:
+			line := f.Line
+			message := replayMessage(line)
+			if message["id"] != nil && skipped[string(message["id"])] && message["method"] == nil {
+				continue
+			}
+			if id, found := ids[string(message["id"])]; found && message["id"] != nil {
+				message["id"] = id
+				if rawmessage, e := json.Marshal(message); e == nil {
+					line = string(rawmessage)
+				}
+			}
+			if _, err = conn.Write([]byte(line + "\n")); err != nil {
+				return
+			}
+		default:
+			err = errors.New("Unknown frame direction " + f.Direction)
+			return
+		}
+	}
+	return
+}
+
+//replayMessage decodes a line for inspection, nil is returned for malformed lines
+func replayMessage(line string) (message map[string]json.RawMessage) {
+	if json.Unmarshal([]byte(line), &message) != nil {
+		return nil
+	}
+	return
+}
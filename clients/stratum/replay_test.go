@@ -0,0 +1,124 @@
+package stratum
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayServer(t *testing.T) {
+	frames := []Frame{
+		{Direction: FrameConnect, Line: "pool:3333"},
+		{Direction: FrameOut, Line: `{"method":"mining.subscribe","params":["gominer"],"id":7}`},
+		{Direction: FrameIn, Line: `{"id":7,"result":[null,"aabbccdd",4],"error":null}`},
+		{Direction: FrameIn, Line: `{"id":null,"method":"mining.set_difficulty","params":[2]}`},
+		{Direction: FrameOut, Line: `{"method":"mining.submit","params":["user","job1","00000000","6400000000000000","0000000000000001"],"id":8}`},
+		{Direction: FrameIn, Line: `{"id":8,"result":false,"error":[23,"Low difficulty share",null]}`},
+		{Direction: FrameOut, Line: `{"method":"mining.authorize","params":["user",""],"id":9}`},
+		{Direction: FrameIn, Line: `{"id":9,"result":"authorized","error":null}`},
+		{Direction: FrameConnect, Line: "pool:3333"},
+		{Direction: FrameIn, Line: `{"id":null,"method":"mining.set_difficulty","params":[4]}`},
+	}
+	s := NewReplayServer(frames)
+	if s.Sessions() != 2 {
+		t.Fatal(s.Sessions(), "sessions instead of 2")
+	}
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i, expectedDifficulty := range []float64{2, 4} {
+		notifications := make(chan []interface{}, 1)
+		c := &Client{}
+		c.SetNotificationHandler("mining.set_difficulty", func(params []interface{}) {
+			notifications <- params
+		})
+		if err := c.Dial(s.Addr().String()); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			//The recorded id 7 should be replaced by the one of the client
+			result, err := c.Call("mining.subscribe", []string{"gominer"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply, ok := result.([]interface{}); !ok || len(reply) != 3 || reply[1] != "aabbccdd" {
+				t.Error("Unexpected reply on subscribe:", result)
+			}
+			//Submits are not replayed but accepted
+			if _, err = c.Call("mining.submit", []string{"user", "job2", "00000001", "6400000000000000", "0000000000000002"}); err != nil {
+				t.Error("Submit not accepted:", err)
+			}
+			if result, err = c.Call("mining.authorize", []string{"user", ""}); err != nil || result != "authorized" {
+				t.Error("Unexpected reply on authorize:", result, err)
+			}
+		}
+		select {
+		case params := <-notifications:
+			if len(params) != 1 || params[0] != expectedDifficulty {
+				t.Error("Unexpected notification parameters in session", i+1, ":", params)
+			}
+		case <-time.After(time.Second):
+			t.Error("No notification received in session", i+1)
+		}
+		c.Close()
+	}
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Error("Replay not done after all sessions")
+	}
+}
+
+func TestReplayServerDone(t *testing.T) {
+	frames := []Frame{
+		{Direction: FrameConnect, Line: "pool:3333"},
+		{Direction: FrameOut, Line: `{"method":"mining.subscribe","params":["gominer"],"id":1}`},
+		{Direction: FrameIn, Line: `{"id":1,"result":[null,"aabbccdd",4],"error":null}`},
+		{Direction: FrameConnect, Line: "pool:3333"},
+		{Direction: FrameIn, Line: `{"id":null,"method":"mining.set_difficulty","params":[4]}`},
+	}
+	s := NewReplayServer(frames)
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	first := &Client{}
+	if err := first.Dial(s.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	//Make sure the first connection gets the first session
+	time.Sleep(50 * time.Millisecond)
+	notifications := make(chan []interface{}, 1)
+	second := &Client{}
+	second.SetNotificationHandler("mining.set_difficulty", func(params []interface{}) {
+		notifications <- params
+	})
+	if err := second.Dial(s.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	select {
+	case <-notifications:
+	case <-time.After(time.Second):
+		t.Fatal("The second session is not replayed")
+	}
+
+	//The first session still waits for the subscription
+	select {
+	case <-s.Done():
+		t.Error("Replay done while the first session is not finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := first.Call("mining.subscribe", []string{"gominer"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Error("Replay not done after all sessions")
+	}
+}
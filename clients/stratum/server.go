@@ -0,0 +1,249 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+var serverLog = logging.New("stratum.server")
+
+//Error is a stratum error as returned to the client, it is serialized as [code, message, null]
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+//MarshalJSON serializes the error in the stratum [code, message, traceback] form
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+//The error codes commonly used by stratum servers
+var (
+	ErrOther              = &Error{Code: 20, Message: "Other/Unknown"}
+	ErrJobNotFound        = &Error{Code: 21, Message: "Job not found"}
+	ErrDuplicateShare     = &Error{Code: 22, Message: "Duplicate share"}
+	ErrLowDifficultyShare = &Error{Code: 23, Message: "Low difficulty share"}
+	ErrUnauthorizedWorker = &Error{Code: 24, Message: "Unauthorized worker"}
+	ErrNotSubscribed      = &Error{Code: 25, Message: "Not subscribed"}
+)
+
+// serverRequest is a request as received from a stratum client, the params are not restricted to strings
+type serverRequest struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// serverResponse is the reply on a serverRequest
+type serverResponse struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	Error  *Error      `json:"error"`
+}
+
+// serverNotification is sent to a client without being requested
+type serverNotification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+//RequestHandler handles a request of a connected client and returns the result or an error to send back
+type RequestHandler func(c *ServerConn, params []interface{}) (result interface{}, err error)
+
+//ConnectionCallback is the type of function that can be registered to be notified of (dis)connecting clients
+type ConnectionCallback func(c *ServerConn)
+
+//Server accepts stratum connections and dispatches the requests to the registered handlers
+type Server struct {
+	ConnectCallback    ConnectionCallback
+	DisconnectCallback ConnectionCallback
+
+	listener        net.Listener
+	requestHandlers map[string]RequestHandler
+
+	mutex       sync.Mutex // protects following
+	connections map[*ServerConn]bool
+}
+
+//SetRequestHandler registers a function to handle requests for a specific method.
+// This function is not threadsafe and all requesthandlers should be set prior to calling the Listen function
+func (s *Server) SetRequestHandler(method string, handler RequestHandler) {
+	if s.requestHandlers == nil {
+		s.requestHandlers = make(map[string]RequestHandler)
+	}
+	s.requestHandlers[method] = handler
+}
+
+//Listen starts accepting stratum connections on the specified network address
+func (s *Server) Listen(address string) (err error) {
+	s.listener, err = net.Listen("tcp", address)
+	if err != nil {
+		return
+	}
+	go s.serve()
+	return
+}
+
+//Addr returns the network address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+//Close stops listening and drops all connected clients
+func (s *Server) Close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for _, c := range s.Connections() {
+		c.Close()
+	}
+}
+
+//Connections returns the currently connected clients
+func (s *Server) Connections() (connections []*ServerConn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	connections = make([]*ServerConn, 0, len(s.connections))
+	for c := range s.connections {
+		connections = append(connections, c)
+	}
+	return
+}
+
+//Broadcast sends a notification to all connected clients
+func (s *Server) Broadcast(method string, params []interface{}) {
+	for _, c := range s.Connections() {
+		if err := c.Notify(method, params); err != nil {
+			serverLog.Error("Unable to notify", c.RemoteAddr(), "-", err)
+		}
+	}
+}
+
+func (s *Server) serve() {
+	for {
+		socket, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &ServerConn{socket: socket, server: s}
+		s.mutex.Lock()
+		if s.connections == nil {
+			s.connections = make(map[*ServerConn]bool)
+		}
+		s.connections[c] = true
+		s.mutex.Unlock()
+		go c.listen()
+	}
+}
+
+func (s *Server) dispatch(c *ServerConn, r serverRequest) (result interface{}, err error) {
+	handler, found := s.requestHandlers[r.Method]
+	if !found {
+		err = &Error{Code: ErrOther.Code, Message: "Unsupported method " + r.Method}
+		return
+	}
+	return handler(c, r.Params)
+}
+
+func (s *Server) removeConnection(c *ServerConn) {
+	s.mutex.Lock()
+	delete(s.connections, c)
+	s.mutex.Unlock()
+	if s.DisconnectCallback != nil {
+		s.DisconnectCallback(c)
+	}
+}
+
+//ServerConn is a connection of a client to the Server
+type ServerConn struct {
+	socket net.Conn
+	server *Server
+
+	writeMutex sync.Mutex // protects the socket writes
+
+	afterReply []func()
+}
+
+//RemoteAddr returns the network address of the client
+func (c *ServerConn) RemoteAddr() net.Addr {
+	return c.socket.RemoteAddr()
+}
+
+//Close drops the connection
+func (c *ServerConn) Close() {
+	c.socket.Close()
+}
+
+//Notify sends a notification to the client
+func (c *ServerConn) Notify(method string, params []interface{}) (err error) {
+	return c.write(serverNotification{Method: method, Params: params})
+}
+
+//AfterReply registers a function to be executed once the reply on the request that is currently being handled is sent.
+// It should only be called from a RequestHandler and can be used to send notifications that should follow the reply.
+func (c *ServerConn) AfterReply(f func()) {
+	c.afterReply = append(c.afterReply, f)
+}
+
+func (c *ServerConn) write(message interface{}) (err error) {
+	rawmsg, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	rawmsg = append(rawmsg, []byte("\n")...)
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err = c.socket.Write(rawmsg)
+	return
+}
+
+//listen reads the requests from the client and replies to them until an error occurs (io or deserialization)
+func (c *ServerConn) listen() {
+	defer c.server.removeConnection(c)
+	defer c.socket.Close()
+
+	if c.server.ConnectCallback != nil {
+		c.server.ConnectCallback(c)
+	}
+
+	reader := bufio.NewReader(c.socket)
+	for {
+		rawmessage, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		r := serverRequest{}
+		if err = json.Unmarshal([]byte(rawmessage), &r); err != nil {
+			serverLog.Error("Invalid request from", c.RemoteAddr(), "-", err)
+			return
+		}
+		result, err := c.server.dispatch(c, r)
+		reply := serverResponse{ID: r.ID, Result: result}
+		if err != nil {
+			stratumErr, ok := err.(*Error)
+			if !ok {
+				stratumErr = &Error{Code: ErrOther.Code, Message: err.Error()}
+			}
+			reply.Result = nil
+			reply.Error = stratumErr
+		}
+		if err = c.write(reply); err != nil {
+			return
+		}
+		afterReply := c.afterReply
+		c.afterReply = nil
+		for _, f := range afterReply {
+			f()
+		}
+	}
+}
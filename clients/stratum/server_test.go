@@ -0,0 +1,59 @@
+package stratum
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	s := &Server{}
+	s.SetRequestHandler("mining.subscribe", func(c *ServerConn, params []interface{}) (result interface{}, err error) {
+		c.AfterReply(func() {
+			c.Notify("mining.set_difficulty", []interface{}{2.0})
+		})
+		result = []interface{}{params[0], "abcd", 4}
+		return
+	})
+	s.SetRequestHandler("mining.authorize", func(c *ServerConn, params []interface{}) (result interface{}, err error) {
+		err = ErrUnauthorizedWorker
+		return
+	})
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	notifications := make(chan []interface{}, 1)
+	c := &Client{}
+	c.SetNotificationHandler("mining.set_difficulty", func(params []interface{}) {
+		notifications <- params
+	})
+	if err := c.Dial(s.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.Call("mining.subscribe", []string{"gominer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, ok := result.([]interface{})
+	if !ok || len(reply) != 3 || reply[0] != "gominer" || reply[1] != "abcd" || reply[2] != 4.0 {
+		t.Error("Unexpected reply on subscribe:", result)
+	}
+	select {
+	case params := <-notifications:
+		if len(params) != 1 || params[0] != 2.0 {
+			t.Error("Unexpected notification parameters:", params)
+		}
+	case <-time.After(time.Second):
+		t.Error("No notification received")
+	}
+
+	if _, err = c.Call("mining.authorize", []string{"user", ""}); err == nil || err.Error() != ErrUnauthorizedWorker.Message {
+		t.Error("Expected an unauthorized error instead of", err)
+	}
+	if _, err = c.Call("mining.unknown", []string{}); err == nil {
+		t.Error("Expected an error on an unsupported method")
+	}
+}
@@ -7,15 +7,18 @@ import (
 	"encoding/json"
 	"errors"
 	"net"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/robvanmieghem/gominer/logging"
 )
 
 // request : A remote method is invoked by sending a request to the remote stratum service.
 type request struct {
-	Method string   `json:"method"`
-	Params []string `json:"params"`
-	ID     uint64   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	ID     uint64        `json:"id"`
 }
 
 // response is the stratum server's response on a Request
@@ -52,6 +55,11 @@ type Client struct {
 
 	ErrorCallback        ErrorCallback
 	notificationHandlers map[string]NotificationHandler
+
+	//Recorder records all lines sent and received if it is set, it should be set prior to calling the Dial function
+	Recorder *Recorder
+	//Log traces all lines sent and received at the debug level if it is set
+	Log *logging.Logger
 }
 
 //Dial connects to a stratum+tcp at the specified network address.
@@ -63,6 +71,7 @@ func (c *Client) Dial(host string) (err error) {
 		c.dispatchError(err)
 		return
 	}
+	c.Recorder.Record(FrameConnect, host)
 	go c.Listen()
 	return
 }
@@ -102,11 +111,15 @@ func (c *Client) dispatch(r response) {
 	cb, found := c.pendingCalls[r.ID]
 	var result interface{}
 	if r.Error != nil {
-		message := ""
+		//Keep the code so the callers can tell stale shares from other errors
+		stratumErr := &Error{Code: ErrOther.Code}
 		if len(r.Error) >= 2 {
-			message, _ = r.Error[1].(string)
+			if code, ok := r.Error[0].(float64); ok {
+				stratumErr.Code = int(code)
+			}
+			stratumErr.Message, _ = r.Error[1].(string)
 		}
-		result = errors.New(message)
+		result = stratumErr
 	} else {
 		result = r.Result
 	}
@@ -128,9 +141,12 @@ func (c *Client) Listen() {
 	for {
 		rawmessage, err := reader.ReadString('\n')
 		if err != nil {
+			c.cancelPendingCalls()
 			c.dispatchError(err)
 			return
 		}
+		c.Recorder.Record(FrameIn, rawmessage)
+		c.trace("Received", rawmessage)
 		r := response{}
 		err = json.Unmarshal([]byte(rawmessage), &r)
 		if err != nil {
@@ -141,6 +157,13 @@ func (c *Client) Listen() {
 	}
 }
 
+//trace logs a line sent or received at the debug level
+func (c *Client) trace(direction, line string) {
+	if c.Log != nil {
+		c.Log.Debug(direction, strings.TrimSpace(line))
+	}
+}
+
 func (c *Client) registerRequest(requestID uint64) (cb chan interface{}) {
 	c.callsMutex.Lock()
 	defer c.callsMutex.Unlock()
@@ -162,25 +185,59 @@ func (c *Client) cancelRequest(requestID uint64) {
 	}
 }
 
-//Call invokes the named function, waits for it to complete, and returns its error status.
-func (c *Client) Call(serviceMethod string, args []string) (reply interface{}, err error) {
-	r := request{Method: serviceMethod, Params: args}
+//cancelPendingCalls makes all calls waiting for a response return, no response will come once the connection is lost
+func (c *Client) cancelPendingCalls() {
+	c.callsMutex.Lock()
+	defer c.callsMutex.Unlock()
+	for requestID, cb := range c.pendingCalls {
+		close(cb)
+		delete(c.pendingCalls, requestID)
+	}
+}
+
+func (c *Client) newRequest(serviceMethod string, args []interface{}) (r request) {
+	r = request{Method: serviceMethod, Params: args}
 
 	c.seqmutex.Lock()
 	c.seq++
 	r.ID = c.seq
 	c.seqmutex.Unlock()
+	return
+}
 
+func (c *Client) send(r request) (err error) {
+	if c.socket == nil {
+		return errors.New("Not connected")
+	}
 	rawmsg, err := json.Marshal(r)
 	if err != nil {
 		return
 	}
+	c.Recorder.Record(FrameOut, string(rawmsg))
+	c.trace("Sent", string(rawmsg))
+	rawmsg = append(rawmsg, []byte("\n")...)
+	_, err = c.socket.Write(rawmsg)
+	return
+}
+
+//Call invokes the named function, waits for it to complete, and returns its error status.
+func (c *Client) Call(serviceMethod string, args []string) (reply interface{}, err error) {
+	params := make([]interface{}, len(args), len(args))
+	for i, arg := range args {
+		params[i] = arg
+	}
+	return c.CallWithParams(serviceMethod, params)
+}
+
+//CallWithParams invokes the named function with parameters that are not necessarily strings,
+// waits for it to complete, and returns its error status.
+func (c *Client) CallWithParams(serviceMethod string, params []interface{}) (reply interface{}, err error) {
+	r := c.newRequest(serviceMethod, params)
+
 	call := c.registerRequest(r.ID)
 	defer c.cancelRequest(r.ID)
 
-	rawmsg = append(rawmsg, []byte("\n")...)
-	_, err = c.socket.Write(rawmsg)
-	if err != nil {
+	if err = c.send(r); err != nil {
 		return
 	}
 	//Make sure the request is cancelled if no response is given
@@ -191,9 +248,14 @@ func (c *Client) Call(serviceMethod string, args []string) (reply interface{}, e
 	reply = <-call
 
 	if reply == nil {
-		err = errors.New("Timeout")
+		err = errors.New("Timeout or connection lost")
 		return
 	}
 	err, _ = reply.(error)
 	return
 }
+
+//Notify invokes the named function without waiting for a response, a response of the server is ignored.
+func (c *Client) Notify(serviceMethod string, params []interface{}) (err error) {
+	return c.send(c.newRequest(serviceMethod, params))
+}
@@ -1 +1,112 @@
-package stratum
+package stratum_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients/stratum"
+	"github.com/robvanmieghem/gominer/clients/stratum/stratumtest"
+)
+
+func TestClientCall(t *testing.T) {
+	pool := stratumtest.NewServer()
+	defer pool.Close()
+	pool.Fail("mining.authorize", stratum.ErrUnauthorizedWorker)
+
+	c := &stratum.Client{}
+	if err := c.Dial(pool.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.Call("mining.subscribe", []string{"gominer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, ok := result.([]interface{}); !ok || len(reply) != 3 || reply[1] != pool.Extranonce1 {
+		t.Error("Unexpected reply on subscribe:", result)
+	}
+	if _, err = c.Call("mining.authorize", []string{"user", ""}); err == nil || err.Error() != stratum.ErrUnauthorizedWorker.Message {
+		t.Error("Expected an unauthorized error instead of", err)
+	} else if stratumErr, ok := err.(*stratum.Error); !ok || stratumErr.Code != stratum.ErrUnauthorizedWorker.Code {
+		t.Error("The error code is not kept:", err)
+	}
+
+	requests := pool.Requests("")
+	if len(requests) != 2 || requests[0].Method != "mining.subscribe" || requests[1].Method != "mining.authorize" {
+		t.Fatal("Unexpected requests recorded:", requests)
+	}
+	if len(requests[1].Params) != 2 || requests[1].Params[0] != "user" {
+		t.Error("Unexpected authorize parameters:", requests[1].Params)
+	}
+}
+
+func TestClientNotifications(t *testing.T) {
+	pool := stratumtest.NewServer()
+	defer pool.Close()
+
+	notifications := make(chan []interface{}, 1)
+	errors := make(chan error, 1)
+	c := &stratum.Client{}
+	c.SetNotificationHandler("mining.set_difficulty", func(params []interface{}) {
+		notifications <- params
+	})
+	c.ErrorCallback = func(err error) {
+		errors <- err
+	}
+	if err := c.Dial(pool.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, err := pool.WaitForConnection(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Notifications without a handler are ignored
+	conn.Notify("mining.unknown")
+	conn.SetDifficulty(2)
+	select {
+	case params := <-notifications:
+		if len(params) != 1 || params[0] != 2.0 {
+			t.Error("Unexpected notification parameters:", params)
+		}
+	case <-time.After(time.Second):
+		t.Error("No notification received")
+	}
+
+	conn.WriteLine("{this is not json")
+	select {
+	case <-errors:
+	case <-time.After(time.Second):
+		t.Error("No error on a malformed message")
+	}
+}
+
+func TestClientConnectionDropped(t *testing.T) {
+	pool := stratumtest.NewServer()
+	defer pool.Close()
+	pool.Delay("mining.subscribe", time.Second)
+
+	errors := make(chan error, 1)
+	c := &stratum.Client{ErrorCallback: func(err error) { errors <- err }}
+	if err := c.Dial(pool.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	go func() {
+		if conn, err := pool.WaitForConnection(1, time.Second); err == nil {
+			pool.WaitForRequests("mining.subscribe", 1, time.Second)
+			conn.Close()
+		}
+	}()
+	if _, err := c.Call("mining.subscribe", []string{"gominer"}); err == nil {
+		t.Error("Expected an error when the connection is dropped")
+	}
+	select {
+	case <-errors:
+	case <-time.After(time.Second):
+		t.Error("Dropped connection not reported")
+	}
+}
@@ -0,0 +1,348 @@
+//Package stratumtest provides an in-process stratum server with scriptable replies to test stratum clients against.
+package stratumtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients/stratum"
+)
+
+//Request is a request as received from a client
+type Request struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	//Conn is the connection the request was received on
+	Conn *Conn `json:"-"`
+}
+
+type response struct {
+	ID     interface{}    `json:"id"`
+	Result interface{}    `json:"result"`
+	Error  *stratum.Error `json:"error"`
+}
+
+type notification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+//Handler scripts the reply on a request, a nil error results in the result being sent back
+type Handler func(r Request) (result interface{}, err *stratum.Error)
+
+//Server is a stratum server listening on a local port.
+// By default, it replies on mining.subscribe with Extranonce1 and Extranonce2Size and accepts
+// every mining.authorize and mining.submit, other methods are answered with an error.
+// All requests are recorded.
+type Server struct {
+	//Extranonce1 is the hex encoded extranonce1 in the default mining.subscribe reply
+	Extranonce1 string
+	//Extranonce2Size is the extranonce2_size in the default mining.subscribe reply
+	Extranonce2Size int
+
+	listener net.Listener
+
+	mutex       sync.Mutex // protects following
+	handlers    map[string]Handler
+	delays      map[string]time.Duration
+	ignored     map[string]bool
+	connections []*Conn
+	requests    []Request
+	changed     *sync.Cond
+}
+
+//NewServer starts a Server listening on a random port of the loopback interface
+func NewServer() (s *Server) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("stratumtest: failed to listen on a port: " + err.Error())
+	}
+	s = &Server{
+		Extranonce1:     "aabbccdd",
+		Extranonce2Size: 4,
+		listener:        listener,
+		handlers:        make(map[string]Handler),
+		delays:          make(map[string]time.Duration),
+		ignored:         make(map[string]bool),
+	}
+	s.changed = sync.NewCond(&s.mutex)
+	s.Handle("mining.subscribe", func(r Request) (result interface{}, err *stratum.Error) {
+		result = []interface{}{
+			[]interface{}{
+				[]interface{}{"mining.set_difficulty", "1"},
+				[]interface{}{"mining.notify", "1"},
+			},
+			s.Extranonce1,
+			s.Extranonce2Size,
+		}
+		return
+	})
+	accept := func(r Request) (result interface{}, err *stratum.Error) {
+		result = true
+		return
+	}
+	s.Handle("mining.authorize", accept)
+	s.Handle("mining.submit", accept)
+	go s.serve()
+	return
+}
+
+//Addr returns the host:port the server is listening on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+//URL returns the stratum+tcp:// url of the server
+func (s *Server) URL() string {
+	return "stratum+tcp://" + s.Addr()
+}
+
+//Close stops listening and drops all connections
+func (s *Server) Close() {
+	s.listener.Close()
+	for _, c := range s.Connections() {
+		c.Close()
+	}
+}
+
+//Handle scripts the replies on requests for a method, a nil handler removes the handler so
+// the requests are answered with an error
+func (s *Server) Handle(method string, handler Handler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if handler == nil {
+		delete(s.handlers, method)
+		return
+	}
+	s.handlers[method] = handler
+}
+
+//Reply scripts a fixed result on requests for a method
+func (s *Server) Reply(method string, result interface{}) {
+	s.Handle(method, func(r Request) (interface{}, *stratum.Error) {
+		return result, nil
+	})
+}
+
+//Fail scripts a fixed error on requests for a method
+func (s *Server) Fail(method string, err *stratum.Error) {
+	s.Handle(method, func(r Request) (interface{}, *stratum.Error) {
+		return nil, err
+	})
+}
+
+//Delay postpones the replies on requests for a method, the connection does not process other requests in the meantime
+func (s *Server) Delay(method string, delay time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.delays[method] = delay
+}
+
+//Ignore records the requests for a method but never replies to them, like stratum servers that
+// silently drop the methods they do not know
+func (s *Server) Ignore(method string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ignored[method] = true
+}
+
+//Connections returns the connections that are still open, in the order they were accepted
+func (s *Server) Connections() (connections []*Conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.connections {
+		if !c.closed {
+			connections = append(connections, c)
+		}
+	}
+	return
+}
+
+//WaitForConnection waits until at least count connections were accepted since the server started and returns the last one
+func (s *Server) WaitForConnection(count int, timeout time.Duration) (c *Conn, err error) {
+	err = s.waitFor(timeout, func() bool {
+		if len(s.connections) < count {
+			return false
+		}
+		c = s.connections[len(s.connections)-1]
+		return true
+	})
+	return
+}
+
+//Requests returns the requests received so far for a method, an empty method returns all requests
+func (s *Server) Requests(method string) (requests []Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.filterRequests(method)
+}
+
+//WaitForRequests waits until at least count requests for a method were received and returns them
+func (s *Server) WaitForRequests(method string, count int, timeout time.Duration) (requests []Request, err error) {
+	err = s.waitFor(timeout, func() bool {
+		requests = s.filterRequests(method)
+		return len(requests) >= count
+	})
+	return
+}
+
+//Submits returns the mining.submit requests received so far
+func (s *Server) Submits() []Request {
+	return s.Requests("mining.submit")
+}
+
+//Broadcast sends a notification to all open connections
+func (s *Server) Broadcast(method string, params ...interface{}) {
+	for _, c := range s.Connections() {
+		c.Notify(method, params...)
+	}
+}
+
+//filterRequests should be called with the mutex held
+func (s *Server) filterRequests(method string) (requests []Request) {
+	for _, r := range s.requests {
+		if method == "" || r.Method == method {
+			requests = append(requests, r)
+		}
+	}
+	return
+}
+
+//waitFor waits until condition returns true, the condition is evaluated with the mutex held
+func (s *Server) waitFor(timeout time.Duration, condition func() bool) (err error) {
+	timer := time.AfterFunc(timeout, func() {
+		s.mutex.Lock()
+		s.changed.Broadcast()
+		s.mutex.Unlock()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for !condition() {
+		if !time.Now().Before(deadline) {
+			return errors.New("stratumtest: timeout")
+		}
+		s.changed.Wait()
+	}
+	return
+}
+
+func (s *Server) serve() {
+	for {
+		socket, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &Conn{socket: socket, server: s}
+		s.mutex.Lock()
+		s.connections = append(s.connections, c)
+		s.changed.Broadcast()
+		s.mutex.Unlock()
+		go c.listen()
+	}
+}
+
+//Conn is a connection of a client to the Server
+type Conn struct {
+	socket net.Conn
+	server *Server
+
+	writeMutex sync.Mutex // protects the socket writes
+
+	closed bool // protected by the mutex of the server
+}
+
+//Notify sends a notification to the client
+func (c *Conn) Notify(method string, params ...interface{}) (err error) {
+	if params == nil {
+		params = []interface{}{}
+	}
+	return c.write(notification{Method: method, Params: params})
+}
+
+//SetDifficulty sends a mining.set_difficulty notification to the client
+func (c *Conn) SetDifficulty(difficulty float64) (err error) {
+	return c.Notify("mining.set_difficulty", difficulty)
+}
+
+//NotifyJob sends a mining.notify notification with the given job parameters to the client
+func (c *Conn) NotifyJob(params ...interface{}) (err error) {
+	return c.Notify("mining.notify", params...)
+}
+
+//WriteLine sends a raw line to the client, it can be used to inject malformed messages
+func (c *Conn) WriteLine(line string) (err error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err = c.socket.Write([]byte(line + "\n"))
+	return
+}
+
+//Close drops the connection
+func (c *Conn) Close() {
+	c.socket.Close()
+}
+
+func (c *Conn) write(message interface{}) (err error) {
+	rawmsg, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	return c.WriteLine(string(rawmsg))
+}
+
+func (c *Conn) listen() {
+	defer func() {
+		c.socket.Close()
+		c.server.mutex.Lock()
+		c.closed = true
+		c.server.changed.Broadcast()
+		c.server.mutex.Unlock()
+	}()
+
+	reader := bufio.NewReader(c.socket)
+	for {
+		rawmessage, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		r := Request{}
+		if err = json.Unmarshal([]byte(rawmessage), &r); err != nil {
+			return
+		}
+		r.Conn = c
+
+		c.server.mutex.Lock()
+		c.server.requests = append(c.server.requests, r)
+		c.server.changed.Broadcast()
+		handler := c.server.handlers[r.Method]
+		delay := c.server.delays[r.Method]
+		ignored := c.server.ignored[r.Method]
+		c.server.mutex.Unlock()
+		if ignored {
+			continue
+		}
+
+		reply := response{ID: r.ID}
+		if handler == nil {
+			reply.Error = &stratum.Error{Code: stratum.ErrOther.Code, Message: "Unsupported method " + r.Method}
+		} else {
+			reply.Result, reply.Error = handler(r)
+		}
+		if reply.Error != nil {
+			reply.Result = nil
+		}
+		time.Sleep(delay)
+		if err = c.write(reply); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package clients
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	//DefaultScoreInterval is the default time between two evaluations of the pool scores
+	DefaultScoreInterval = time.Minute
+	//DefaultMinDwell is the default minimum time mined on a pool before switching to another one
+	DefaultMinDwell = 10 * time.Minute
+	//DefaultHysteresis is the default relative improvement of the score required to switch
+	DefaultHysteresis = 0.05
+)
+
+//ScoreSource provides the current scores of pools by name, a higher score means more profitable
+type ScoreSource interface {
+	Scores() (scores map[string]float64, err error)
+}
+
+//StaticScores are fixed scores
+type StaticScores map[string]float64
+
+//Scores returns the fixed scores
+func (s StaticScores) Scores() (map[string]float64, error) {
+	return s, nil
+}
+
+//FileScores reads the scores from a json file containing an object that maps the pool names to their scores.
+// The file is read again at every evaluation so it can be updated by an external script.
+type FileScores struct {
+	Path string
+}
+
+//Scores reads the scores from the file
+func (s FileScores) Scores() (scores map[string]float64, err error) {
+	buf, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(buf, &scores)
+	return
+}
+
+//HTTPScores fetches the scores from an http endpoint returning a json object that maps the pool names to their scores
+type HTTPScores struct {
+	URL string
+	//HTTPClient is used for the requests, the default client is used if it is nil
+	HTTPClient *http.Client
+}
+
+//Scores fetches the scores from the endpoint
+func (s HTTPScores) Scores() (scores map[string]float64, err error) {
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("Status code %d", resp.StatusCode)
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(&scores)
+	return
+}
+
+//SwitchingClient keeps several upstream clients connected and mines on the one with the highest score.
+// The scores are fetched from the Source every Interval, the active pool is only replaced if it was mined on
+// for at least MinDwell and if the score of the best pool is more than Hysteresis (relative) higher.
+// The weights of the pools are not used. Solutions are submitted to the pool the job came from.
+// The scores are ignored once a pool is chosen with SwitchPool.
+type SwitchingClient struct {
+	Source     ScoreSource
+	Interval   time.Duration
+	MinDwell   time.Duration
+	Hysteresis float64
+
+	pools []Pool
+
+	mutex       sync.Mutex // protects following
+	active      int
+	activeSince time.Time
+	//pinned is true if the active pool is chosen with SwitchPool
+	pinned            bool
+	deprecatedJobCall DeprecatedJobCall
+	//switched is closed when the active pool changes
+	switched chan bool
+	//deprecationChannels maps the deprecation channels of the upstream clients to the ones handed out
+	deprecationChannels map[chan bool]chan bool
+}
+
+//switchingJob wraps the job of an upstream client so the solution can be submitted to the right pool
+type switchingJob struct {
+	pool int
+	job  interface{}
+}
+
+//NewSwitchingClient creates a SwitchingClient for the pools, every pool needs a unique name to match the scores with
+func NewSwitchingClient(pools []Pool, source ScoreSource) (sc *SwitchingClient, err error) {
+	if len(pools) == 0 {
+		err = errors.New("No pools configured")
+		return
+	}
+	names := make(map[string]bool)
+	for _, p := range pools {
+		if names[p.Name] {
+			err = fmt.Errorf("Pool name %s is not unique", p.Name)
+			return
+		}
+		names[p.Name] = true
+	}
+	sc = &SwitchingClient{
+		Source:              source,
+		Interval:            DefaultScoreInterval,
+		MinDwell:            DefaultMinDwell,
+		Hysteresis:          DefaultHysteresis,
+		pools:               pools,
+		switched:            make(chan bool),
+		deprecationChannels: make(map[chan bool]chan bool),
+	}
+	return
+}
+
+//Start starts all upstream clients and periodically evaluates the scores
+func (sc *SwitchingClient) Start() {
+	for i, p := range sc.pools {
+		//Only abandon the work when the active pool asks for it
+		pool := i
+		p.Client.SetDeprecatedJobCall(func() {
+			sc.mutex.Lock()
+			call := sc.deprecatedJobCall
+			active := sc.active == pool
+			sc.mutex.Unlock()
+			if active && call != nil {
+				call()
+			}
+		})
+		p.Client.Start()
+	}
+	//The minimum time does not apply to the first evaluation since activeSince is not set yet
+	poolsLog.Info("Mining on pool", sc.pools[0].Name)
+	sc.Evaluate()
+	go func() {
+		for {
+			time.Sleep(sc.Interval)
+			sc.Evaluate()
+		}
+	}()
+}
+
+//SetDeprecatedJobCall sets the function to be called when the previous jobs should be abandoned
+func (sc *SwitchingClient) SetDeprecatedJobCall(call DeprecatedJobCall) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.deprecatedJobCall = call
+}
+
+//Active returns the name of the pool that is mined on
+func (sc *SwitchingClient) Active() string {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.pools[sc.active].Name
+}
+
+//PoolStatus returns the state of all pools, only the one that is mined on is active
+func (sc *SwitchingClient) PoolStatus() (statuses []PoolStatus) {
+	sc.mutex.Lock()
+	active := sc.active
+	sc.mutex.Unlock()
+	for i, p := range sc.pools {
+		statuses = append(statuses, poolStatus(p, i == active)...)
+	}
+	return
+}
+
+//JobPool returns the url of the pool the job comes from
+func (sc *SwitchingClient) JobPool(job interface{}) string {
+	if sj, ok := job.(switchingJob); ok {
+		return JobPool(sc.pools[sj.pool].Client, sj.job)
+	}
+	return ""
+}
+
+//JobDetails describes the job using the client of the pool it comes from
+func (sc *SwitchingClient) JobDetails(job interface{}) JobDetails {
+	if sj, ok := job.(switchingJob); ok {
+		return DescribeJob(sc.pools[sj.pool].Client, sj.job)
+	}
+	return JobDetails{}
+}
+
+//Evaluate fetches the scores and switches to the best pool if it is worth it
+func (sc *SwitchingClient) Evaluate() {
+	scores, err := sc.Source.Scores()
+	if err != nil {
+		poolsLog.Error("Unable to fetch the pool scores -", err)
+		return
+	}
+	best := -1
+	for i, p := range sc.pools {
+		if score, found := scores[p.Name]; found && (best < 0 || score > scores[sc.pools[best].Name]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		poolsLog.Error("None of the pools has a score")
+		return
+	}
+
+	sc.mutex.Lock()
+	if sc.pinned {
+		sc.mutex.Unlock()
+		return
+	}
+	active := sc.pools[sc.active].Name
+	activeScore, activeScored := scores[active]
+	bestScore := scores[sc.pools[best].Name]
+	var reason string
+	switch {
+	case best == sc.active:
+		sc.mutex.Unlock()
+		return
+	case !activeScored:
+		reason = "there is no score for the current pool"
+	case bestScore-activeScore <= sc.Hysteresis*math.Abs(activeScore):
+		sc.mutex.Unlock()
+		return
+	case time.Since(sc.activeSince) < sc.MinDwell:
+		sc.mutex.Unlock()
+		poolsLog.Info("Not switching to pool", sc.pools[best].Name, "yet, the minimum time on pool", active, "is not reached")
+		return
+	default:
+		reason = fmt.Sprintf("its score %g is more than %g%% higher than %g", bestScore, sc.Hysteresis*100, activeScore)
+	}
+	call := sc.switchTo(best)
+	sc.mutex.Unlock()
+
+	poolsLog.Info("Switching from pool", active, "to pool", sc.pools[best].Name, "since", reason)
+	if call != nil {
+		go call()
+	}
+}
+
+//switchTo makes pool the active one and returns the function to call to abandon the outstanding work
+// This method is not threadsafe
+func (sc *SwitchingClient) switchTo(pool int) DeprecatedJobCall {
+	sc.active = pool
+	sc.activeSince = time.Now()
+	close(sc.switched)
+	sc.switched = make(chan bool)
+	sc.deprecationChannels = make(map[chan bool]chan bool)
+	return sc.deprecatedJobCall
+}
+
+//SwitchPool mines on the pool with this index regardless of the scores, -1 lets the scores decide again from the next evaluation on
+func (sc *SwitchingClient) SwitchPool(pool int) (err error) {
+	if pool < -1 || pool >= len(sc.pools) {
+		return fmt.Errorf("There is no pool %d", pool)
+	}
+	sc.mutex.Lock()
+	sc.pinned = pool >= 0
+	if pool < 0 {
+		sc.mutex.Unlock()
+		poolsLog.Info("The scores decide the pool to mine on again")
+		return
+	}
+	if pool == sc.active {
+		sc.mutex.Unlock()
+		poolsLog.Info("Staying on pool", sc.pools[pool].Name, "regardless of the scores")
+		return
+	}
+	active := sc.pools[sc.active].Name
+	call := sc.switchTo(pool)
+	sc.mutex.Unlock()
+
+	poolsLog.Info("Switching from pool", active, "to pool", sc.pools[pool].Name, "by hand")
+	if call != nil {
+		go call()
+	}
+	return
+}
+
+//SetUser changes the user of all pools that support it
+func (sc *SwitchingClient) SetUser(user string) error {
+	return setUser(sc.pools, user)
+}
+
+//deprecationChannel returns a channel that is closed when the upstream channel is closed or the active pool changes
+// This method is not threadsafe
+func (sc *SwitchingClient) deprecationChannel(upstream chan bool) chan bool {
+	if c, found := sc.deprecationChannels[upstream]; found {
+		return c
+	}
+	c := make(chan bool)
+	sc.deprecationChannels[upstream] = c
+	switched := sc.switched
+	go func() {
+		select {
+		case <-upstream:
+		case <-switched:
+		}
+		sc.mutex.Lock()
+		if sc.deprecationChannels[upstream] == c {
+			delete(sc.deprecationChannels, upstream)
+		}
+		sc.mutex.Unlock()
+		close(c)
+	}()
+	return c
+}
+
+//GetHeaderForWork fetches work from the active pool
+func (sc *SwitchingClient) GetHeaderForWork() (target, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	sc.mutex.Lock()
+	active := sc.active
+	sc.mutex.Unlock()
+
+	var upstreamChannel chan bool
+	var upstreamJob interface{}
+	target, header, upstreamChannel, upstreamJob, err = sc.pools[active].Client.GetHeaderForWork()
+	if err != nil {
+		return
+	}
+	job = switchingJob{pool: active, job: upstreamJob}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.active != active {
+		err = errors.New("Switched pools while fetching work")
+		return
+	}
+	deprecationChannel = sc.deprecationChannel(upstreamChannel)
+	return
+}
+
+//SubmitHeader submits a solution to the pool the job came from
+func (sc *SwitchingClient) SubmitHeader(header []byte, job interface{}) (err error) {
+	sj, ok := job.(switchingJob)
+	if !ok {
+		err = errors.New("Job is not handed out by this client")
+		return
+	}
+	return sc.pools[sj.pool].Client.SubmitHeader(header, sj.job)
+}
+
+//SetHashRate passes the hashrate to the active pool if it uses it
+func (sc *SwitchingClient) SetHashRate(hashRate float64) {
+	sc.mutex.Lock()
+	active := sc.active
+	sc.mutex.Unlock()
+	if receiver, ok := sc.pools[active].Client.(HashRateReceiver); ok {
+		receiver.SetHashRate(hashRate)
+	}
+}
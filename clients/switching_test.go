@@ -0,0 +1,127 @@
+package clients
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSwitchingClient(t *testing.T) {
+	pool1, pool2 := &fakeClient{id: 0}, &fakeClient{id: 1}
+	scores := StaticScores{"pool1": 1, "pool2": 2}
+	sc, err := NewSwitchingClient([]Pool{{Name: "pool1", Client: pool1}, {Name: "pool2", Client: pool2}}, scores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.Interval = time.Hour
+	deprecatedCalls := make(chan bool, 10)
+	sc.SetDeprecatedJobCall(func() { deprecatedCalls <- true })
+
+	//The first evaluation switches to the best pool right away
+	sc.Start()
+	if sc.Active() != "pool2" {
+		t.Fatal("Mining on", sc.Active(), "instead of pool2")
+	}
+	<-deprecatedCalls
+	_, header, deprecationChannel, job, err := sc.GetHeaderForWork()
+	if err != nil || header[0] != 1 {
+		t.Fatal("Work not taken from pool2:", header, err)
+	}
+
+	//Within the minimum time, no switch should happen
+	scores["pool1"] = 3
+	sc.Evaluate()
+	if sc.Active() != "pool2" {
+		t.Error("Switched before the minimum time on a pool")
+	}
+
+	//A score within the hysteresis should not trigger a switch
+	sc.MinDwell = 0
+	scores["pool1"] = 2.05
+	sc.Evaluate()
+	if sc.Active() != "pool2" {
+		t.Error("Switched for an improvement within the hysteresis")
+	}
+
+	scores["pool1"] = 3
+	sc.Evaluate()
+	if sc.Active() != "pool1" {
+		t.Fatal("No switch to the better pool")
+	}
+	select {
+	case <-deprecationChannel:
+	case <-time.After(time.Second):
+		t.Error("Work of the previous pool not abandoned")
+	}
+	select {
+	case <-deprecatedCalls:
+	case <-time.After(time.Second):
+		t.Error("Deprecated job call not executed on a switch")
+	}
+
+	//Solutions of the previous pool still go to that pool
+	if err = sc.SubmitHeader(header, job); err != nil || pool2.submitted != 1 {
+		t.Error("Solution not submitted to the pool the job came from:", err)
+	}
+	if _, header, _, _, err = sc.GetHeaderForWork(); err != nil || header[0] != 0 {
+		t.Error("Work not taken from pool1:", header, err)
+	}
+
+	//A pool chosen by hand is kept regardless of the scores
+	if err = sc.SwitchPool(1); err != nil || sc.Active() != "pool2" {
+		t.Fatal("Not switched to pool2 by hand:", err)
+	}
+	sc.Evaluate()
+	if sc.Active() != "pool2" {
+		t.Error("Switched by the scores while the pool is chosen by hand")
+	}
+	if err = sc.SwitchPool(-1); err != nil {
+		t.Fatal(err)
+	}
+	sc.Evaluate()
+	if sc.Active() != "pool1" {
+		t.Error("The scores do not decide again")
+	}
+	if err = sc.SwitchPool(2); err == nil {
+		t.Error("Expected an error switching to an unknown pool")
+	}
+
+	if _, err = NewSwitchingClient([]Pool{{Name: "pool", Client: pool1}, {Name: "pool", Client: pool2}}, scores); err == nil {
+		t.Error("Expected an error for pools with the same name")
+	}
+}
+
+func TestScoreSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gominer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scores.json")
+	if err = ioutil.WriteFile(path, []byte(`{"pool1": 1.5, "pool2": 0.5}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"pool1": 1.5, "pool2": 0.5}`))
+	}))
+	defer server.Close()
+
+	for _, source := range []ScoreSource{FileScores{Path: path}, HTTPScores{URL: server.URL}} {
+		scores, err := source.Scores()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if len(scores) != 2 || scores["pool1"] != 1.5 || scores["pool2"] != 0.5 {
+			t.Error("Unexpected scores", scores)
+		}
+	}
+	if _, err = (FileScores{Path: filepath.Join(dir, "missing.json")}).Scores(); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
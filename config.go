@@ -0,0 +1,515 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robvanmieghem/go-opencl/cl"
+	"github.com/robvanmieghem/gominer/algorithms/sia"
+	"github.com/robvanmieghem/gominer/api"
+	"github.com/robvanmieghem/gominer/clients"
+	"github.com/robvanmieghem/gominer/hwmon"
+	"github.com/robvanmieghem/gominer/logging"
+	"github.com/robvanmieghem/gominer/sharelog"
+)
+
+//Config is the configuration of the miner, it is loaded from the json file given with -config
+// and the flags that are set on the command line override the values of the file.
+type Config struct {
+	//File is the configuration file the values are loaded from
+	File string `json:"-"`
+	//URL is the single pool or siad to mine on if no Pools are configured
+	URL string `json:"url"`
+	//User is used for the pools that do not have a user
+	User        string         `json:"user"`
+	APIPassword string         `json:"apipassword,omitempty"`
+	CAFile      string         `json:"cafile,omitempty"`
+	Pools       []PoolConfig   `json:"pools,omitempty"`
+	Switch      SwitchConfig   `json:"switch"`
+	Stratum     StratumConfig  `json:"stratum"`
+	Devices     DevicesConfig  `json:"devices"`
+	API         APIConfig      `json:"api"`
+	CGMinerAPI  CGMinerConfig  `json:"cgminerapi"`
+	Log         LogConfig      `json:"log"`
+	Thermal     ThermalConfig  `json:"thermal"`
+	ShareLog    ShareLogConfig `json:"sharelog"`
+	//Dashboard shows the full screen dashboard instead of the log and the hashrate line
+	Dashboard bool `json:"dashboard,omitempty"`
+
+	printVersion bool
+}
+
+//PoolConfig is a pool to mine on
+type PoolConfig struct {
+	URL  string `json:"url"`
+	User string `json:"user,omitempty"`
+	//Password is the stratum or getwork password or the siad API password
+	Password string `json:"password,omitempty"`
+	//Priority orders the pools for failover, the pools with the lowest priority are mined on as long as they have work
+	Priority int `json:"priority"`
+	//Weight is the relative share of the work of the pools with the same priority, it defaults to 1
+	Weight float64 `json:"weight"`
+}
+
+//SwitchConfig configures mining on the most profitable pool only
+type SwitchConfig struct {
+	//Source of the scores: static, file:<path> or an http(s) url, switching is disabled if it is empty
+	Source     string   `json:"source,omitempty"`
+	Interval   duration `json:"interval"`
+	Dwell      duration `json:"dwell"`
+	Hysteresis float64  `json:"hysteresis"`
+}
+
+//StratumConfig are the settings of the stratum clients
+type StratumConfig struct {
+	SuggestDifficulty float64  `json:"suggestdiff,omitempty"`
+	SuggestTarget     bool     `json:"suggesttarget,omitempty"`
+	SharesPerMinute   float64  `json:"sharesperminute,omitempty"`
+	NTimeRoll         duration `json:"ntimeroll"`
+	//Record is the file the stratum sessions are recorded in
+	Record string `json:"record,omitempty"`
+}
+
+//DevicesConfig selects the devices to mine on and their settings
+type DevicesConfig struct {
+	//CPU also uses the CPU for mining
+	CPU bool `json:"cpu,omitempty"`
+	//Select is the device selector of the devices to mine on, all devices are used if it is empty
+	Select string `json:"select,omitempty"`
+	//Exclude is the device selector of the devices not to mine on
+	Exclude   string `json:"exclude,omitempty"`
+	Intensity int    `json:"intensity"`
+	//Devices overrides the settings for specific devices
+	Devices []DeviceConfig `json:"devices,omitempty"`
+}
+
+//DeviceConfig overrides the settings of a single device
+type DeviceConfig struct {
+	//Device is the device selector of the devices the settings apply to, like "0:1" to keep pointing at the same card
+	Device        string `json:"device"`
+	Intensity     int    `json:"intensity,omitempty"`
+	LocalSize     int    `json:"localsize,omitempty"`
+	KernelOptions string `json:"kerneloptions,omitempty"`
+	//HWMon is the drm card of the device, like card1, or the hwmon directory with its sensors if it is not found automatically
+	HWMon string `json:"hwmon,omitempty"`
+}
+
+//APIConfig configures the http api with the state of the miner
+type APIConfig struct {
+	//Listen is the address the api is served on, it is disabled if it is empty
+	Listen string `json:"listen,omitempty"`
+	//Token is required to access the api if it is not empty
+	Token string `json:"token,omitempty"`
+}
+
+//CGMinerConfig configures the cgminer compatible api used by rig management tools
+type CGMinerConfig struct {
+	//Listen is the address the api is served on, usually port 4028, it is disabled if it is empty
+	Listen string `json:"listen,omitempty"`
+	//Allow is the comma separated list of addresses and networks that can access the api, W: grants the privileged commands
+	Allow string `json:"allow,omitempty"`
+}
+
+//LogConfig configures the logging
+type LogConfig struct {
+	//File is the file the log is appended to instead of writing it to stdout
+	File string `json:"file,omitempty"`
+	//Level is the minimum level of the entries that are written, per component, like "warn,stratum=debug"
+	Level string `json:"level,omitempty"`
+	//Format is text or json
+	Format string `json:"format,omitempty"`
+	//MaxSize is the size in MB at which the File is rotated, 0 disables the rotation
+	MaxSize int `json:"maxsize,omitempty"`
+	//MaxFiles is the number of rotated files that are kept
+	MaxFiles int `json:"maxfiles"`
+}
+
+//ThermalConfig configures the monitoring of the temperature, fan and power of the GPU's through the Linux hwmon interface
+// and the protection against overheating
+type ThermalConfig struct {
+	//Monitor reads the sensors of the devices, setting a Limit enables it as well
+	Monitor bool `json:"monitor,omitempty"`
+	//Root is the sysfs root the cards are looked up in
+	Root     string   `json:"root"`
+	Interval duration `json:"interval"`
+	//Limit is the temperature in degrees Celsius at which a device is throttled or paused, 0 disables the protection
+	Limit float64 `json:"limit,omitempty"`
+	//Resume is the temperature a device has to cool down to before it mines at full speed again, 10 degrees below the Limit if it is 0
+	Resume float64 `json:"resume,omitempty"`
+	//Action is throttle to lower the duty cycle of a hot device or pause to pause it
+	Action string `json:"action,omitempty"`
+}
+
+//ShareLogConfig configures the record of every solution found and the answer of the pool
+type ShareLogConfig struct {
+	//File is the file the shares are appended to, the share log is disabled if it is empty
+	File string `json:"file,omitempty"`
+	//Format is jsonl or csv, the extension of the File decides if it is empty
+	Format string `json:"format,omitempty"`
+	//MaxSize is the size in MB at which the File is rotated, 0 disables the rotation by size
+	MaxSize int `json:"maxsize,omitempty"`
+	//Daily rotates the File when the day changes
+	Daily bool `json:"daily,omitempty"`
+	//Sync is the time between two fsyncs of the File, 0 syncs after every share
+	Sync duration `json:"sync"`
+}
+
+//enabled tells if the sensors are monitored
+func (t ThermalConfig) enabled() bool {
+	return t.Monitor || t.Limit > 0
+}
+
+//DefaultLogMaxFiles is the default number of rotated log files that are kept
+const DefaultLogMaxFiles = 5
+
+//duration is a time.Duration that is written as a string like "1m30s" in json
+type duration time.Duration
+
+//MarshalJSON writes the duration as a string
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+//UnmarshalJSON reads a duration string
+func (d *duration) UnmarshalJSON(data []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(data, &s); err != nil {
+		return errors.New("a duration should be a string like \"1m30s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	*d = duration(parsed)
+	return
+}
+
+//defaultConfig returns the configuration used when nothing is set
+func defaultConfig() (cfg *Config) {
+	return &Config{
+		URL:  "localhost:9980",
+		User: "payoutaddress.rigname",
+		Switch: SwitchConfig{
+			Interval:   duration(clients.DefaultScoreInterval),
+			Dwell:      duration(clients.DefaultMinDwell),
+			Hysteresis: clients.DefaultHysteresis,
+		},
+		Devices:  DevicesConfig{Intensity: 28},
+		Log:      LogConfig{MaxFiles: DefaultLogMaxFiles},
+		Thermal:  ThermalConfig{Root: hwmon.DefaultRoot, Interval: duration(hwmon.DefaultInterval)},
+		ShareLog: ShareLogConfig{Sync: duration(sharelog.DefaultSyncInterval)},
+	}
+}
+
+//newFlagSet creates the command line flags of the miner, they are bound to the fields of cfg
+func newFlagSet(name string, cfg *Config) (fs *flag.FlagSet) {
+	fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.File, "config", cfg.File, "load the configuration from this json `file`, the flags that are set override its values")
+	fs.BoolVar(&cfg.printVersion, "v", false, "Show version and exit")
+	fs.BoolVar(&cfg.Devices.CPU, "cpu", cfg.Devices.CPU, "If set, also use the CPU for mining, only GPU's are used by default")
+	fs.IntVar(&cfg.Devices.Intensity, "I", cfg.Devices.Intensity, "Intensity")
+	fs.StringVar(&cfg.URL, "url", cfg.URL, "daemon or server host and port, use `https://<host>:<port>` for siad behind TLS, for stratum servers, use `stratum+tcp://<host>:<port>`, for getwork servers, use `http+getwork://[<user>:<password>@]<host>:<port>[/<path>]`")
+	fs.StringVar(&cfg.User, "user", cfg.User, "username, most stratum servers take this in the form [payoutaddress].[rigname]")
+	fs.StringVar(&cfg.Devices.Select, "d", cfg.Devices.Select, "Select the devices to mine on: comma separated list of devicenumbers, ranges like `0,2-4`, <platform>:<device>, platform:<text>, vendor:<text> or name~=<regexp>")
+	fs.StringVar(&cfg.Devices.Exclude, "E", cfg.Devices.Exclude, "Exclude GPU's: comma separated list of devicenumbers or other device selectors like -d")
+	fs.StringVar(&cfg.APIPassword, "apipassword", cfg.APIPassword, "siad API password, defaults to the SIA_API_PASSWORD environment variable or the apipassword file in the sia directory")
+	fs.StringVar(&cfg.CAFile, "cafile", cfg.CAFile, "PEM file with the certificate authorities to trust when connecting to siad or a getwork server over https")
+	fs.Float64Var(&cfg.Stratum.SuggestDifficulty, "suggestdiff", cfg.Stratum.SuggestDifficulty, "difficulty to suggest to the stratum server after subscribing")
+	fs.BoolVar(&cfg.Stratum.SuggestTarget, "suggesttarget", cfg.Stratum.SuggestTarget, "suggest the difficulty as a target using mining.suggest_target instead of mining.suggest_difficulty")
+	fs.Float64Var(&cfg.Stratum.SharesPerMinute, "sharesperminute", cfg.Stratum.SharesPerMinute, "suggest the difficulty at which the measured hashrate finds this number of shares per minute to the stratum server")
+	fs.DurationVar((*time.Duration)(&cfg.Stratum.NTimeRoll), "ntimeroll", time.Duration(cfg.Stratum.NTimeRoll), "maximum offset to the ntime of a stratum job to ask the stratum server for, ntime rolling is disabled if it is 0")
+	fs.StringVar(&cfg.Stratum.Record, "record", cfg.Stratum.Record, "append the lines exchanged with the stratum server to this file, they can be replayed using `gominer replay`")
+	fs.Var(&poolList{pools: &cfg.Pools}, "pool", "mine on multiple pools, in the form `<weight>,<url>[,<user>]`, repeat it for every pool, the work is divided in proportion to the weights and -url is ignored")
+	fs.StringVar(&cfg.Switch.Source, "switch", cfg.Switch.Source, "mine only on the most profitable of the -pool pools, the scores of the pools by url come from `static` (the weights), file:<path> or an http(s) url returning a json object")
+	fs.DurationVar((*time.Duration)(&cfg.Switch.Interval), "switchinterval", time.Duration(cfg.Switch.Interval), "time between two evaluations of the pool scores when switching")
+	fs.DurationVar((*time.Duration)(&cfg.Switch.Dwell), "switchdwell", time.Duration(cfg.Switch.Dwell), "minimum time mined on a pool before switching to another one")
+	fs.Float64Var(&cfg.Switch.Hysteresis, "switchhysteresis", cfg.Switch.Hysteresis, "relative improvement of the score required to switch pools")
+	fs.StringVar(&cfg.API.Listen, "api", cfg.API.Listen, "serve the json status api on this `address`, like 127.0.0.1:9999")
+	fs.StringVar(&cfg.API.Token, "apitoken", cfg.API.Token, "token required to access the status api, as bearer token or token query parameter")
+	fs.StringVar(&cfg.CGMinerAPI.Listen, "cgminerapi", cfg.CGMinerAPI.Listen, "serve the cgminer compatible api on this `address`, like 127.0.0.1:4028")
+	fs.StringVar(&cfg.CGMinerAPI.Allow, "cgminerapiallow", cfg.CGMinerAPI.Allow, "addresses and networks like `W:127.0.0.1,192.168.1.0/24` that can access the cgminer api, W: grants access to the privileged commands, only the local host has read access by default")
+	fs.BoolVar(&cfg.Dashboard, "dashboard", cfg.Dashboard, "show a full screen dashboard with the devices, pools and log, with hotkeys to pause and resume the devices")
+	fs.BoolVar(&cfg.Thermal.Monitor, "hwmon", cfg.Thermal.Monitor, "monitor the temperature, fan and power of the GPU's through the Linux hwmon interface")
+	fs.Float64Var(&cfg.Thermal.Limit, "templimit", cfg.Thermal.Limit, "throttle or pause a GPU when it reaches this temperature in degrees Celsius, 0 disables the protection")
+	fs.Float64Var(&cfg.Thermal.Resume, "tempresume", cfg.Thermal.Resume, "temperature a throttled or paused GPU has to cool down to before it mines at full speed again, 10 degrees below -templimit by default")
+	fs.StringVar(&cfg.Thermal.Action, "tempaction", cfg.Thermal.Action, "what to do with a GPU at -templimit: `throttle` to lower its duty cycle or pause")
+	fs.StringVar(&cfg.ShareLog.File, "sharelog", cfg.ShareLog.File, "append a line for every solution found and the answer of the pool to this `file`")
+	fs.StringVar(&cfg.ShareLog.Format, "sharelogformat", cfg.ShareLog.Format, "write the share log as `jsonl` or csv, by default csv if the file has a .csv extension")
+	fs.IntVar(&cfg.ShareLog.MaxSize, "sharelogmaxsize", cfg.ShareLog.MaxSize, "rotate the share log when it reaches this size in MB, 0 disables the rotation by size")
+	fs.BoolVar(&cfg.ShareLog.Daily, "sharelogdaily", cfg.ShareLog.Daily, "rotate the share log when the day changes")
+	fs.StringVar(&cfg.Log.File, "logfile", cfg.Log.File, "append the log to this file instead of writing it to stdout")
+	fs.StringVar(&cfg.Log.Level, "loglevel", cfg.Log.Level, "minimum level of the log entries: debug, info, warn or error, followed by the levels of components like `warn,stratum=debug,device=info`")
+	fs.StringVar(&cfg.Log.Format, "logformat", cfg.Log.Format, "write the log entries as `text` or json")
+	fs.IntVar(&cfg.Log.MaxSize, "logmaxsize", cfg.Log.MaxSize, "rotate the log file when it reaches this size in MB, 0 disables the rotation")
+	fs.IntVar(&cfg.Log.MaxFiles, "logmaxfiles", cfg.Log.MaxFiles, "number of rotated log files to keep")
+	return
+}
+
+//parseConfig parses the command line flags on top of the configuration file given with -config and validates the result,
+// the commands can add their own flags using extraFlags
+func parseConfig(name string, args []string, extraFlags ...func(fs *flag.FlagSet)) (cfg *Config, err error) {
+	newFlagSet := func(name string, cfg *Config) (fs *flag.FlagSet) {
+		fs = newFlagSet(name, cfg)
+		for _, extra := range extraFlags {
+			extra(fs)
+		}
+		return
+	}
+	cfg = defaultConfig()
+	if err = newFlagSet(name, cfg).Parse(args); err != nil {
+		return
+	}
+	if cfg.File != "" {
+		//Parse the flags again so the ones that are set override the values of the file
+		file := cfg.File
+		cfg = defaultConfig()
+		if err = cfg.load(file); err != nil {
+			return
+		}
+		fs := newFlagSet(name, cfg)
+		if err = fs.Parse(args); err != nil {
+			return
+		}
+		cfg.File = file
+		//A url on the command line replaces the pools of the file
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if set["url"] && !set["pool"] {
+			cfg.Pools = nil
+		}
+	}
+	err = cfg.validate()
+	return
+}
+
+//load reads the json configuration file, unknown fields are reported as an error to catch typos
+func (cfg *Config) load(file string) (err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(cfg); err != nil {
+		err = fmt.Errorf("Invalid configuration file %s - %s", file, err)
+	}
+	return
+}
+
+//validate checks the configuration and fills in the default weights of the pools
+func (cfg *Config) validate() (err error) {
+	if len(cfg.Pools) == 0 {
+		if sia.NewClient(cfg.URL, cfg.User) == nil {
+			return fmt.Errorf("Invalid url %s", cfg.URL)
+		}
+	}
+	for i := range cfg.Pools {
+		p := &cfg.Pools[i]
+		switch {
+		case p.URL == "":
+			return fmt.Errorf("Pool %d has no url", i)
+		case sia.NewClient(p.URL, p.User) == nil:
+			return fmt.Errorf("Pool %d has an invalid url %s", i, p.URL)
+		case p.Weight < 0:
+			return fmt.Errorf("The weight of pool %d should be positive", i)
+		case p.Weight == 0:
+			p.Weight = 1
+		}
+	}
+	if cfg.Switch.Source != "" {
+		if len(cfg.Pools) == 0 {
+			return errors.New("Switching requires pools")
+		}
+		if cfg.Switch.Interval <= 0 || cfg.Switch.Hysteresis < 0 {
+			return errors.New("The switch interval should be positive and the hysteresis not negative")
+		}
+	}
+	if cfg.Stratum.SuggestDifficulty < 0 || cfg.Stratum.SharesPerMinute < 0 || cfg.Stratum.NTimeRoll < 0 {
+		return errors.New("The stratum settings should not be negative")
+	}
+	if err = validateIntensity(cfg.Devices.Intensity); err != nil {
+		return
+	}
+	if _, err = api.ParseAllowList(cfg.CGMinerAPI.Allow); err != nil {
+		return
+	}
+	if _, err = logging.ParseLevels(cfg.Log.Level); err != nil {
+		return
+	}
+	if cfg.Log.Format != "" && cfg.Log.Format != "text" && cfg.Log.Format != "json" {
+		return fmt.Errorf("Invalid log format %s, use text or json", cfg.Log.Format)
+	}
+	if cfg.Log.MaxSize < 0 || cfg.Log.MaxFiles < 0 {
+		return errors.New("The log file size and number of files should not be negative")
+	}
+	if cfg.Thermal.Limit < 0 || cfg.Thermal.Resume < 0 || (cfg.Thermal.Limit > 0 && cfg.Thermal.Resume >= cfg.Thermal.Limit) {
+		return errors.New("The temperature limit should be positive and the resume temperature below it")
+	}
+	if cfg.Thermal.enabled() && cfg.Thermal.Interval <= 0 {
+		return errors.New("The interval of the thermal monitoring should be positive")
+	}
+	if _, err = hwmon.ParseAction(cfg.Thermal.Action); err != nil {
+		return
+	}
+	if _, err = sharelog.ParseFormat(cfg.ShareLog.Format, cfg.ShareLog.File); err != nil {
+		return
+	}
+	if cfg.ShareLog.MaxSize < 0 || cfg.ShareLog.Sync < 0 {
+		return errors.New("The share log size and sync interval should not be negative")
+	}
+	if _, err = parseDeviceSelector(cfg.Devices.Select); err != nil {
+		return
+	}
+	if _, err = parseDeviceSelector(cfg.Devices.Exclude); err != nil {
+		return
+	}
+	for _, d := range cfg.Devices.Devices {
+		if ds, e := parseDeviceSelector(d.Device); e != nil || len(ds) == 0 {
+			return fmt.Errorf("Invalid device selector %q in the device settings", d.Device)
+		}
+		intensity := cfg.Devices.Intensity
+		if d.Intensity != 0 {
+			intensity = d.Intensity
+			if err = validateIntensity(intensity); err != nil {
+				return fmt.Errorf("Device %s - %s", d.Device, err)
+			}
+		}
+		//The global item size has to be a multiple of the local one
+		if d.LocalSize < 0 || d.LocalSize&(d.LocalSize-1) != 0 || d.LocalSize > 1<<uint(intensity) {
+			return fmt.Errorf("Device %s - The local size should be a power of 2, not larger than 2^intensity", d.Device)
+		}
+	}
+	return
+}
+
+//validateIntensity checks that a nonce range of 2^intensity fits in the 32 bit nonce space multiple times
+func validateIntensity(intensity int) error {
+	if intensity < 1 || intensity > 31 {
+		return fmt.Errorf("Invalid intensity %d, it should be between 1 and 31", intensity)
+	}
+	return nil
+}
+
+//deviceSettings returns the settings of the devices that are configured separately by their Index,
+// if multiple settings match a device, the first one is used
+func (cfg *Config) deviceSettings(devices map[int]*deviceInfo) (settings map[int]sia.DeviceSettings) {
+	settings = make(map[int]sia.DeviceSettings)
+	for index, device := range devices {
+		if d, found := cfg.deviceConfig(device); found {
+			settings[index] = sia.DeviceSettings{Intensity: d.Intensity, LocalItemSize: d.LocalSize, KernelOptions: d.KernelOptions}
+		}
+	}
+	return
+}
+
+//deviceCards returns the drm cards or hwmon directories that are configured for the devices by their Index
+func (cfg *Config) deviceCards(devices map[int]*deviceInfo) (cards map[int]string) {
+	cards = make(map[int]string)
+	for index, device := range devices {
+		if d, found := cfg.deviceConfig(device); found && d.HWMon != "" {
+			cards[index] = d.HWMon
+		}
+	}
+	return
+}
+
+//deviceConfig returns the first device settings that match a device
+func (cfg *Config) deviceConfig(device *deviceInfo) (d DeviceConfig, found bool) {
+	for _, d = range cfg.Devices.Devices {
+		if ds, err := parseDeviceSelector(d.Device); err == nil && ds.matches(device) {
+			return d, true
+		}
+	}
+	return DeviceConfig{}, false
+}
+
+//deviceTypes returns the types of the devices to mine on
+func (cfg *Config) deviceTypes() cl.DeviceType {
+	if cfg.Devices.CPU {
+		return cl.DeviceTypeAll
+	}
+	return cl.DeviceTypeGPU
+}
+
+//clientOptions returns the options that apply to all clients
+func (cfg *Config) clientOptions() clientOptions {
+	return clientOptions{
+		apiPassword:         cfg.APIPassword,
+		caFile:              cfg.CAFile,
+		suggestedDifficulty: cfg.Stratum.SuggestDifficulty,
+		suggestTarget:       cfg.Stratum.SuggestTarget,
+		sharesPerMinute:     cfg.Stratum.SharesPerMinute,
+		maxNTimeRoll:        time.Duration(cfg.Stratum.NTimeRoll),
+		recordFile:          cfg.Stratum.Record,
+	}
+}
+
+//secretMask replaces the passwords and the api token when the configuration is printed
+const secretMask = "********"
+
+//maskSecret returns secretMask if the secret is set
+func maskSecret(secret string) string {
+	if secret == "" {
+		return secret
+	}
+	return secretMask
+}
+
+//maskURL masks the password in the url of a getwork server
+func maskURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.User == nil {
+		return rawurl
+	}
+	if _, set := u.User.Password(); !set {
+		return rawurl
+	}
+	//The userinfo is replaced in the url itself since the mask would be escaped
+	return strings.Replace(rawurl, u.User.String()+"@", url.User(u.User.Username()).String()+":"+secretMask+"@", 1)
+}
+
+//masked returns a copy of the configuration with the passwords and the api token masked so it can be printed
+func (cfg *Config) masked() (m Config) {
+	m = *cfg
+	m.URL = maskURL(cfg.URL)
+	m.APIPassword = maskSecret(cfg.APIPassword)
+	m.API.Token = maskSecret(cfg.API.Token)
+	m.Pools = make([]PoolConfig, len(cfg.Pools))
+	for i, pool := range cfg.Pools {
+		pool.URL = maskURL(pool.URL)
+		pool.Password = maskSecret(pool.Password)
+		m.Pools[i] = pool
+	}
+	return
+}
+
+//configCommand implements `gominer config check [<file>] [flags]`, it validates the configuration
+// and prints the effective configuration, including the flags that override the file
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Println("Usage: gominer config check [<file>] [flags]")
+		os.Exit(2)
+	}
+	args = args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		args = append([]string{"-config", args[0]}, args[1:]...)
+	}
+	cfg, err := parseConfig("config check", args)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	effective, err := json.MarshalIndent(cfg.masked(), "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(string(effective))
+}
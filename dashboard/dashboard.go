@@ -0,0 +1,388 @@
+//Package dashboard shows the state of the miner full screen in a terminal.
+// It has a table of the devices, a panel with the pools and the last lines of the log,
+// the devices can be paused and resumed with hotkeys.
+package dashboard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robvanmieghem/gominer/clients"
+	"github.com/robvanmieghem/gominer/logging"
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+//DefaultInterval is the default time between two redraws of the dashboard
+const DefaultInterval = time.Second
+
+//MaxLogLines is the number of log lines the dashboard keeps
+const MaxLogLines = 500
+
+const (
+	escClearLine   = "\x1b[K"
+	escClearBelow  = "\x1b[J"
+	escHome        = "\x1b[H"
+	escReverse     = "\x1b[7m"
+	escBold        = "\x1b[1m"
+	escReset       = "\x1b[0m"
+	escEnterScreen = "\x1b[?1049h\x1b[?25l"
+	escLeaveScreen = "\x1b[?25h\x1b[?1049l"
+)
+
+//exitLogLines is the number of log lines written to the terminal when the dashboard is closed
+const exitLogLines = 5
+
+//keysHelp is shown in the footer until a key is pressed
+const keysHelp = "Keys: up/down select a device, p pause/resume, a resume all, s summary, q quit"
+
+var dashboardLog = logging.New("dashboard")
+
+//DeviceController pauses and resumes the devices by their device number
+type DeviceController interface {
+	PauseDevice(minerID int) error
+	ResumeDevice(minerID int) error
+}
+
+//Dashboard draws the state of the miner on a terminal and handles the hotkeys.
+// It is also the writer of the log, the lines are shown in the log pane.
+type Dashboard struct {
+	Version  string
+	Stats    *mining.Stats
+	Client   clients.Client
+	Control  DeviceController
+	Interval time.Duration
+
+	mutex sync.Mutex // protects following
+	lines []string
+	//partial is the last line written that is not terminated yet
+	partial  []byte
+	selected int
+	//message is the result of the last hotkey, it is shown in the footer
+	message string
+
+	closeOnce sync.Once
+	restore   func()
+}
+
+//New creates a Dashboard showing stats, the Client and Control can be set later, before calling Run
+func New(stats *mining.Stats) *Dashboard {
+	return &Dashboard{Stats: stats, Interval: DefaultInterval, restore: func() {}}
+}
+
+//Write adds the lines of p to the log pane, only the last MaxLogLines are kept
+func (d *Dashboard) Write(p []byte) (n int, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.partial = append(d.partial, p...)
+	for {
+		i := bytes.IndexByte(d.partial, '\n')
+		if i < 0 {
+			break
+		}
+		d.lines = append(d.lines, string(d.partial[:i]))
+		d.partial = d.partial[i+1:]
+	}
+	if len(d.lines) > MaxLogLines {
+		d.lines = append([]string(nil), d.lines[len(d.lines)-MaxLogLines:]...)
+	}
+	return len(p), nil
+}
+
+//Run switches the terminal to raw mode, draws the dashboard every Interval and handles the keys
+// until q or ctrl-c is pressed or the input is closed. The terminal is restored when it returns.
+func (d *Dashboard) Run(in *os.File, out *os.File) (err error) {
+	restore, err := makeRaw(in)
+	if err != nil {
+		return
+	}
+	d.mutex.Lock()
+	d.restore = func() {
+		io.WriteString(out, escLeaveScreen)
+		restore()
+		//Keep the reason of an exit visible
+		d.mutex.Lock()
+		tail := d.lines
+		d.mutex.Unlock()
+		if len(tail) > exitLogLines {
+			tail = tail[len(tail)-exitLogLines:]
+		}
+		for _, line := range tail {
+			fmt.Fprintln(out, line)
+		}
+	}
+	d.mutex.Unlock()
+	defer d.Close()
+	io.WriteString(out, escEnterScreen)
+
+	keys := make(chan byte)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 16)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, key := range buf[:n] {
+				keys <- key
+			}
+		}
+	}()
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	escape := 0
+	for {
+		width, height := terminalSize(out)
+		var screen bytes.Buffer
+		d.Render(&screen, width, height)
+		out.Write(screen.Bytes())
+		select {
+		case <-ticker.C:
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+			//The arrow keys are sent as ESC [ A and ESC [ B
+			switch {
+			case key == 0x1b:
+				escape = 1
+				continue
+			case escape == 1 && key == '[':
+				escape = 2
+				continue
+			case escape == 2 && key == 'A':
+				key = 'k'
+			case escape == 2 && key == 'B':
+				key = 'j'
+			}
+			escape = 0
+			if !d.HandleKey(key) {
+				return
+			}
+		}
+	}
+}
+
+//Close restores the terminal, it can be called from another goroutine when the miner exits while the dashboard runs
+func (d *Dashboard) Close() {
+	d.closeOnce.Do(func() {
+		d.mutex.Lock()
+		restore := d.restore
+		d.mutex.Unlock()
+		restore()
+	})
+}
+
+//HandleKey executes the action of a hotkey, it returns false if the dashboard should quit:
+// up/down or k/j select a device, p pauses or resumes it, a resumes all devices, s logs a summary and q quits.
+func (d *Dashboard) HandleKey(key byte) (running bool) {
+	devices := d.Stats.Devices()
+	d.mutex.Lock()
+	if d.selected >= len(devices) {
+		d.selected = len(devices) - 1
+	}
+	if d.selected < 0 {
+		d.selected = 0
+	}
+	selected := d.selected
+	d.mutex.Unlock()
+
+	var message string
+	switch key {
+	case 'q', 'Q', 3:
+		return false
+	case 'k':
+		if selected > 0 {
+			selected--
+		}
+	case 'j':
+		if selected < len(devices)-1 {
+			selected++
+		}
+	case 'p', 'P', ' ':
+		if len(devices) == 0 {
+			break
+		}
+		message = d.togglePause(devices[selected])
+	case 'a', 'A':
+		message = "Resumed all devices"
+		for _, device := range devices {
+			if device.Paused {
+				if err := d.control(device.MinerID, DeviceController.ResumeDevice); err != nil {
+					message = err.Error()
+				}
+			}
+		}
+	case 's', 'S':
+		message = Summary(d.Stats.Summary())
+		dashboardLog.Info(message)
+	default:
+		message = keysHelp
+	}
+	d.mutex.Lock()
+	d.selected = selected
+	d.message = message
+	d.mutex.Unlock()
+	return true
+}
+
+//togglePause pauses a device that is mining and resumes a paused one
+func (d *Dashboard) togglePause(device mining.DeviceStats) (message string) {
+	action, verb := DeviceController.PauseDevice, "Paused"
+	if device.Paused {
+		action, verb = DeviceController.ResumeDevice, "Resumed"
+	}
+	if err := d.control(device.MinerID, action); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s device %d", verb, device.MinerID)
+}
+
+//control executes an action on the Control if it is set
+func (d *Dashboard) control(minerID int, action func(c DeviceController, minerID int) error) error {
+	if d.Control == nil {
+		return errors.New("The devices can not be controlled")
+	}
+	return action(d.Control, minerID)
+}
+
+//Summary formats the totals of all devices on a single line
+func Summary(s mining.Summary) string {
+	return fmt.Sprintf("Uptime %s - %.1f MH/s (average %.1f) - accepted %d - rejected %d - stale %d - hardware errors %d",
+		time.Duration(s.Uptime)*time.Second, s.HashRate, s.AverageHashRate, s.Accepted, s.Rejected, s.Stale, s.HardwareErrors)
+}
+
+//Render writes a screen of width by height characters to w
+func (d *Dashboard) Render(w io.Writer, width, height int) {
+	var lines []string
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	summary := d.Stats.Summary()
+	add(escBold+"gominer %s"+escReset+" - %s", d.Version, Summary(summary))
+	add("")
+
+	d.mutex.Lock()
+	selected, message := d.selected, d.message
+	logLines := d.lines
+	d.mutex.Unlock()
+
+	add(escBold+"%-4s %-20s %-7s %8s %8s %8s %8s %6s %6s %4s %5s %6s %5s %6s"+escReset, "Dev", "Name", "State", "MH/s", "Avg MH/s", "Accepted", "Rejected", "Stale", "HW err", "I", "Duty", "Temp", "Fan", "Power")
+	for i, device := range d.Stats.Devices() {
+		temperature, fan, power := "-", "-", "-"
+		if sensors := device.Sensors; sensors != nil {
+			temperature = fmt.Sprintf("%.0f°C", sensors.Temperature)
+			fan = fmt.Sprintf("%.0f%%", sensors.FanSpeed)
+			power = fmt.Sprintf("%.0fW", sensors.Power)
+		}
+		line := fmt.Sprintf("%-4d %-20s %-7s %8.1f %8.1f %8d %8d %6d %6d %4d %4d%% %6s %5s %6s", device.MinerID, truncate(device.Name, 20), deviceState(device),
+			device.HashRate, device.AverageHashRate, device.Accepted, device.Rejected, device.Stale, device.HardwareErrors, device.Intensity,
+			device.DutyCycle, temperature, fan, power)
+		if i == selected {
+			line = escReverse + line + escReset
+		}
+		lines = append(lines, line)
+	}
+	add("")
+
+	add(escBold+"%-40s %-12s %10s %9s %9s %6s"+escReset, "Pool", "State", "Difficulty", "Job age", "Latency", "Jobs")
+	if reporter, ok := d.Client.(clients.StatusReporter); ok {
+		for _, pool := range reporter.PoolStatus() {
+			jobAge := "-"
+			if !pool.JobReceived.IsZero() {
+				jobAge = time.Since(pool.JobReceived).Truncate(time.Second).String()
+			}
+			latency := "-"
+			if pool.Latency > 0 {
+				latency = pool.Latency.Truncate(time.Millisecond).String()
+			}
+			add("%-40s %-12s %10.4g %9s %9s %6d", truncate(pool.URL, 40), poolState(pool), pool.Difficulty, jobAge, latency, pool.Jobs)
+		}
+	}
+	add("")
+
+	//The log fills the remaining lines, except for the footer
+	logHeight := height - len(lines) - 2
+	if logHeight < 0 {
+		logHeight = 0
+	}
+	if len(logLines) > logHeight {
+		logLines = logLines[len(logLines)-logHeight:]
+	}
+	add(escBold + "Log" + escReset)
+	lines = append(lines, logLines...)
+	for i := len(logLines); i < logHeight; i++ {
+		add("")
+	}
+	if message == "" {
+		message = keysHelp
+	}
+	lines = append(lines, message)
+
+	io.WriteString(w, escHome)
+	for i, line := range lines {
+		if i >= height {
+			break
+		}
+		io.WriteString(w, truncate(line, width))
+		io.WriteString(w, escClearLine)
+		if i < len(lines)-1 && i < height-1 {
+			io.WriteString(w, "\r\n")
+		}
+	}
+	io.WriteString(w, escClearBelow)
+}
+
+//deviceState describes what a device is doing
+func deviceState(device mining.DeviceStats) string {
+	switch {
+	case device.Paused:
+		return "Paused"
+	case device.HashRate == 0:
+		return "Idle"
+	default:
+		return "Mining"
+	}
+}
+
+//poolState describes the connection to a pool
+func poolState(pool clients.PoolStatus) string {
+	switch {
+	case !pool.Connected:
+		return "Disconnected"
+	case pool.Active:
+		return "Active"
+	default:
+		return "Standby"
+	}
+}
+
+//truncate shortens s to width characters, the escape sequences are not counted
+func truncate(s string, width int) string {
+	var b strings.Builder
+	visible := 0
+	escape := false
+	for _, r := range s {
+		switch {
+		case r == 0x1b:
+			escape = true
+		case escape:
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+				escape = false
+			}
+		default:
+			if visible >= width {
+				continue
+			}
+			visible++
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
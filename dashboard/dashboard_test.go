@@ -0,0 +1,99 @@
+package dashboard
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+//testController records the actions and reports them to the stats like the miner does
+type testController struct {
+	stats   *mining.Stats
+	actions []string
+}
+
+func (c *testController) PauseDevice(minerID int) error {
+	c.actions = append(c.actions, fmt.Sprint("pause ", minerID))
+	c.stats.ReportState(minerID, 28, true)
+	return nil
+}
+
+func (c *testController) ResumeDevice(minerID int) error {
+	c.actions = append(c.actions, fmt.Sprint("resume ", minerID))
+	c.stats.ReportState(minerID, 28, false)
+	return nil
+}
+
+func TestWrite(t *testing.T) {
+	d := New(mining.NewStats())
+	fmt.Fprint(d, "first\nsec")
+	fmt.Fprint(d, "ond\nthird")
+	if len(d.lines) != 2 || d.lines[0] != "first" || d.lines[1] != "second" || string(d.partial) != "third" {
+		t.Error("Unexpected lines:", d.lines, string(d.partial))
+	}
+	for i := 0; i < MaxLogLines+10; i++ {
+		fmt.Fprintln(d, "line", i)
+	}
+	if len(d.lines) != MaxLogLines || d.lines[MaxLogLines-1] != fmt.Sprint("line ", MaxLogLines+9) {
+		t.Error("Unexpected number of lines:", len(d.lines), d.lines[len(d.lines)-1])
+	}
+}
+
+func TestHandleKey(t *testing.T) {
+	stats := mining.NewStats()
+	stats.AddDevice(0, "Fiji")
+	stats.AddDevice(1, "Ellesmere")
+	control := &testController{stats: stats}
+	d := New(stats)
+	d.Control = control
+
+	for _, key := range []byte{'j', 'p', 'k', ' ', 'j', 'p', 'a'} {
+		if !d.HandleKey(key) {
+			t.Fatal("Quit on", string(key))
+		}
+	}
+	expected := "pause 1,pause 0,resume 1,resume 0"
+	if actions := strings.Join(control.actions, ","); actions != expected {
+		t.Error("Actions are", actions, "instead of", expected)
+	}
+	if d.HandleKey('q') {
+		t.Error("q did not quit")
+	}
+
+	//Without a controller the devices can not be paused
+	d.Control = nil
+	d.HandleKey('p')
+	if !strings.Contains(d.message, "can not be controlled") {
+		t.Error("Unexpected message:", d.message)
+	}
+}
+
+func TestRender(t *testing.T) {
+	stats := mining.NewStats()
+	stats.AddDevice(0, "Fiji")
+	stats.ReportHashRate(&mining.HashRateReport{MinerID: 0, HashRate: 123.4})
+	stats.ReportSensors(0, mining.SensorReading{Temperature: 71, FanSpeed: 45, Power: 140})
+	d := New(stats)
+	d.Version = "1.0"
+	for i := 0; i < 50; i++ {
+		fmt.Fprintln(d, "log line", i)
+	}
+
+	var b bytes.Buffer
+	d.Render(&b, 120, 20)
+	screen := b.String()
+	for _, expected := range []string{"gominer 1.0", "Fiji", "123.4", "Mining", "100%", "71°C", "45%", "140W", "log line 49", keysHelp} {
+		if !strings.Contains(screen, expected) {
+			t.Error("The screen does not contain", expected)
+		}
+	}
+	if strings.Contains(screen, "log line 38") {
+		t.Error("The log does not fit on the screen")
+	}
+	if lines := strings.Count(screen, "\r\n") + 1; lines != 20 {
+		t.Error("The screen has", lines, "lines instead of 20")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package dashboard
+
+import "os"
+
+//makeRaw does not change the terminal on this platform, the keys are read after pressing enter
+func makeRaw(f *os.File) (restore func(), err error) {
+	return func() {}, nil
+}
+
+//terminalSize returns 80 by 24 since the size is not known on this platform
+func terminalSize(f *os.File) (width, height int) {
+	return 80, 24
+}
@@ -0,0 +1,52 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package dashboard
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+//makeRaw switches the terminal to raw mode so the keys are read without waiting for enter and are not echoed,
+// the returned function restores the previous mode
+func makeRaw(f *os.File) (restore func(), err error) {
+	var original syscall.Termios
+	if err = ioctl(f, ioctlGetTermios, unsafe.Pointer(&original)); err != nil {
+		return
+	}
+	raw := original
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err = ioctl(f, ioctlSetTermios, unsafe.Pointer(&raw)); err != nil {
+		return
+	}
+	restore = func() {
+		ioctl(f, ioctlSetTermios, unsafe.Pointer(&original))
+	}
+	return
+}
+
+//winsize is the size of the terminal as returned by TIOCGWINSZ
+type winsize struct {
+	rows, cols, xpixel, ypixel uint16
+}
+
+//terminalSize returns the number of columns and rows of the terminal, 80 by 24 if it is unknown
+func terminalSize(f *os.File) (width, height int) {
+	var ws winsize
+	if err := ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil || ws.cols == 0 || ws.rows == 0 {
+		return 80, 24
+	}
+	return int(ws.cols), int(ws.rows)
+}
+
+func ioctl(f *os.File, request uintptr, arg unsafe.Pointer) (err error) {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(arg)); errno != 0 {
+		err = errno
+	}
+	return
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/robvanmieghem/go-opencl/cl"
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+var devicesLog = logging.New("devices")
+
+//deviceInfo describes an OpenCL device to select it on
+type deviceInfo struct {
+	//Index is the number of the device among the devices of the types to mine on, -1 for the other devices
+	Index int
+	//Platform is the index of the platform and PlatformDevice the index of the device among all devices of the platform
+	Platform        int
+	PlatformDevice  int
+	PlatformName    string
+	PlatformVendor  string
+	PlatformVersion string
+	Name            string
+	Vendor          string
+	Type            cl.DeviceType
+	//PCIBusID is the PCI address of a GPU like 0000:01:00.0, it is empty if the driver does not expose it
+	PCIBusID string
+
+	device *cl.Device
+}
+
+//ID is the identifier of the device that does not depend on the types of the devices to mine on, <platform>:<device>
+func (d *deviceInfo) ID() string {
+	return fmt.Sprintf("%d:%d", d.Platform, d.PlatformDevice)
+}
+
+//enumerateDevices lists the devices of all platforms, only the devices of deviceTypes get an Index
+func enumerateDevices(deviceTypes cl.DeviceType) (devices []*deviceInfo, err error) {
+	platforms, err := cl.GetPlatforms()
+	if err != nil {
+		return
+	}
+	index := 0
+	for p, platform := range platforms {
+		platformDevices, err := cl.GetDevices(platform, cl.DeviceTypeAll)
+		if err != nil {
+			devicesLog.Error("Listing the devices of platform", platform.Name(), "-", err)
+			continue
+		}
+		for i, device := range platformDevices {
+			d := &deviceInfo{
+				Index:           -1,
+				Platform:        p,
+				PlatformDevice:  i,
+				PlatformName:    platform.Name(),
+				PlatformVendor:  platform.Vendor(),
+				PlatformVersion: platform.Version(),
+				Name:            device.Name(),
+				Vendor:          device.Vendor(),
+				Type:            device.Type(),
+				device:          device,
+			}
+			//The PCI bus id is only known if the driver exposes it, it stays empty otherwise
+			d.PCIBusID, _ = device.PCIBusID()
+			if d.Type&deviceTypes != 0 {
+				d.Index = index
+				index++
+			}
+			devices = append(devices, d)
+		}
+	}
+	return
+}
+
+//deviceSelector selects the devices that match any of its terms
+type deviceSelector []func(d *deviceInfo) bool
+
+//parseDeviceSelector parses a comma separated list of terms, a term is one of
+// <n> or <n>-<m>: the device number or a range of them, as listed when the miner starts,
+// <platform>:<device>: the index of the platform and the index of the device on the platform,
+// this does not change when other types of devices are mined on,
+// platform:<text>: the devices of the platforms with the text in their name or vendor,
+// vendor:<text>: the devices with the text in their vendor,
+// name~=<regexp>: the devices with a name matching the regular expression,
+// pci:<address>: the GPU at the PCI address, the domain can be left out like in pci:01:00.0.
+// The texts and regular expressions are case insensitive.
+func parseDeviceSelector(selector string) (ds deviceSelector, err error) {
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		var match func(d *deviceInfo) bool
+		switch {
+		case term == "":
+			continue
+		case strings.HasPrefix(term, "name~="):
+			var re *regexp.Regexp
+			if re, err = regexp.Compile("(?i)" + strings.TrimPrefix(term, "name~=")); err != nil {
+				return
+			}
+			match = func(d *deviceInfo) bool { return re.MatchString(d.Name) }
+		case strings.HasPrefix(term, "platform:"):
+			text := strings.ToLower(strings.TrimPrefix(term, "platform:"))
+			match = func(d *deviceInfo) bool {
+				return strings.Contains(strings.ToLower(d.PlatformName), text) || strings.Contains(strings.ToLower(d.PlatformVendor), text)
+			}
+		case strings.HasPrefix(term, "vendor:"):
+			text := strings.ToLower(strings.TrimPrefix(term, "vendor:"))
+			match = func(d *deviceInfo) bool { return strings.Contains(strings.ToLower(d.Vendor), text) }
+		case strings.HasPrefix(term, "pci:"):
+			address := strings.ToLower(strings.TrimPrefix(term, "pci:"))
+			if address == "" {
+				return nil, fmt.Errorf("Invalid device selector %s", term)
+			}
+			match = func(d *deviceInfo) bool {
+				busID := strings.ToLower(d.PCIBusID)
+				return busID != "" && (busID == address || strings.HasSuffix(busID, ":"+address))
+			}
+		case strings.Contains(term, ":"):
+			parts := strings.SplitN(term, ":", 2)
+			var platform, first, last int
+			if platform, err = strconv.Atoi(parts[0]); err != nil {
+				return nil, fmt.Errorf("Invalid device selector %s", term)
+			}
+			if first, last, err = parseRange(parts[1]); err != nil {
+				return nil, fmt.Errorf("Invalid device selector %s", term)
+			}
+			match = func(d *deviceInfo) bool {
+				return d.Platform == platform && d.PlatformDevice >= first && d.PlatformDevice <= last
+			}
+		default:
+			var first, last int
+			if first, last, err = parseRange(term); err != nil {
+				return nil, fmt.Errorf("Invalid device selector %s", term)
+			}
+			match = func(d *deviceInfo) bool { return d.Index >= 0 && d.Index >= first && d.Index <= last }
+		}
+		ds = append(ds, match)
+	}
+	return
+}
+
+//parseRange parses a number or a range of numbers in the form <first>-<last>
+func parseRange(value string) (first, last int, err error) {
+	parts := strings.SplitN(value, "-", 2)
+	if first, err = strconv.Atoi(parts[0]); err != nil {
+		return
+	}
+	last = first
+	if len(parts) == 2 {
+		if last, err = strconv.Atoi(parts[1]); err != nil {
+			return
+		}
+	}
+	if first < 0 || last < first {
+		err = errors.New("Invalid range")
+	}
+	return
+}
+
+//matches checks if any of the terms matches the device
+func (ds deviceSelector) matches(d *deviceInfo) bool {
+	for _, match := range ds {
+		if match(d) {
+			return true
+		}
+	}
+	return false
+}
+
+//selectDevices returns the devices to mine on by their Index: the devices of the types to mine on that are selected,
+// all of them if selection is empty, and that are not excluded
+func selectDevices(devices []*deviceInfo, selection, exclusion string) (selected map[int]*deviceInfo, err error) {
+	include, err := parseDeviceSelector(selection)
+	if err != nil {
+		return
+	}
+	exclude, err := parseDeviceSelector(exclusion)
+	if err != nil {
+		return
+	}
+	selected = make(map[int]*deviceInfo)
+	for _, d := range devices {
+		if d.Index < 0 || (len(include) > 0 && !include.matches(d)) || exclude.matches(d) {
+			continue
+		}
+		selected[d.Index] = d
+	}
+	return
+}
+
+//platformDescription is a platform as listed by the devices command
+type platformDescription struct {
+	Index   int                 `json:"index"`
+	Name    string              `json:"name"`
+	Vendor  string              `json:"vendor"`
+	Version string              `json:"version"`
+	Devices []deviceDescription `json:"devices"`
+}
+
+//deviceDescription is a device and its capabilities as listed by the devices command
+type deviceDescription struct {
+	//Index is the device number, -1 if it is not of the types to mine on
+	Index  int    `json:"index"`
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Vendor string `json:"vendor"`
+	//PCIBusID is empty if the driver does not expose it
+	PCIBusID string `json:"pcibusid"`
+	//Selected tells if the device is mined on with the current selection
+	Selected          bool     `json:"selected"`
+	Available         bool     `json:"available"`
+	MaxComputeUnits   int      `json:"maxcomputeunits"`
+	MaxClockFrequency int      `json:"maxclockfrequency"`
+	GlobalMemSize     int64    `json:"globalmemsize"`
+	MaxMemAllocSize   int64    `json:"maxmemallocsize"`
+	LocalMemSize      int64    `json:"localmemsize"`
+	MaxWorkGroupSize  int      `json:"maxworkgroupsize"`
+	Version           string   `json:"version"`
+	DriverVersion     string   `json:"driverversion"`
+	OpenCLCVersion    string   `json:"openclcversion"`
+	Extensions        []string `json:"extensions"`
+}
+
+//describeDevices queries the capabilities of the devices and groups them by platform
+func describeDevices(devices []*deviceInfo, selected map[int]*deviceInfo) (platforms []platformDescription) {
+	platforms = []platformDescription{}
+	for _, d := range devices {
+		if len(platforms) == 0 || platforms[len(platforms)-1].Index != d.Platform {
+			platforms = append(platforms, platformDescription{Index: d.Platform, Name: d.PlatformName, Vendor: d.PlatformVendor, Version: d.PlatformVersion})
+		}
+		platform := &platforms[len(platforms)-1]
+		platform.Devices = append(platform.Devices, deviceDescription{
+			Index:             d.Index,
+			ID:                d.ID(),
+			Type:              d.Type.String(),
+			Name:              d.Name,
+			Vendor:            d.Vendor,
+			PCIBusID:          d.PCIBusID,
+			Selected:          d.Index >= 0 && selected[d.Index] == d,
+			Available:         d.device.Available(),
+			MaxComputeUnits:   d.device.MaxComputeUnits(),
+			MaxClockFrequency: d.device.MaxClockFrequency(),
+			GlobalMemSize:     d.device.GlobalMemSize(),
+			MaxMemAllocSize:   d.device.MaxMemAllocSize(),
+			LocalMemSize:      d.device.LocalMemSize(),
+			MaxWorkGroupSize:  d.device.MaxWorkGroupSize(),
+			Version:           d.device.Version(),
+			DriverVersion:     d.device.DriverVersion(),
+			OpenCLCVersion:    d.device.OpenCLCVersion(),
+			Extensions:        strings.Fields(d.device.Extensions()),
+		})
+	}
+	return
+}
+
+//writeDevicesTable writes the platforms and their devices as a table for humans
+func writeDevicesTable(out io.Writer, platforms []platformDescription) (err error) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	for _, p := range platforms {
+		fmt.Fprintf(w, "Platform %d: %s - %s - %s\n", p.Index, p.Name, p.Vendor, p.Version)
+		fmt.Fprintln(w, "  #\tID\tTYPE\tNAME\tCU\tMHZ\tMEMORY\tWORKGROUP\tDRIVER\tOPENCL C\tPCI\tSELECTED")
+		for _, d := range p.Devices {
+			index := "-"
+			if d.Index >= 0 {
+				index = strconv.Itoa(d.Index)
+			}
+			pciBusID := "-"
+			if d.PCIBusID != "" {
+				pciBusID = d.PCIBusID
+			}
+			selected := "no"
+			if d.Selected {
+				selected = "yes"
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%d\t%d\t%d MB\t%d\t%s\t%s\t%s\t%s\n", index, d.ID, d.Type, d.Name,
+				d.MaxComputeUnits, d.MaxClockFrequency, d.GlobalMemSize>>20, d.MaxWorkGroupSize, d.DriverVersion, d.OpenCLCVersion, pciBusID, selected)
+		}
+	}
+	return w.Flush()
+}
+
+//devicesCommand lists the OpenCL platforms and devices with their capabilities,
+// it takes the flags of the miner to tell which devices would be mined on
+func devicesCommand(args []string) {
+	var jsonOutput bool
+	cfg, err := parseConfig("devices", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&jsonOutput, "json", false, "write the platforms and devices as json")
+	})
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		devicesLog.Fatal(err)
+	}
+	devices, err := enumerateDevices(cfg.deviceTypes())
+	if err != nil {
+		devicesLog.Fatal("Listing the OpenCL platforms -", err)
+	}
+	selected, err := selectDevices(devices, cfg.Devices.Select, cfg.Devices.Exclude)
+	if err != nil {
+		devicesLog.Fatal(err)
+	}
+	platforms := describeDevices(devices, selected)
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(platforms)
+	} else {
+		err = writeDevicesTable(os.Stdout, platforms)
+	}
+	if err != nil {
+		devicesLog.Fatal(err)
+	}
+}
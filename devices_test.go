@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/robvanmieghem/go-opencl/cl"
+)
+
+//testDevices are two platforms, the CPU of the second one is not mined on
+var testDevices = []*deviceInfo{
+	{Index: 0, Platform: 0, PlatformDevice: 0, PlatformName: "AMD Accelerated Parallel Processing", PlatformVendor: "Advanced Micro Devices, Inc.", Name: "Ellesmere", Vendor: "Advanced Micro Devices, Inc.", Type: cl.DeviceTypeGPU},
+	{Index: 1, Platform: 0, PlatformDevice: 1, PlatformName: "AMD Accelerated Parallel Processing", PlatformVendor: "Advanced Micro Devices, Inc.", Name: "Fiji", Vendor: "Advanced Micro Devices, Inc.", Type: cl.DeviceTypeGPU},
+	{Index: -1, Platform: 1, PlatformDevice: 0, PlatformName: "NVIDIA CUDA", PlatformVendor: "NVIDIA Corporation", Name: "Intel(R) Core(TM) i7", Vendor: "GenuineIntel", Type: cl.DeviceTypeCPU},
+	{Index: 2, Platform: 1, PlatformDevice: 1, PlatformName: "NVIDIA CUDA", PlatformVendor: "NVIDIA Corporation", Name: "GeForce GTX 1070", Vendor: "NVIDIA Corporation", Type: cl.DeviceTypeGPU},
+	{Index: 3, Platform: 1, PlatformDevice: 2, PlatformName: "NVIDIA CUDA", PlatformVendor: "NVIDIA Corporation", Name: "GeForce GTX 1080", Vendor: "NVIDIA Corporation", Type: cl.DeviceTypeGPU},
+}
+
+func TestSelectDevices(t *testing.T) {
+	testSet := []struct {
+		selection string
+		exclusion string
+		selected  []int
+	}{
+		{"", "", []int{0, 1, 2, 3}},
+		{"0,2-3", "", []int{0, 2, 3}},
+		{"", "1", []int{0, 2, 3}},
+		{"platform:amd", "", []int{0, 1}},
+		{"vendor:NVIDIA", "name~=1080$", []int{2}},
+		{"name~=ellesmere|fiji", "", []int{0, 1}},
+		{"1:1-2", "", []int{2, 3}},
+		{"1:0", "", []int{}},
+		{"0:1, 3", "", []int{1, 3}},
+		{"", "3,2", []int{0, 1}},
+		{"", "0", []int{1, 2, 3}},
+	}
+	for _, test := range testSet {
+		selected, err := selectDevices(testDevices, test.selection, test.exclusion)
+		if err != nil {
+			t.Error(test, err)
+			continue
+		}
+		indexes := make([]int, 0, len(selected))
+		for index := range selected {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+		if len(indexes) != len(test.selected) {
+			t.Error(test, "selected", indexes)
+			continue
+		}
+		for i := range indexes {
+			if indexes[i] != test.selected[i] {
+				t.Error(test, "selected", indexes)
+				break
+			}
+		}
+	}
+	for _, invalid := range []string{"a", "3-1", "-1", "x:1", "name~=(", "pci:"} {
+		if _, err := parseDeviceSelector(invalid); err == nil {
+			t.Error("No error for", invalid)
+		}
+	}
+}
+
+func TestPCIBusIDSelector(t *testing.T) {
+	devices := make([]*deviceInfo, len(testDevices))
+	for i, d := range testDevices {
+		device := *d
+		devices[i] = &device
+	}
+	//The CPU and the second NVIDIA GPU do not expose a PCI bus id
+	devices[0].PCIBusID = "0000:01:00.0"
+	devices[1].PCIBusID = "0000:03:00.0"
+	devices[3].PCIBusID = "0000:04:00.0"
+	for selection, expected := range map[string]int{"pci:0000:01:00.0": 0, "pci:03:00.0": 1, "pci:04:00.0": 2} {
+		selected, err := selectDevices(devices, selection, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(selected) != 1 || selected[expected] == nil {
+			t.Error(selection, "selected", selected)
+		}
+	}
+	//Only the GPU at the address is excluded, the one without a PCI bus id is not
+	if selected, _ := selectDevices(devices, "", "pci:0000:04:00.0"); len(selected) != 3 || selected[3] == nil {
+		t.Error("Unexpected selection:", selected)
+	}
+}
+
+func TestWriteDevicesTable(t *testing.T) {
+	platforms := []platformDescription{{Index: 0, Name: "AMD Accelerated Parallel Processing", Vendor: "Advanced Micro Devices, Inc.", Version: "OpenCL 2.0 AMD-APP (2348.3)",
+		Devices: []deviceDescription{
+			{Index: 0, ID: "0:0", Type: "GPU", Name: "Ellesmere", MaxComputeUnits: 36, MaxClockFrequency: 1340, GlobalMemSize: 8 << 30, MaxWorkGroupSize: 256, DriverVersion: "2348.3", OpenCLCVersion: "OpenCL C 2.0", Selected: true},
+			{Index: -1, ID: "0:1", Type: "CPU", Name: "Intel(R) Core(TM) i7"},
+		}}}
+	var out bytes.Buffer
+	if err := writeDevicesTable(&out, platforms); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatal("Unexpected table:\n", out.String())
+	}
+	if !strings.HasPrefix(lines[0], "Platform 0: AMD Accelerated Parallel Processing") {
+		t.Error("Unexpected platform line:", lines[0])
+	}
+	if fields := strings.Fields(lines[2]); len(fields) < 11 || fields[0] != "0" || fields[1] != "0:0" || fields[6] != "8192" || fields[len(fields)-1] != "yes" {
+		t.Error("Unexpected device line:", lines[2])
+	}
+	if fields := strings.Fields(lines[3]); fields[0] != "-" || fields[len(fields)-1] != "no" {
+		t.Error("Unexpected device line:", lines[3])
+	}
+}
@@ -0,0 +1,133 @@
+//Package hwmon reads the temperature, fan and power sensors of the GPU's through the Linux hwmon interface
+// and protects the devices against overheating.
+//
+// The amdgpu driver exposes the sensors of a card in /sys/class/drm/card<N>/device/hwmon/hwmon<M>,
+// the temperature in temp1_input in millidegrees Celsius, the fan duty in pwm1 from 0 to pwm1_max,
+// the fan speed in fan1_input in rpm and the average power draw in power1_average in microwatts.
+package hwmon
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+//DefaultRoot is the sysfs root the cards are looked up in
+const DefaultRoot = "/sys"
+
+//PCI vendor ids of the GPU vendors
+const (
+	VendorAMD    = "0x1002"
+	VendorNVIDIA = "0x10de"
+	VendorIntel  = "0x8086"
+)
+
+//cardName matches the drm cards, not their connectors like card0-DP-1
+var cardName = regexp.MustCompile(`^card[0-9]+$`)
+
+//Card is a drm card with its hwmon node
+type Card struct {
+	//Name is the name of the card in /sys/class/drm, like card0
+	Name string
+	//Slot is the PCI address of the card, like 0000:01:00.0
+	Slot string
+	//Vendor is the PCI vendor id, like 0x1002
+	Vendor string
+	//HWMon is the directory with the sensors of the card, it is empty if the driver does not expose them
+	HWMon string
+}
+
+//Cards lists the drm cards under the sysfs root, ordered by their PCI address
+func Cards(root string) (cards []Card, err error) {
+	entries, err := ioutil.ReadDir(filepath.Join(root, "class", "drm"))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !cardName.MatchString(entry.Name()) {
+			continue
+		}
+		device := filepath.Join(root, "class", "drm", entry.Name(), "device")
+		card := Card{Name: entry.Name(), Vendor: readString(filepath.Join(device, "vendor"))}
+		for _, line := range strings.Split(readString(filepath.Join(device, "uevent")), "\n") {
+			if strings.HasPrefix(line, "PCI_SLOT_NAME=") {
+				card.Slot = strings.TrimPrefix(line, "PCI_SLOT_NAME=")
+			}
+		}
+		if nodes, _ := filepath.Glob(filepath.Join(device, "hwmon", "hwmon*")); len(nodes) > 0 {
+			card.HWMon = nodes[0]
+		}
+		cards = append(cards, card)
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		if cards[i].Slot != cards[j].Slot {
+			return cards[i].Slot < cards[j].Slot
+		}
+		return cards[i].Name < cards[j].Name
+	})
+	return
+}
+
+//VendorID returns the PCI vendor id of an OpenCL vendor name, or an empty string if it is not known
+func VendorID(vendor string) string {
+	vendor = strings.ToLower(vendor)
+	switch {
+	case strings.Contains(vendor, "advanced micro devices") || strings.Contains(vendor, "amd"):
+		return VendorAMD
+	case strings.Contains(vendor, "nvidia"):
+		return VendorNVIDIA
+	case strings.Contains(vendor, "intel"):
+		return VendorIntel
+	}
+	return ""
+}
+
+//Read reads the sensors in a hwmon directory, the temperature is required, the other sensors are 0 if they are missing
+func Read(dir string) (reading mining.SensorReading, err error) {
+	temperature, err := readNumber(filepath.Join(dir, "temp1_input"))
+	if err != nil {
+		return
+	}
+	reading.Time = time.Now()
+	reading.Temperature = temperature / 1000
+	if pwm, e := readNumber(filepath.Join(dir, "pwm1")); e == nil {
+		max, e := readNumber(filepath.Join(dir, "pwm1_max"))
+		if e != nil || max <= 0 {
+			max = 255
+		}
+		reading.FanSpeed = pwm * 100 / max
+	}
+	if rpm, e := readNumber(filepath.Join(dir, "fan1_input")); e == nil {
+		reading.FanRPM = int(rpm)
+	}
+	if power, e := readNumber(filepath.Join(dir, "power1_average")); e == nil {
+		reading.Power = power / 1000000
+	}
+	return
+}
+
+//readNumber reads a file with a single number
+func readNumber(file string) (value float64, err error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	value, err = strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+	if err != nil {
+		err = errors.New("Invalid value in " + file)
+	}
+	return
+}
+
+//readString reads a small text file, it returns an empty string if it can not be read
+func readString(file string) string {
+	content, _ := ioutil.ReadFile(file)
+	return strings.TrimSpace(string(content))
+}
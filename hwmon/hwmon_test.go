@@ -0,0 +1,176 @@
+package hwmon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+//writeFiles creates the files with their content under root
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//fakeSysfs creates a sysfs tree with two AMD cards, listed in a different order than their PCI addresses,
+// an Intel card without sensors and a connector
+func fakeSysfs(t *testing.T) (root string) {
+	root, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, map[string]string{
+		"class/drm/card0/device/vendor":                      VendorIntel,
+		"class/drm/card0/device/uevent":                      "DRIVER=i915\nPCI_SLOT_NAME=0000:00:02.0",
+		"class/drm/card1/device/vendor":                      VendorAMD,
+		"class/drm/card1/device/uevent":                      "DRIVER=amdgpu\nPCI_SLOT_NAME=0000:03:00.0",
+		"class/drm/card1/device/hwmon/hwmon4/temp1_input":    "65000",
+		"class/drm/card1/device/hwmon/hwmon4/pwm1":           "102",
+		"class/drm/card1/device/hwmon/hwmon4/pwm1_max":       "255",
+		"class/drm/card1/device/hwmon/hwmon4/fan1_input":     "1800",
+		"class/drm/card1/device/hwmon/hwmon4/power1_average": "120500000",
+		"class/drm/card2/device/vendor":                      VendorAMD,
+		"class/drm/card2/device/uevent":                      "DRIVER=amdgpu\nPCI_SLOT_NAME=0000:01:00.0",
+		"class/drm/card2/device/hwmon/hwmon3/temp1_input":    "54000",
+		"class/drm/card1-DP-1/status":                        "connected",
+	})
+	return
+}
+
+func TestCards(t *testing.T) {
+	root := fakeSysfs(t)
+	defer os.RemoveAll(root)
+	cards, err := Cards(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, card := range cards {
+		names = append(names, card.Name)
+	}
+	if strings.Join(names, ",") != "card0,card2,card1" {
+		t.Fatal("Unexpected cards:", names)
+	}
+	if cards[0].HWMon != "" || cards[1].Vendor != VendorAMD || cards[1].Slot != "0000:01:00.0" || filepath.Base(cards[2].HWMon) != "hwmon4" {
+		t.Error("Unexpected cards:", cards)
+	}
+
+	reading, err := Read(cards[2].HWMon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.Temperature != 65 || reading.FanSpeed != 40 || reading.FanRPM != 1800 || reading.Power != 120.5 || reading.Time.IsZero() {
+		t.Error("Unexpected reading:", reading)
+	}
+	//Only the temperature is required
+	if reading, err = Read(cards[1].HWMon); err != nil || reading.Temperature != 54 || reading.FanSpeed != 0 || reading.Power != 0 {
+		t.Error("Unexpected reading:", reading, err)
+	}
+	if _, err = Read(filepath.Join(root, "missing")); err == nil {
+		t.Error("No error for a missing hwmon directory")
+	}
+}
+
+func TestVendorID(t *testing.T) {
+	for vendor, id := range map[string]string{
+		"Advanced Micro Devices, Inc.": VendorAMD,
+		"AMD":                          VendorAMD,
+		"NVIDIA Corporation":           VendorNVIDIA,
+		"Intel(R) Corporation":         VendorIntel,
+		"GenuineIntel":                 VendorIntel,
+		"Unknown":                      "",
+	} {
+		if VendorID(vendor) != id {
+			t.Error("Vendor id of", vendor, "is", VendorID(vendor), "instead of", id)
+		}
+	}
+}
+
+//testController records the actions of the monitor
+type testController struct {
+	actions []string
+}
+
+func (c *testController) PauseDevice(minerID int) error {
+	c.actions = append(c.actions, fmt.Sprint("pause ", minerID))
+	return nil
+}
+
+func (c *testController) ResumeDevice(minerID int) error {
+	c.actions = append(c.actions, fmt.Sprint("resume ", minerID))
+	return nil
+}
+
+func (c *testController) SetDutyCycle(minerID, percent int) error {
+	c.actions = append(c.actions, fmt.Sprint("duty ", minerID, " ", percent))
+	return nil
+}
+
+//checkTemperatures lets the monitor check the temperatures one by one and returns the actions it took
+func checkTemperatures(t *testing.T, m *Monitor, dir string, temperatures ...int) string {
+	control := m.Control.(*testController)
+	control.actions = nil
+	for _, temperature := range temperatures {
+		writeFiles(t, dir, map[string]string{"temp1_input": fmt.Sprint(temperature * 1000)})
+		m.Check()
+	}
+	return strings.Join(control.actions, ",")
+}
+
+func TestMonitor(t *testing.T) {
+	root, err := ioutil.TempDir("", "hwmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	stats := mining.NewStats()
+	m := NewMonitor(map[int]string{2: root}, stats, &testController{})
+
+	//Without a limit the readings are only reported
+	if actions := checkTemperatures(t, m, root, 95); actions != "" {
+		t.Error("Unexpected actions without a limit:", actions)
+	}
+	if d := stats.Devices(); len(d) != 1 || d[0].MinerID != 2 || d[0].Sensors == nil || d[0].Sensors.Temperature != 95 {
+		t.Fatal("Reading not reported:", d)
+	}
+
+	//Throttling lowers the duty cycle while the device is too hot and raises it again once it is below the resume temperature
+	m.Limit = 80
+	expected := "duty 2 90,duty 2 80,duty 2 90,duty 2 100"
+	if actions := checkTemperatures(t, m, root, 81, 80, 75, 70, 60, 60); actions != expected {
+		t.Error("Throttled with", actions, "instead of", expected)
+	}
+	//A device that is still too hot at the minimum duty cycle is paused
+	expected = "duty 2 90,duty 2 80,duty 2 70,duty 2 60,duty 2 50,duty 2 40,duty 2 30,duty 2 20,duty 2 10,pause 2,pause 2,resume 2,duty 2 20"
+	if actions := checkTemperatures(t, m, root, 90, 90, 90, 90, 90, 90, 90, 90, 90, 90, 90, 70); actions != expected {
+		t.Error("Throttled with", actions, "instead of", expected)
+	}
+
+	m = NewMonitor(map[int]string{2: root}, stats, &testController{})
+	m.Limit, m.Resume, m.Action = 80, 75, Pause
+	expected = "pause 2,pause 2,resume 2"
+	if actions := checkTemperatures(t, m, root, 85, 80, 78, 75, 70); actions != expected {
+		t.Error("Paused with", actions, "instead of", expected)
+	}
+
+	for action, expected := range map[string]Action{"": Throttle, "throttle": Throttle, "pause": Pause} {
+		if a, err := ParseAction(action); err != nil || a != expected {
+			t.Error("Unexpected action for", action, a, err)
+		}
+	}
+	if _, err := ParseAction("stop"); err == nil {
+		t.Error("No error for an invalid action")
+	}
+}
@@ -0,0 +1,174 @@
+package hwmon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robvanmieghem/gominer/logging"
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+//DefaultInterval is the default time between two readings of the sensors
+const DefaultInterval = 5 * time.Second
+
+//DefaultResumeMargin is the number of degrees below the limit a device has to cool down to before it mines at full speed again
+const DefaultResumeMargin = 10
+
+//ThrottleStep is the number of percent the duty cycle of a device changes with every reading
+const ThrottleStep = 10
+
+//MinDutyCycle is the lowest duty cycle a device is throttled to, it is paused if it is still too hot
+const MinDutyCycle = 10
+
+//Action is what the Monitor does with a device that is too hot
+type Action int
+
+const (
+	//Throttle lowers the duty cycle of a hot device step by step, it is paused if it is still too hot at the MinDutyCycle
+	Throttle Action = iota
+	//Pause pauses a hot device until it cools down
+	Pause
+)
+
+//ParseAction parses throttle or pause
+func ParseAction(action string) (a Action, err error) {
+	switch action {
+	case "", "throttle":
+		a = Throttle
+	case "pause":
+		a = Pause
+	default:
+		err = fmt.Errorf("Invalid thermal action %s, use throttle or pause", action)
+	}
+	return
+}
+
+//Controller throttles and pauses the devices by their device number
+type Controller interface {
+	PauseDevice(minerID int) error
+	ResumeDevice(minerID int) error
+	SetDutyCycle(minerID, percent int) error
+}
+
+var monitorLog = logging.New("hwmon")
+
+//Monitor reads the sensors of the devices every Interval and reports them to the Stats.
+// If a Limit is set, the devices that reach it are throttled or paused until they cool down to the Resume temperature.
+type Monitor struct {
+	//Sensors are the hwmon directories of the devices by their device number
+	Sensors  map[int]string
+	Stats    *mining.Stats
+	Control  Controller
+	Interval time.Duration
+	//Limit is the temperature in degrees Celsius at which a device is throttled or paused, 0 disables the protection
+	Limit float64
+	//Resume is the temperature a device has to cool down to before it mines at full speed again,
+	// it is DefaultResumeMargin degrees below the Limit if it is 0
+	Resume float64
+	Action Action
+
+	//devices is the state of the protection of the devices, it is only used by Check
+	devices map[int]*deviceState
+}
+
+//deviceState is what the Monitor did to a device
+type deviceState struct {
+	dutyCycle int
+	paused    bool
+	//failed is set when the sensors can not be read, so the error is only logged once
+	failed bool
+}
+
+//NewMonitor creates a Monitor for the sensors, it only reports the readings until a Limit is set
+func NewMonitor(sensors map[int]string, stats *mining.Stats, control Controller) *Monitor {
+	return &Monitor{Sensors: sensors, Stats: stats, Control: control, Interval: DefaultInterval}
+}
+
+//Run checks the devices every Interval until stop is closed
+func (m *Monitor) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		m.Check()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+//Check reads the sensors of all devices once and throttles, pauses or resumes them if needed
+func (m *Monitor) Check() {
+	if m.devices == nil {
+		m.devices = make(map[int]*deviceState)
+	}
+	for minerID, dir := range m.Sensors {
+		state, found := m.devices[minerID]
+		if !found {
+			state = &deviceState{dutyCycle: 100}
+			m.devices[minerID] = state
+		}
+		logger := monitorLog.With("device", minerID)
+		reading, err := Read(dir)
+		if err != nil {
+			if !state.failed {
+				logger.Warn("Unable to read the sensors -", err)
+			}
+			state.failed = true
+			continue
+		}
+		state.failed = false
+		m.Stats.ReportSensors(minerID, reading)
+		if m.Limit > 0 {
+			m.protect(minerID, state, reading.Temperature, logger)
+		}
+	}
+}
+
+//protect throttles or pauses a device at the Limit and lets it mine again at the Resume temperature
+func (m *Monitor) protect(minerID int, state *deviceState, temperature float64, logger *logging.Logger) {
+	resume := m.Resume
+	if resume == 0 {
+		resume = m.Limit - DefaultResumeMargin
+	}
+	switch {
+	case temperature >= m.Limit:
+		if m.Action == Throttle && state.dutyCycle > MinDutyCycle {
+			state.dutyCycle -= ThrottleStep
+			if state.dutyCycle < MinDutyCycle {
+				state.dutyCycle = MinDutyCycle
+			}
+			logger.Warn(fmt.Sprintf("Temperature %.0f°C reached the limit of %.0f°C, throttling to %d%%", temperature, m.Limit, state.dutyCycle))
+			m.control(logger, m.Control.SetDutyCycle(minerID, state.dutyCycle))
+			return
+		}
+		if !state.paused {
+			logger.Warn(fmt.Sprintf("Temperature %.0f°C reached the limit of %.0f°C, pausing", temperature, m.Limit))
+		}
+		//Pause again in case the device was resumed through the api
+		state.paused = true
+		m.control(logger, m.Control.PauseDevice(minerID))
+	case temperature <= resume:
+		if state.paused {
+			state.paused = false
+			logger.Info(fmt.Sprintf("Temperature %.0f°C is below %.0f°C, resuming", temperature, resume))
+			m.control(logger, m.Control.ResumeDevice(minerID))
+		}
+		if state.dutyCycle < 100 {
+			state.dutyCycle += ThrottleStep
+			if state.dutyCycle > 100 {
+				state.dutyCycle = 100
+			}
+			logger.Info(fmt.Sprintf("Temperature %.0f°C is below %.0f°C, raising the duty cycle to %d%%", temperature, resume, state.dutyCycle))
+			m.control(logger, m.Control.SetDutyCycle(minerID, state.dutyCycle))
+		}
+	}
+}
+
+//control logs the error of a control action
+func (m *Monitor) control(logger *logging.Logger, err error) {
+	if err != nil {
+		logger.Error("Unable to protect the device against overheating -", err)
+	}
+}
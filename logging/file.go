@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+//File is a log file that is rotated when it reaches MaxSize bytes.
+// The rotated files get the suffixes .1, .2, ... with .1 the most recent one, only MaxFiles of them are kept.
+type File struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+
+	mutex sync.Mutex // protects following
+	file  *os.File
+	size  int64
+}
+
+//OpenFile opens or creates a log file, the entries are appended to the existing ones.
+// A MaxSize of 0 disables the rotation.
+func OpenFile(path string, maxSize int64, maxFiles int) (f *File, err error) {
+	f = &File{Path: path, MaxSize: maxSize, MaxFiles: maxFiles}
+	if err = f.open(); err != nil {
+		f = nil
+	}
+	return
+}
+
+//open opens the file at Path for appending
+// This method is not threadsafe
+func (f *File) open() (err error) {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+	f.file = file
+	f.size = info.Size()
+	return
+}
+
+//Write appends p to the file, it rotates the file first if p does not fit anymore
+func (f *File) Write(p []byte) (n int, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return 0, errors.New("The log file is closed")
+	}
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		if err = f.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = f.file.Write(p)
+	f.size += int64(n)
+	return
+}
+
+//rotate renames the current file to .1 after shifting the rotated files and starts a new file
+// This method is not threadsafe
+func (f *File) rotate() (err error) {
+	if err = f.file.Close(); err != nil {
+		return
+	}
+	f.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", f.Path, f.MaxFiles))
+	for i := f.MaxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.Path, i), fmt.Sprintf("%s.%d", f.Path, i+1))
+	}
+	if f.MaxFiles > 0 {
+		err = os.Rename(f.Path, f.Path+".1")
+	} else {
+		err = os.Remove(f.Path)
+	}
+	if err != nil {
+		//Keep appending to the current file
+		f.open()
+		return
+	}
+	return f.open()
+}
+
+//Close closes the file
+func (f *File) Close() (err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return
+	}
+	err = f.file.Close()
+	f.file = nil
+	return
+}
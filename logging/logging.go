@@ -0,0 +1,346 @@
+//Package logging writes the leveled log entries of the components of gominer as text or json lines.
+// Every component has its own Logger, the minimum level of the entries that are written can be set per component.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Level is the severity of a log entry
+type Level int
+
+const (
+	//Debug entries trace the details, like the messages exchanged with a pool
+	Debug Level = iota
+	//Info entries report the normal operation
+	Info
+	//Warn entries report problems gominer recovers from
+	Warn
+	//Error entries report failures
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+//ParseLevel parses the name of a level, case insensitive
+func ParseLevel(name string) (level Level, err error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(strings.TrimSpace(name), levelName) {
+			return Level(i), nil
+		}
+	}
+	err = fmt.Errorf("Invalid log level %q, use %s", name, strings.Join(levelNames, ", "))
+	return
+}
+
+//Levels are the minimum levels of the entries that are written.
+// A level for the first word of a component, like "device", applies to all components starting with it, like "device 1".
+type Levels struct {
+	Default    Level
+	Components map[string]Level
+}
+
+//ParseLevels parses a comma separated list of a default level and <component>=<level> settings, like "warn,stratum=debug".
+// The default level is info if it is not in the list.
+func ParseLevels(spec string) (levels Levels, err error) {
+	levels.Default = Info
+	for _, setting := range strings.Split(spec, ",") {
+		if strings.TrimSpace(setting) == "" {
+			continue
+		}
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) == 1 {
+			if levels.Default, err = ParseLevel(parts[0]); err != nil {
+				return
+			}
+			continue
+		}
+		component := strings.TrimSpace(parts[0])
+		if component == "" {
+			err = fmt.Errorf("Invalid log level setting %q, the component is missing", setting)
+			return
+		}
+		if levels.Components == nil {
+			levels.Components = make(map[string]Level)
+		}
+		if levels.Components[component], err = ParseLevel(parts[1]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//String formats the levels the way ParseLevels reads them
+func (l Levels) String() string {
+	settings := []string{l.Default.String()}
+	for component, level := range l.Components {
+		settings = append(settings, component+"="+level.String())
+	}
+	sort.Strings(settings[1:])
+	return strings.Join(settings, ",")
+}
+
+//level returns the minimum level of a component
+func (l Levels) level(component string) Level {
+	if level, found := l.Components[component]; found {
+		return level
+	}
+	if i := strings.IndexByte(component, ' '); i > 0 {
+		if level, found := l.Components[component[:i]]; found {
+			return level
+		}
+	}
+	return l.Default
+}
+
+//Field is a key and value added to all entries of a Logger
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+//Entry is a single log entry
+type Entry struct {
+	Time      time.Time
+	Level     Level
+	Component string
+	Message   string
+	Fields    []Field
+}
+
+//Line formats the entry without the time and level: "<component> - <message> <key>=<value> ..."
+func (e Entry) Line() string {
+	var b strings.Builder
+	if e.Component != "" {
+		b.WriteString(e.Component)
+		b.WriteString(" - ")
+	}
+	b.WriteString(e.Message)
+	for _, f := range e.Fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	return b.String()
+}
+
+//text formats the entry as a line of text
+func (e Entry) text() []byte {
+	return []byte(fmt.Sprintf("%s %-5s %s\n", e.Time.Format("2006/01/02 15:04:05"), strings.ToUpper(e.Level.String()), e.Line()))
+}
+
+//json formats the entry as a json object on a single line, the fields follow time, level, component and msg
+func (e Entry) json() []byte {
+	var b bytes.Buffer
+	b.WriteString("{")
+	write := func(key string, value interface{}) {
+		if b.Len() > 1 {
+			b.WriteString(",")
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(value))
+		}
+		b.Write(k)
+		b.WriteString(":")
+		b.Write(v)
+	}
+	write("time", e.Time.Format(time.RFC3339Nano))
+	write("level", e.Level.String())
+	if e.Component != "" {
+		write("component", e.Component)
+	}
+	write("msg", e.Message)
+	for _, f := range e.Fields {
+		if err, ok := f.Value.(error); ok {
+			write(f.Key, err.Error())
+			continue
+		}
+		write(f.Key, f.Value)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+//console is where the status line is written, log entries written to it clear the status line first
+var console io.Writer = os.Stdout
+
+var (
+	mutex        sync.Mutex // protects following
+	output       io.Writer  = os.Stdout
+	jsonFormat   bool
+	levels       = Levels{Default: Info}
+	errorHandler func(e Entry)
+	status       string
+	atExit       []func()
+)
+
+//SetOutput sets the writer the entries are written to, the default is stdout
+func SetOutput(w io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	output = w
+}
+
+//SetJSON writes the entries as json objects, one per line, instead of text
+func SetJSON(enabled bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	jsonFormat = enabled
+}
+
+//SetLevels sets the minimum levels of the entries that are written
+func SetLevels(l Levels) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	levels = l
+}
+
+//SetErrorHandler sets a function that is called for every error entry, whether it is written or not.
+// It is called while the output is locked, it should not log itself.
+func SetErrorHandler(handler func(e Entry)) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	errorHandler = handler
+}
+
+//SetStatus writes a line on stdout that is rewritten with every update,
+// entries that are written to stdout clear it and write it again after the entry.
+func SetStatus(line string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	clearStatus()
+	status = line
+	io.WriteString(console, "\r"+status)
+}
+
+//clearStatus overwrites the status line with spaces
+// This function is not threadsafe
+func clearStatus() {
+	if status != "" {
+		io.WriteString(console, "\r"+strings.Repeat(" ", len(status))+"\r")
+	}
+}
+
+//write writes an entry if its level is not below the level of its component
+func write(e Entry) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if e.Level == Error && errorHandler != nil {
+		errorHandler(e)
+	}
+	if e.Level < levels.level(e.Component) {
+		return
+	}
+	line := e.text()
+	if jsonFormat {
+		line = e.json()
+	}
+	if output == console {
+		clearStatus()
+		defer io.WriteString(console, status)
+	}
+	output.Write(line)
+}
+
+//Logger creates the entries of a component
+type Logger struct {
+	component string
+	fields    []Field
+}
+
+//New creates a Logger for a component like "stratum" or "device 1"
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+//With returns a Logger that adds a field to all entries
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]Field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return &Logger{component: l.component, fields: append(fields, Field{Key: key, Value: value})}
+}
+
+//Enabled returns if the entries of a level are written, to avoid formatting costly messages that are not
+func (l *Logger) Enabled(level Level) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return level >= levels.level(l.component)
+}
+
+//Log writes an entry, the message is formatted like fmt.Sprintln without the newline
+func (l *Logger) Log(level Level, v ...interface{}) {
+	//Errors are passed to the error handler even if they are not written
+	if level < Error && !l.Enabled(level) {
+		return
+	}
+	message := fmt.Sprintln(v...)
+	write(Entry{Time: time.Now(), Level: level, Component: l.component, Message: message[:len(message)-1], Fields: l.fields})
+}
+
+//Debug writes a debug entry
+func (l *Logger) Debug(v ...interface{}) {
+	l.Log(Debug, v...)
+}
+
+//Info writes an info entry
+func (l *Logger) Info(v ...interface{}) {
+	l.Log(Info, v...)
+}
+
+//Warn writes a warning entry
+func (l *Logger) Warn(v ...interface{}) {
+	l.Log(Warn, v...)
+}
+
+//Error writes an error entry
+func (l *Logger) Error(v ...interface{}) {
+	l.Log(Error, v...)
+}
+
+//AtExit registers a function that is called before Fatal exits, like restoring the terminal
+func AtExit(f func()) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	atExit = append(atExit, f)
+}
+
+//Fatal writes an error entry, calls the functions registered with AtExit and exits
+func (l *Logger) Fatal(v ...interface{}) {
+	l.Log(Error, v...)
+	mutex.Lock()
+	functions := atExit
+	mutex.Unlock()
+	for _, f := range functions {
+		f()
+	}
+	os.Exit(1)
+}
+
+//stdLog passes the lines of the standard library logger to the entries without a component
+type stdLog struct{}
+
+//StdLog returns a writer for the standard library logger, use it with log.SetOutput and log.SetFlags(0).
+// The lines become info entries, use a Logger to write entries of other levels.
+func StdLog() io.Writer {
+	return stdLog{}
+}
+
+func (stdLog) Write(p []byte) (n int, err error) {
+	write(Entry{Time: time.Now(), Level: Info, Message: strings.TrimRight(string(p), "\n")})
+	return len(p), nil
+}
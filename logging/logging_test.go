@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels("warn, stratum=debug,device=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		component string
+		level     Level
+	}{
+		{"", Warn},
+		{"sia.miner", Warn},
+		{"stratum", Debug},
+		{"device 1", Error},
+	} {
+		if level := levels.level(test.component); level != test.level {
+			t.Error("Level", level, "for", test.component, "instead of", test.level)
+		}
+	}
+	if levels.String() != "warn,device=error,stratum=debug" {
+		t.Error("Unexpected string:", levels)
+	}
+	if levels, err = ParseLevels(""); err != nil || levels.Default != Info {
+		t.Error("Unexpected default level:", levels, err)
+	}
+	for _, spec := range []string{"verbose", "=debug", "stratum=loud"} {
+		if _, err = ParseLevels(spec); err == nil {
+			t.Error("No error for", spec)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var out, statusLine bytes.Buffer
+	var errorEntries []Entry
+	console = &statusLine
+	SetOutput(&out)
+	SetLevels(Levels{Default: Info, Components: map[string]Level{"stratum": Debug}})
+	SetErrorHandler(func(e Entry) { errorEntries = append(errorEntries, e) })
+	defer func() {
+		console = os.Stdout
+		SetOutput(os.Stdout)
+		SetLevels(Levels{Default: Info})
+		SetErrorHandler(nil)
+		SetJSON(false)
+	}()
+
+	device := New("device 1")
+	device.Debug("Not written")
+	device.Info("Mining", 2, "jobs")
+	stratum := New("stratum").With("pool", "pool:3333")
+	stratum.Debug("Received", "{}")
+	stratum.Error("Connection lost")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], " INFO  device 1 - Mining 2 jobs") ||
+		!strings.HasSuffix(lines[1], " DEBUG stratum - Received {} pool=pool:3333") || !strings.HasSuffix(lines[2], " ERROR stratum - Connection lost pool=pool:3333") {
+		t.Errorf("Unexpected log:\n%s", out.String())
+	}
+	if len(errorEntries) != 1 || errorEntries[0].Line() != "stratum - Connection lost pool=pool:3333" {
+		t.Error("Unexpected error entries:", errorEntries)
+	}
+	if !device.Enabled(Info) || device.Enabled(Debug) {
+		t.Error("Unexpected enabled levels")
+	}
+
+	out.Reset()
+	SetJSON(true)
+	stratum.With("err", errors.New("EOF")).Warn("Reconnecting")
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatal(err, out.String())
+	}
+	if entry["level"] != "warn" || entry["component"] != "stratum" || entry["msg"] != "Reconnecting" || entry["pool"] != "pool:3333" || entry["err"] != "EOF" || entry["time"] == nil {
+		t.Error("Unexpected json entry:", out.String())
+	}
+	SetJSON(false)
+
+	//Entries written to the console clear the status line and write it again
+	SetOutput(&statusLine)
+	SetStatus("Total: 1.0 MH/s")
+	device.Info("Found")
+	if s := statusLine.String(); !strings.HasPrefix(s, "\rTotal: 1.0 MH/s\r               \r") || !strings.HasSuffix(s, "INFO  device 1 - Found\nTotal: 1.0 MH/s") {
+		t.Errorf("Unexpected console output %q", s)
+	}
+	SetStatus("")
+
+	out.Reset()
+	SetOutput(&out)
+	//The lines of the standard library logger are info entries, whatever their text
+	logger := log.New(StdLog(), "", 0)
+	logger.Println("ERROR Unable to connect")
+	if !strings.HasSuffix(out.String(), " INFO  ERROR Unable to connect\n") || len(errorEntries) != 1 {
+		t.Errorf("Unexpected standard log entry %q", out.String())
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gominerlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "gominer.log")
+	f, err := OpenFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		if _, err = f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, expected := range map[string]string{"": "six\n", ".1": "four\nfive\n", ".2": "three\n"} {
+		if content, err := ioutil.ReadFile(path + file); err != nil || string(content) != expected {
+			t.Errorf("Unexpected content %q of %s - %v", content, path+file, err)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Too many rotated files are kept")
+	}
+}
@@ -1,120 +1,509 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/robvanmieghem/go-opencl/cl"
 	"github.com/robvanmieghem/gominer/algorithms/sia"
+	"github.com/robvanmieghem/gominer/api"
+	"github.com/robvanmieghem/gominer/clients"
+	"github.com/robvanmieghem/gominer/clients/stratum"
+	"github.com/robvanmieghem/gominer/dashboard"
+	"github.com/robvanmieghem/gominer/logging"
 	"github.com/robvanmieghem/gominer/mining"
+	"github.com/robvanmieghem/gominer/sharelog"
 )
 
+//poolStatsInterval is the time between the logs of the statistics when mining on multiple pools
+const poolStatsInterval = time.Minute
+
 //Version is the released version string of gominer
 var Version = "0.6.2-Dev"
 
-var intensity = 28
-var devicesTypesForMining = cl.DeviceTypeGPU
+//commands maps the subcommands to the functions implementing them, they get the remaining arguments
+var commands = map[string]func(args []string){
+	"proxy":   proxyCommand,
+	"server":  serverCommand,
+	"replay":  replayCommand,
+	"config":  configCommand,
+	"devices": devicesCommand,
+}
+
+var mainLog = logging.New("gominer")
 
 func main() {
-	log.SetOutput(os.Stdout)
-	printVersion := flag.Bool("v", false, "Show version and exit")
-	useCPU := flag.Bool("cpu", false, "If set, also use the CPU for mining, only GPU's are used by default")
-	flag.IntVar(&intensity, "I", intensity, "Intensity")
-	host := flag.String("url", "localhost:9980", "daemon or server host and port, for stratum servers, use `stratum+tcp://<host>:<port>`")
-	pooluser := flag.String("user", "payoutaddress.rigname", "username, most stratum servers take this in the form [payoutaddress].[rigname]")
-	excludedGPUs := flag.String("E", "", "Exclude GPU's: comma separated list of devicenumbers")
-	flag.Parse()
-
-	if *printVersion {
+	//The standard library logger is used by the commands and the libraries
+	log.SetFlags(0)
+	log.SetOutput(logging.StdLog())
+	if len(os.Args) > 1 {
+		if command, found := commands[os.Args[1]]; found {
+			command(os.Args[2:])
+			return
+		}
+	}
+	cfg, err := parseConfig(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		mainLog.Fatal(err)
+	}
+
+	if cfg.printVersion {
 		fmt.Println("gominer version", Version)
 		os.Exit(0)
 	}
 
-	if *useCPU {
-		devicesTypesForMining = cl.DeviceTypeAll
+	stats := mining.NewStats()
+	//The dashboard shows the log, it is started once the miner runs
+	var dash *dashboard.Dashboard
+	var logPane io.Writer
+	if cfg.Dashboard {
+		dash = dashboard.New(stats)
+		dash.Version = Version
+		logPane = dash
+		logging.AtExit(dash.Close)
 	}
-	globalItemSize := int(math.Exp2(float64(intensity)))
-
-	platforms, err := cl.GetPlatforms()
+	if err = configureLogging(cfg.Log, stats, logPane); err != nil {
+		mainLog.Fatal("Unable to open the log file -", err)
+	}
+	shareLog, err := openShareLog(cfg.ShareLog)
 	if err != nil {
-		log.Panic(err)
+		mainLog.Fatal("Unable to open the share log -", err)
 	}
+	logging.AtExit(func() { shareLog.Close() })
 
-	clDevices := make([]*cl.Device, 0, 4)
-	for _, platform := range platforms {
-		log.Println("Platform", platform.Name())
-		platormDevices, err := cl.GetDevices(platform, devicesTypesForMining)
-		if err != nil {
-			log.Println(err)
+	globalItemSize := int(math.Exp2(float64(cfg.Devices.Intensity)))
+
+	devices, err := enumerateDevices(cfg.deviceTypes())
+	if err != nil {
+		mainLog.Fatal(err)
+	}
+	platform := -1
+	for _, d := range devices {
+		if d.Platform != platform {
+			platform = d.Platform
+			mainLog.Info("Platform", d.PlatformName)
 		}
-		log.Println(len(platormDevices), "device(s) found:")
-		for i, device := range platormDevices {
-			log.Println(i, "-", device.Type(), "-", device.Name())
-			clDevices = append(clDevices, device)
+		if d.Index >= 0 {
+			mainLog.Info(d.Index, "-", d.ID(), "-", d.Type, "-", d.Name)
 		}
 	}
-
-	if len(clDevices) == 0 {
-		log.Println("No suitable opencl devices found")
-		os.Exit(1)
+	selectedDevices, err := selectDevices(devices, cfg.Devices.Select, cfg.Devices.Exclude)
+	if err != nil {
+		mainLog.Fatal(err)
+	}
+	if len(selectedDevices) == 0 {
+		mainLog.Fatal("No suitable opencl devices found")
 	}
-
-	//Filter the excluded devices
 	miningDevices := make(map[int]*cl.Device)
-	for i, device := range clDevices {
-		if deviceExcludedForMining(i, *excludedGPUs) {
-			continue
-		}
-		miningDevices[i] = device
+	for i, d := range selectedDevices {
+		miningDevices[i] = d.device
+		stats.AddDevice(i, d.Name)
 	}
 
 	nrOfMiningDevices := len(miningDevices)
 	var hashRateReportsChannel = make(chan *mining.HashRateReport, nrOfMiningDevices*10)
 
-	var miner mining.Miner
-	log.Println("Starting SIA mining")
-	c := sia.NewClient(*host, *pooluser)
+	mainLog.Info("Starting SIA mining")
+	c, err := newMinerClient(cfg)
+	if err != nil {
+		mainLog.Fatal(err)
+	}
 
-	miner = &sia.Miner{
+	miner := &sia.Miner{
 		ClDevices:       miningDevices,
 		HashRateReports: hashRateReportsChannel,
-		Intensity:       intensity,
+		Intensity:       cfg.Devices.Intensity,
 		GlobalItemSize:  globalItemSize,
 		Client:          c,
+		DeviceSettings:  cfg.deviceSettings(selectedDevices),
+		Stats:           stats,
+		ShareLog:        shareLog,
 	}
 	miner.Mine()
 
+	control := &minerControl{miner: miner, client: c, shareLog: shareLog, dashboard: dash}
+	if cfg.Thermal.enabled() {
+		startMonitor(cfg.Thermal, devices, selectedDevices, cfg.deviceCards(selectedDevices), stats, control)
+	}
+	if cfg.API.Listen != "" {
+		apiServer := api.NewServer(stats, c)
+		apiServer.Token = cfg.API.Token
+		apiServer.Version = Version
+		apiServer.Control = control
+		if err = apiServer.Listen(cfg.API.Listen); err != nil {
+			mainLog.Fatal("Unable to serve the api -", err)
+		}
+		mainLog.Info("Serving the api on", apiServer.Addr())
+	}
+	if cfg.CGMinerAPI.Listen != "" {
+		//The allow list is checked when the configuration is validated
+		allow, _ := api.ParseAllowList(cfg.CGMinerAPI.Allow)
+		cgminerServer := api.NewCGMinerServer(stats, c, allow)
+		cgminerServer.Version = Version
+		cgminerServer.Control = control
+		if err = cgminerServer.Listen(cfg.CGMinerAPI.Listen); err != nil {
+			mainLog.Fatal("Unable to serve the cgminer api -", err)
+		}
+		mainLog.Info("Serving the cgminer api on", cgminerServer.Addr())
+	}
+
+	if dash != nil {
+		dash.Client = c
+		dash.Control = control
+		go func() {
+			if err := dash.Run(os.Stdin, os.Stdout); err != nil {
+				mainLog.Fatal("The dashboard requires a terminal -", err)
+			}
+			fmt.Println(dashboard.Summary(stats.Summary()))
+			control.Shutdown()
+		}()
+	}
+
 	//Start printing out the hashrates of the different gpu's
-	hashRateReports := make([]float64, nrOfMiningDevices)
+	//The miner ids are the device numbers, they are not consecutive if devices are not selected
+	minerIDs := make([]int, 0, nrOfMiningDevices)
+	for minerID := range miningDevices {
+		minerIDs = append(minerIDs, minerID)
+	}
+	sort.Ints(minerIDs)
+	hashRateReports := make(map[int]float64, nrOfMiningDevices)
+	lastPoolStats := time.Now()
 	for {
 		//No need to print at every hashreport, we have time
 		for i := 0; i < nrOfMiningDevices; i++ {
 			report := <-hashRateReportsChannel
 			hashRateReports[report.MinerID] = report.HashRate
+			stats.ReportHashRate(report)
 		}
-		fmt.Print("\r")
+		var status strings.Builder
 		var totalHashRate float64
-		for minerID, hashrate := range hashRateReports {
-			fmt.Printf("%d-%.1f ", minerID, hashrate)
-			totalHashRate += hashrate
+		for _, minerID := range minerIDs {
+			fmt.Fprintf(&status, "%d-%.1f ", minerID, hashRateReports[minerID])
+			totalHashRate += hashRateReports[minerID]
+		}
+		fmt.Fprintf(&status, "Total: %.1f MH/s  ", totalHashRate)
+		if dash == nil {
+			logging.SetStatus(status.String())
+		}
+		if receiver, ok := c.(clients.HashRateReceiver); ok {
+			receiver.SetHashRate(totalHashRate)
+		}
+		if multiPoolClient, ok := c.(*clients.MultiPoolClient); ok && time.Since(lastPoolStats) > poolStatsInterval {
+			lastPoolStats = time.Now()
+			for _, stats := range multiPoolClient.Stats() {
+				mainLog.Info("Pool", stats.Name, "- weight", stats.Weight, "- work", stats.Work, "- accepted", stats.Accepted, "- rejected", stats.Rejected)
+			}
+		}
+
+	}
+}
+
+//minerStopTimeout is the time the devices get to finish their work when the miner is shut down or restarted
+const minerStopTimeout = 30 * time.Second
+
+//minerControl changes the miner while it is running, it is used by the control endpoints of the apis
+type minerControl struct {
+	miner  *sia.Miner
+	client clients.Client
+	//shareLog is closed once the shares of the devices are submitted
+	shareLog *sharelog.Log
+	//dashboard is closed before exiting or restarting if it is set
+	dashboard *dashboard.Dashboard
+	stopping  sync.Once
+}
+
+func (mc *minerControl) PauseDevice(minerID int) error {
+	return mc.miner.Pause(minerID)
+}
+
+func (mc *minerControl) ResumeDevice(minerID int) error {
+	return mc.miner.Resume(minerID)
+}
+
+func (mc *minerControl) SetIntensity(minerID, intensity int) error {
+	return mc.miner.SetIntensity(minerID, intensity)
+}
+
+func (mc *minerControl) SetDutyCycle(minerID, percent int) error {
+	return mc.miner.SetDutyCycle(minerID, percent)
+}
+
+func (mc *minerControl) SwitchPool(pool int) error {
+	switcher, ok := mc.client.(clients.PoolSwitcher)
+	if !ok {
+		return errors.New("Switching pools requires multiple pools")
+	}
+	return switcher.SwitchPool(pool)
+}
+
+func (mc *minerControl) SetUser(user string) error {
+	setter, ok := mc.client.(clients.UserSetter)
+	if !ok {
+		return errors.New("The user can not be changed for this pool")
+	}
+	return setter.SetUser(user)
+}
+
+func (mc *minerControl) Restart() error {
+	go mc.stop(true)
+	return nil
+}
+
+func (mc *minerControl) Shutdown() error {
+	go mc.stop(false)
+	return nil
+}
+
+//stop lets the devices finish their work and exits or restarts the process, only the first call has effect
+func (mc *minerControl) stop(restart bool) {
+	mc.stopping.Do(func() {
+		mainLog.Info("Stopping the miner")
+		stopped := make(chan struct{})
+		go func() {
+			mc.miner.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(minerStopTimeout):
+			mainLog.Error("The devices did not stop within", minerStopTimeout)
+		}
+		if err := mc.shareLog.Close(); err != nil {
+			mainLog.Error("Unable to close the share log -", err)
+		}
+		if mc.dashboard != nil {
+			mc.dashboard.Close()
+		}
+		if restart {
+			mainLog.Info("Restarting")
+			if err := restartProcess(); err != nil {
+				mainLog.Fatal("Unable to restart -", err)
+			}
+		}
+		os.Exit(0)
+	})
+}
+
+//openShareLog opens the share log if a file is configured, the log is nil otherwise
+func openShareLog(cfg ShareLogConfig) (shareLog *sharelog.Log, err error) {
+	if cfg.File == "" {
+		return
+	}
+	//The format is checked when the configuration is validated
+	format, _ := sharelog.ParseFormat(cfg.Format, cfg.File)
+	return sharelog.Open(cfg.File, format, int64(cfg.MaxSize)<<20, cfg.Daily, time.Duration(cfg.Sync))
+}
+
+//configureLogging sets the levels, format and file of the log and reports the errors to stats,
+// the log is also written to pane if it is not nil
+func configureLogging(cfg LogConfig, stats *mining.Stats, pane io.Writer) (err error) {
+	//The levels are checked when the configuration is validated
+	levels, _ := logging.ParseLevels(cfg.Level)
+	logging.SetLevels(levels)
+	logging.SetJSON(cfg.Format == "json")
+	logging.SetErrorHandler(func(e logging.Entry) { stats.ReportError(e.Line()) })
+	var output io.Writer = os.Stdout
+	if pane != nil {
+		output = pane
+	}
+	if cfg.File != "" {
+		file, err := logging.OpenFile(cfg.File, int64(cfg.MaxSize)<<20, cfg.MaxFiles)
+		if err != nil {
+			return err
+		}
+		output = file
+		if pane != nil {
+			output = io.MultiWriter(file, pane)
+		}
+	}
+	logging.SetOutput(output)
+	return
+}
+
+//clientOptions are the command line options that apply to the clients
+type clientOptions struct {
+	apiPassword         string
+	caFile              string
+	suggestedDifficulty float64
+	suggestTarget       bool
+	sharesPerMinute     float64
+	maxNTimeRoll        time.Duration
+	recordFile          string
+	//password is the password of the pool, for siad it replaces apiPassword
+	password string
+}
+
+//newMinerClient creates the client for the configured pools
+func newMinerClient(cfg *Config) (c clients.Client, err error) {
+	options := cfg.clientOptions()
+	switch {
+	case len(cfg.Pools) == 0:
+		c, err = newClient(cfg.URL, cfg.User, options)
+	case len(cfg.Pools) == 1 && cfg.Switch.Source == "":
+		p := cfg.Pools[0]
+		if p.User == "" {
+			p.User = cfg.User
+		}
+		options.password = p.Password
+		c, err = newClient(p.URL, p.User, options)
+	case cfg.Switch.Source == "":
+		c, err = newMultiPoolClient(cfg.Pools, cfg.User, options)
+	default:
+		var switchingClient *clients.SwitchingClient
+		if switchingClient, err = newSwitchingClient(cfg.Pools, cfg.User, options, cfg.Switch.Source); err == nil {
+			switchingClient.Interval = time.Duration(cfg.Switch.Interval)
+			switchingClient.MinDwell = time.Duration(cfg.Switch.Dwell)
+			switchingClient.Hysteresis = cfg.Switch.Hysteresis
+			c = switchingClient
+		}
+	}
+	return
+}
+
+//newClient creates and configures the client for a url
+func newClient(url, user string, options clientOptions) (c clients.Client, err error) {
+	c = sia.NewClient(url, user)
+	switch client := c.(type) {
+	case nil:
+		err = fmt.Errorf("Invalid url %s", url)
+	case *sia.SiadClient:
+		apiPassword := options.apiPassword
+		if options.password != "" {
+			apiPassword = options.password
 		}
-		fmt.Printf("Total: %.1f MH/s  ", totalHashRate)
+		err = configureSiadClient(client, apiPassword, options.caFile)
+	case *sia.GetworkClient:
+		if options.password != "" {
+			client.Password = options.password
+		}
+		client.HTTPClient, err = sia.NewHTTPClient(options.caFile)
+	case *sia.StratumClient:
+		client.Password = options.password
+		client.SuggestedDifficulty = options.suggestedDifficulty
+		client.SuggestTarget = options.suggestTarget
+		client.MaxNTimeRoll = options.maxNTimeRoll
+		client.SharesPerMinute = options.sharesPerMinute
+		if options.recordFile != "" {
+			if client.Recorder, err = stratum.CreateRecorder(options.recordFile); err != nil {
+				err = fmt.Errorf("Unable to record the stratum session - %s", err)
+			}
+		}
+	}
+	return
+}
+
+//poolList collects the repeated -pool flags, the first one replaces the pools that are already configured
+type poolList struct {
+	pools *[]PoolConfig
+	set   bool
+}
+
+func (pl *poolList) String() string {
+	if pl.pools == nil {
+		return ""
+	}
+	return fmt.Sprint(*pl.pools)
+}
+
+//Set parses a pool in the form <weight>,<url>[,<user>]
+func (pl *poolList) Set(value string) (err error) {
+	parts := strings.Split(value, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return errors.New("a pool should be in the form <weight>,<url>[,<user>]")
+	}
+	p := PoolConfig{URL: parts[1]}
+	if p.Weight, err = strconv.ParseFloat(parts[0], 64); err != nil || p.Weight <= 0 {
+		return errors.New("the weight of a pool should be a positive number")
+	}
+	if len(parts) == 3 {
+		p.User = parts[2]
+	}
+	if !pl.set {
+		*pl.pools = nil
+		pl.set = true
+	}
+	*pl.pools = append(*pl.pools, p)
+	return
+}
 
+//newMultiPoolClient creates a client dividing the work over the pools
+func newMultiPoolClient(pools []PoolConfig, defaultUser string, options clientOptions) (c clients.Client, err error) {
+	upstreams, err := newPools(pools, defaultUser, options)
+	if err != nil {
+		return
+	}
+	for i := range upstreams {
+		upstreams[i].Name = fmt.Sprintf("%d (%s)", i, upstreams[i].Name)
+	}
+	return clients.NewMultiPoolClient(upstreams)
+}
+
+//newSwitchingClient creates a client mining on the pool with the highest score from the source
+// The source is either "static", "file:<path>" or an http(s) url.
+func newSwitchingClient(pools []PoolConfig, defaultUser string, options clientOptions, source string) (sc *clients.SwitchingClient, err error) {
+	upstreams, err := newPools(pools, defaultUser, options)
+	if err != nil {
+		return
 	}
+	var scoreSource clients.ScoreSource
+	switch {
+	case source == "static":
+		scores := make(clients.StaticScores)
+		for _, p := range upstreams {
+			scores[p.Name] = p.Weight
+		}
+		scoreSource = scores
+	case strings.HasPrefix(source, "file:"):
+		scoreSource = clients.FileScores{Path: strings.TrimPrefix(source, "file:")}
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		scoreSource = clients.HTTPScores{URL: source}
+	default:
+		err = fmt.Errorf("Unsupported score source %s, use static, file:<path> or an http(s) url", source)
+		return
+	}
+	return clients.NewSwitchingClient(upstreams, scoreSource)
 }
 
-//deviceExcludedForMining checks if the device is in the exclusion list
-func deviceExcludedForMining(deviceID int, excludedGPUs string) bool {
-	excludedGPUList := strings.Split(excludedGPUs, ",")
-	for _, excludedGPU := range excludedGPUList {
-		if strconv.Itoa(deviceID) == excludedGPU {
-			return true
+//newPools creates the clients for the pools, named by their url, pools without a user use defaultUser
+// When recording, every pool is recorded in its own file, suffixed with the number of the pool.
+func newPools(pools []PoolConfig, defaultUser string, options clientOptions) (upstreams []clients.Pool, err error) {
+	upstreams = make([]clients.Pool, len(pools))
+	recordFile := options.recordFile
+	for i, p := range pools {
+		user := p.User
+		if user == "" {
+			user = defaultUser
+		}
+		if recordFile != "" {
+			options.recordFile = fmt.Sprintf("%s.%d", recordFile, i)
+		}
+		options.password = p.Password
+		upstreams[i] = clients.Pool{Name: p.URL, Weight: p.Weight, Priority: p.Priority}
+		if upstreams[i].Client, err = newClient(p.URL, user, options); err != nil {
+			return
 		}
 	}
-	return false
+	return
+}
+
+//configureSiadClient sets the API password and the trusted certificate authorities of a siad client
+func configureSiadClient(siadClient *sia.SiadClient, apiPassword, caFile string) (err error) {
+	if siadClient.APIPassword, err = sia.LoadAPIPassword(apiPassword); err != nil {
+		return
+	}
+	siadClient.HTTPClient, err = sia.NewHTTPClient(caFile)
+	return
 }
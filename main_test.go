@@ -2,41 +2,21 @@ package main
 
 import "testing"
 
-func TestExcludedDevices(t *testing.T) {
-	testSet := []struct {
-		deviceID     int
-		excludedGPUs string
-		excluded     bool
-	}{{
-		deviceID:     1,
-		excludedGPUs: "",
-		excluded:     false,
-	},
-		{
-			deviceID:     2,
-			excludedGPUs: "2",
-			excluded:     true,
-		},
-		{
-			deviceID:     2,
-			excludedGPUs: "3,2",
-			excluded:     true,
-		},
-		{
-			deviceID:     1,
-			excludedGPUs: "2,3",
-			excluded:     false,
-		},
-		{
-			deviceID:     1,
-			excludedGPUs: "0",
-			excluded:     false,
-		},
+func TestPoolList(t *testing.T) {
+	pools := []PoolConfig{{URL: "stratum+tcp://configured:3333"}}
+	pl := &poolList{pools: &pools}
+	for _, value := range []string{"80,stratum+tcp://pool1:3333", "20,stratum+tcp://pool2:3333,address.rig"} {
+		if err := pl.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []PoolConfig{{URL: "stratum+tcp://pool1:3333", Weight: 80}, {URL: "stratum+tcp://pool2:3333", User: "address.rig", Weight: 20}}
+	if len(pools) != len(expected) || pools[0] != expected[0] || pools[1] != expected[1] {
+		t.Error(pools, "parsed instead of", expected)
 	}
-	for _, test := range testSet {
-		result := deviceExcludedForMining(test.deviceID, test.excludedGPUs)
-		if result != test.excluded {
-			t.Error(test)
+	for _, invalid := range []string{"stratum+tcp://pool1:3333", "0,stratum+tcp://pool1:3333", "a,stratum+tcp://pool1:3333", "1,url,user,extra"} {
+		if err := pl.Set(invalid); err == nil {
+			t.Error("No error for", invalid)
 		}
 	}
 }
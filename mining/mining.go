@@ -2,6 +2,7 @@ package mining
 
 import (
 	"log"
+	"time"
 
 	"github.com/robvanmieghem/go-opencl/cl"
 )
@@ -10,6 +11,8 @@ import (
 type HashRateReport struct {
 	MinerID  int
 	HashRate float64
+	//KernelDuration is the average time a kernel run took
+	KernelDuration time.Duration
 }
 
 //CreateEmptyBuffer calls CreateEmptyBuffer on the supplied context and logs and panics if an error occurred
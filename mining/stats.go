@@ -0,0 +1,326 @@
+package mining
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+//MaxRecentErrors is the number of errors a Stats keeps
+const MaxRecentErrors = 50
+
+//KernelDurationBuckets are the upper bounds in seconds of the buckets of the kernel duration histograms
+var KernelDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+//ShareResult is the outcome of a share found by a device
+type ShareResult int
+
+const (
+	//ShareAccepted is a share accepted by the pool
+	ShareAccepted ShareResult = iota
+	//ShareRejected is a share rejected by the pool for another reason than being stale
+	ShareRejected
+	//ShareStale is a share rejected by the pool since its job is no longer valid
+	ShareStale
+	//ShareHardwareError is a solution returned by the device that does not meet the target, it is not submitted
+	ShareHardwareError
+)
+
+var shareResultNames = []string{"accepted", "rejected", "stale", "hardware_error"}
+
+func (r ShareResult) String() string {
+	if r < 0 || int(r) >= len(shareResultNames) {
+		return "unknown"
+	}
+	return shareResultNames[r]
+}
+
+//ShareCount is the number of shares of a device for a pool with the same result
+type ShareCount struct {
+	MinerID int
+	Pool    string
+	Result  ShareResult
+	Count   uint64
+}
+
+//Histogram counts observations in the KernelDurationBuckets
+type Histogram struct {
+	//Buckets are the number of observations per bucket, the observations larger than the last bound are only in Count
+	Buckets []uint64
+	Count   uint64
+	Sum     float64
+}
+
+//observe adds a value to the histogram
+func (h *Histogram) observe(value float64) {
+	if h.Buckets == nil {
+		h.Buckets = make([]uint64, len(KernelDurationBuckets))
+	}
+	for i, bound := range KernelDurationBuckets {
+		if value <= bound {
+			h.Buckets[i]++
+			break
+		}
+	}
+	h.Count++
+	h.Sum += value
+}
+
+//DeviceStats are the statistics of a single mining device
+type DeviceStats struct {
+	MinerID int    `json:"id"`
+	Name    string `json:"name"`
+	//HashRate is the last reported hashrate in MH/s
+	HashRate float64 `json:"hashrate"`
+	//AverageHashRate is the average of the hashrates reported while the device was mining, in MH/s
+	AverageHashRate float64 `json:"averagehashrate"`
+	Accepted        uint64  `json:"accepted"`
+	Rejected        uint64  `json:"rejected"`
+	Stale           uint64  `json:"stale"`
+	HardwareErrors  uint64  `json:"hardwareerrors"`
+	//BestDifficulty is the highest difficulty of the shares found by the device
+	BestDifficulty float64   `json:"bestdifficulty"`
+	LastShare      time.Time `json:"lastshare"`
+	//KernelDuration is the histogram of the time a kernel run takes in seconds
+	KernelDuration Histogram `json:"-"`
+	Intensity      int       `json:"intensity"`
+	Paused         bool      `json:"paused"`
+	//DutyCycle is the percentage of the time the device mines, it is lowered to throttle a hot device
+	DutyCycle int `json:"dutycycle"`
+	//Sensors is the last reading of the temperature, fan and power of the device, nil if they are not monitored
+	Sensors *SensorReading `json:"sensors,omitempty"`
+
+	//hashRateReports is the number of reports the AverageHashRate is calculated from
+	hashRateReports uint64
+}
+
+//SensorReading is a reading of the sensors of a device, the values the device does not report are 0
+type SensorReading struct {
+	Time time.Time `json:"time"`
+	//Temperature is the temperature of the GPU in degrees Celsius
+	Temperature float64 `json:"temperature"`
+	//FanSpeed is the speed of the fan in percent of its maximum, FanRPM the revolutions per minute
+	FanSpeed float64 `json:"fanspeed"`
+	FanRPM   int     `json:"fanrpm"`
+	//Power is the average power draw in Watt
+	Power float64 `json:"power"`
+}
+
+//Summary are the statistics of all devices together
+type Summary struct {
+	Started time.Time `json:"started"`
+	//Uptime is the number of seconds since the start
+	Uptime   int64   `json:"uptime"`
+	HashRate float64 `json:"hashrate"`
+	//AverageHashRate is the sum of the average hashrates of the devices
+	AverageHashRate float64 `json:"averagehashrate"`
+	Accepted        uint64  `json:"accepted"`
+	Rejected        uint64  `json:"rejected"`
+	Stale           uint64  `json:"stale"`
+	HardwareErrors  uint64  `json:"hardwareerrors"`
+	Devices         int     `json:"devices"`
+}
+
+//ErrorReport is an error that occurred while mining
+type ErrorReport struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+//Stats collects the statistics of the miners, it is safe for concurrent use and the methods can be called on a nil Stats
+type Stats struct {
+	mutex   sync.Mutex // protects following
+	started time.Time
+	devices map[int]*DeviceStats
+	shares  map[ShareCount]uint64
+	errors  []ErrorReport
+}
+
+//NewStats creates a Stats, the uptime is counted from now
+func NewStats() *Stats {
+	return &Stats{started: time.Now(), devices: make(map[int]*DeviceStats), shares: make(map[ShareCount]uint64)}
+}
+
+//device returns the statistics of a device, creating them if needed
+// This method is not threadsafe
+func (s *Stats) device(minerID int) *DeviceStats {
+	d, found := s.devices[minerID]
+	if !found {
+		d = &DeviceStats{MinerID: minerID, DutyCycle: 100}
+		s.devices[minerID] = d
+	}
+	return d
+}
+
+//AddDevice registers a device so it is listed before it reports anything
+func (s *Stats) AddDevice(minerID int, name string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.device(minerID).Name = name
+}
+
+//ReportState records the intensity of a device and if it is paused
+func (s *Stats) ReportState(minerID int, intensity int, paused bool) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d := s.device(minerID)
+	d.Intensity = intensity
+	d.Paused = paused
+}
+
+//ReportDutyCycle records the percentage of the time a device mines
+func (s *Stats) ReportDutyCycle(minerID int, dutyCycle int) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.device(minerID).DutyCycle = dutyCycle
+}
+
+//ReportSensors records the last reading of the sensors of a device
+func (s *Stats) ReportSensors(minerID int, reading SensorReading) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	//The reading is never changed once it is reported, so the copies of the device stats can share it
+	s.device(minerID).Sensors = &reading
+}
+
+//ReportHashRate records the hashrate and the kernel duration of a device
+func (s *Stats) ReportHashRate(report *HashRateReport) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d := s.device(report.MinerID)
+	d.HashRate = report.HashRate
+	//Paused devices report a hashrate of 0
+	if report.HashRate > 0 {
+		d.hashRateReports++
+		d.AverageHashRate += (report.HashRate - d.AverageHashRate) / float64(d.hashRateReports)
+	}
+	if report.KernelDuration > 0 {
+		d.KernelDuration.observe(report.KernelDuration.Seconds())
+	}
+}
+
+//ReportShare records the result of a share found by a device for a pool
+func (s *Stats) ReportShare(minerID int, pool string, difficulty float64, result ShareResult) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shares[ShareCount{MinerID: minerID, Pool: pool, Result: result}]++
+	d := s.device(minerID)
+	switch result {
+	case ShareHardwareError:
+		d.HardwareErrors++
+		return
+	case ShareAccepted:
+		d.Accepted++
+	case ShareStale:
+		d.Stale++
+	default:
+		d.Rejected++
+	}
+	d.LastShare = time.Now()
+	if difficulty > d.BestDifficulty {
+		d.BestDifficulty = difficulty
+	}
+}
+
+//Shares returns the number of shares per device, pool and result, ordered by device
+func (s *Stats) Shares() (shares []ShareCount) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, count := range s.shares {
+		key.Count = count
+		shares = append(shares, key)
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].MinerID != shares[j].MinerID {
+			return shares[i].MinerID < shares[j].MinerID
+		}
+		if shares[i].Pool != shares[j].Pool {
+			return shares[i].Pool < shares[j].Pool
+		}
+		return shares[i].Result < shares[j].Result
+	})
+	return
+}
+
+//ReportError records an error, only the last MaxRecentErrors are kept
+func (s *Stats) ReportError(message string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.errors = append(s.errors, ErrorReport{Time: time.Now(), Message: message})
+	if len(s.errors) > MaxRecentErrors {
+		s.errors = append([]ErrorReport(nil), s.errors[len(s.errors)-MaxRecentErrors:]...)
+	}
+}
+
+//Summary returns the totals of all devices
+func (s *Stats) Summary() (summary Summary) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	summary.Started = s.started
+	summary.Uptime = int64(time.Since(s.started).Seconds())
+	summary.Devices = len(s.devices)
+	for _, d := range s.devices {
+		summary.HashRate += d.HashRate
+		summary.AverageHashRate += d.AverageHashRate
+		summary.Accepted += d.Accepted
+		summary.Rejected += d.Rejected
+		summary.Stale += d.Stale
+		summary.HardwareErrors += d.HardwareErrors
+	}
+	return
+}
+
+//Devices returns the statistics of the devices, ordered by their id
+func (s *Stats) Devices() (devices []DeviceStats) {
+	devices = []DeviceStats{}
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, d := range s.devices {
+		device := *d
+		device.KernelDuration.Buckets = append([]uint64(nil), d.KernelDuration.Buckets...)
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].MinerID < devices[j].MinerID })
+	return
+}
+
+//Errors returns the recent errors, the oldest first
+func (s *Stats) Errors() (errors []ErrorReport) {
+	errors = []ErrorReport{}
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append(errors, s.errors...)
+}
@@ -0,0 +1,65 @@
+package mining
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	s := NewStats()
+	s.AddDevice(1, "Fiji")
+	s.ReportHashRate(&HashRateReport{MinerID: 0, HashRate: 100})
+	s.ReportHashRate(&HashRateReport{MinerID: 1, HashRate: 50, KernelDuration: 20 * time.Millisecond})
+	s.ReportHashRate(&HashRateReport{MinerID: 0, HashRate: 200})
+	//A paused device does not lower the average
+	s.ReportHashRate(&HashRateReport{MinerID: 0})
+	s.ReportHashRate(&HashRateReport{MinerID: 0, HashRate: 150})
+	s.ReportShare(0, "pool", 5, ShareAccepted)
+	s.ReportShare(0, "pool", 3, ShareRejected)
+	s.ReportShare(0, "pool", 7, ShareStale)
+	s.ReportShare(1, "pool", 0, ShareHardwareError)
+	devices := s.Devices()
+	if len(devices) != 2 || devices[0].MinerID != 0 || devices[1].Name != "Fiji" {
+		t.Fatal("Unexpected devices:", devices)
+	}
+	if devices[0].Accepted != 1 || devices[0].Rejected != 1 || devices[0].Stale != 1 || devices[0].BestDifficulty != 7 || devices[0].LastShare.IsZero() {
+		t.Error("Unexpected device stats:", devices[0])
+	}
+	if d := devices[1]; d.HardwareErrors != 1 || !d.LastShare.IsZero() || d.KernelDuration.Count != 1 || d.KernelDuration.Buckets[2] != 1 {
+		t.Error("Unexpected device stats:", d)
+	}
+	if devices[0].DutyCycle != 100 || devices[0].Sensors != nil {
+		t.Error("Unexpected duty cycle or sensors:", devices[0].DutyCycle, devices[0].Sensors)
+	}
+	if devices[0].AverageHashRate != 150 || devices[1].AverageHashRate != 50 {
+		t.Error("Unexpected average hashrates:", devices[0].AverageHashRate, devices[1].AverageHashRate)
+	}
+	if summary := s.Summary(); summary.HashRate != 200 || summary.AverageHashRate != 200 || summary.Accepted != 1 || summary.Rejected != 1 || summary.Stale != 1 || summary.HardwareErrors != 1 || summary.Devices != 2 {
+		t.Error("Unexpected summary:", summary)
+	}
+	s.ReportDutyCycle(1, 60)
+	s.ReportSensors(1, SensorReading{Temperature: 71, FanSpeed: 40, Power: 120})
+	if d := s.Devices()[1]; d.DutyCycle != 60 || d.Sensors == nil || d.Sensors.Temperature != 71 || d.Sensors.Power != 120 {
+		t.Error("Unexpected duty cycle or sensors:", d.DutyCycle, d.Sensors)
+	}
+	shares := s.Shares()
+	if len(shares) != 4 || shares[0].MinerID != 0 || shares[0].Result != ShareAccepted || shares[3].Result != ShareHardwareError || shares[3].Count != 1 {
+		t.Error("Unexpected shares:", shares)
+	}
+
+	for i := 0; i < MaxRecentErrors+5; i++ {
+		s.ReportError(fmt.Sprint("error ", i))
+	}
+	errs := s.Errors()
+	if len(errs) != MaxRecentErrors || errs[0].Message != "error 5" {
+		t.Error("Unexpected recent errors:", len(errs), errs[0])
+	}
+
+	//A nil Stats ignores the reports
+	var nilStats *Stats
+	nilStats.ReportShare(0, "pool", 1, ShareAccepted)
+	if len(nilStats.Devices()) != 0 {
+		t.Error("Devices reported by a nil Stats")
+	}
+}
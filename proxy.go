@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/robvanmieghem/gominer/algorithms/sia"
+	"github.com/robvanmieghem/gominer/clients/stratum"
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+var proxyLog = logging.New("proxy")
+
+//proxyCommand accepts stratum connections from downstream miners and forwards the shares over a single upstream pool connection
+func proxyCommand(args []string) {
+	flags := flag.NewFlagSet("proxy", flag.ExitOnError)
+	listen := flags.String("listen", ":3333", "address to accept stratum connections from downstream miners on")
+	host := flags.String("url", "stratum+tcp://localhost:3333", "upstream stratum server, use `stratum+tcp://<host>:<port>`")
+	pooluser := flags.String("user", "payoutaddress.rigname", "username, most stratum servers take this in the form [payoutaddress].[rigname]")
+	prefixSize := flags.Uint("prefix", sia.DefaultProxyPrefixSize, "number of upstream extranonce2 bytes used to distinguish the downstream miners, from 1 to 7")
+	recordFile := flags.String("record", "", "append the lines exchanged with the upstream pool to this file")
+	flags.Parse(args)
+
+	proxy, err := sia.NewStratumProxy(*host, *pooluser)
+	if err != nil {
+		proxyLog.Fatal(err)
+	}
+	proxy.PrefixSize = *prefixSize
+	if *recordFile != "" {
+		if proxy.Upstream.Recorder, err = stratum.CreateRecorder(*recordFile); err != nil {
+			proxyLog.Fatal("Unable to record the upstream session -", err)
+		}
+	}
+	if err = proxy.Listen(*listen); err != nil {
+		proxyLog.Fatal(err)
+	}
+	select {}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/robvanmieghem/gominer/algorithms/sia"
+	"github.com/robvanmieghem/gominer/clients/stratum"
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+var replayLog = logging.New("replay")
+
+//replayCommand replays a stratum session recorded with the -record flag, either offline or by serving it to a miner
+func replayCommand(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	listen := flags.String("listen", "", "serve the recorded sessions to a miner connecting on this address instead of replaying them offline")
+	realtime := flags.Bool("realtime", false, "wait between the recorded lines as long as during the recording")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gominer replay [flags] <recording>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	frames, err := stratum.ReadFramesFromFile(flags.Arg(0))
+	if err != nil {
+		replayLog.Fatal("Unable to read the recording -", err)
+	}
+
+	if *listen == "" {
+		if err = sia.ReplayStratumSessions(frames, *realtime, os.Stdout); err != nil {
+			replayLog.Fatal(err)
+		}
+		return
+	}
+
+	server := stratum.NewReplayServer(frames)
+	server.Realtime = *realtime
+	if err = server.Listen(*listen); err != nil {
+		replayLog.Fatal(err)
+	}
+	replayLog.Info("Serving", server.Sessions(), "recorded sessions on", server.Addr())
+	<-server.Done()
+}
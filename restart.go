@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+//restartProcess replaces the process by a new one with the same arguments, it only returns if that fails
+func restartProcess() (err error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return
+	}
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+//restartProcess starts a new process with the same arguments, the caller exits if it succeeds
+func restartProcess() (err error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Start()
+}
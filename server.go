@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/robvanmieghem/gominer/algorithms/sia"
+	"github.com/robvanmieghem/gominer/logging"
+)
+
+var serverLog = logging.New("server")
+
+//serverCommand serves the work of a siad to mining rigs over stratum for solo mining
+func serverCommand(args []string) {
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	listen := flags.String("listen", ":3333", "address to accept stratum connections from rigs on")
+	host := flags.String("url", "localhost:9980", "siad host and port, use `https://<host>:<port>` for siad behind TLS")
+	jobInterval := flags.Duration("interval", sia.DefaultJobInterval, "time between new jobs sent to a rig")
+	startDifficulty := flags.Float64("diff", sia.DefaultStartDifficulty, "share difficulty a rig starts with")
+	sharesPerMinute := flags.Float64("shares", sia.DefaultSharesPerMinute, "number of shares per minute per rig the variable difficulty aims for")
+	apiPassword := flags.String("apipassword", "", "siad API password, defaults to the SIA_API_PASSWORD environment variable or the apipassword file in the sia directory")
+	caFile := flags.String("cafile", "", "PEM file with the certificate authorities to trust when connecting to siad over https")
+	flags.Parse(args)
+
+	server := sia.NewSoloServer(*host)
+	if err := configureSiadClient(server.Siad, *apiPassword, *caFile); err != nil {
+		serverLog.Fatal(err)
+	}
+	server.JobInterval = *jobInterval
+	server.StartDifficulty = *startDifficulty
+	server.SharesPerMinute = *sharesPerMinute
+	if err := server.Listen(*listen); err != nil {
+		serverLog.Fatal(err)
+	}
+	select {}
+}
@@ -0,0 +1,294 @@
+//Package sharelog keeps an append-only record of every solution found by the devices and the answer of the pool,
+// one JSON object or CSV row per line, to settle disputes with pools.
+package sharelog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DefaultSyncInterval is the default time between two fsyncs of the share log
+const DefaultSyncInterval = time.Second
+
+//Format is the format of the lines of the share log
+type Format int
+
+const (
+	//JSONL writes every share as a JSON object on a single line
+	JSONL Format = iota
+	//CSV writes every share as a row of comma separated values, the file starts with a header row
+	CSV
+)
+
+//ParseFormat parses jsonl or csv, if format is empty the extension of path decides
+func ParseFormat(format, path string) (f Format, err error) {
+	if format == "" && strings.EqualFold(filepath.Ext(path), ".csv") {
+		format = "csv"
+	}
+	switch format {
+	case "", "jsonl":
+		f = JSONL
+	case "csv":
+		f = CSV
+	default:
+		err = fmt.Errorf("Invalid share log format %s, use jsonl or csv", format)
+	}
+	return
+}
+
+//Share is a solution found by a device and the outcome of submitting it
+type Share struct {
+	//Time is the time the solution was found
+	Time   time.Time `json:"time"`
+	Device int       `json:"device"`
+	Pool   string    `json:"pool"`
+	//JobID and ExtraNonce2 are the stratum job and extranonce2 the header was constructed from, they are empty for other pools
+	JobID       string `json:"jobid"`
+	ExtraNonce2 string `json:"extranonce2"`
+	//NTime and Nonce are hex encoded as submitted to a stratum server
+	NTime string `json:"ntime"`
+	Nonce string `json:"nonce"`
+	//Header is the hex encoded solved header and Hash its hex encoded id
+	Header     string  `json:"header"`
+	Hash       string  `json:"hash"`
+	Difficulty float64 `json:"difficulty"`
+	//Result is accepted, rejected, stale or hardware_error and Response the error returned by the pool
+	Result   string `json:"result"`
+	Response string `json:"response"`
+	//Latency is the time the pool took to answer, in nanoseconds
+	Latency time.Duration `json:"latency"`
+}
+
+//csvHeader are the columns of a CSV share log
+var csvHeader = []string{"time", "device", "pool", "jobid", "extranonce2", "ntime", "nonce", "header", "hash", "difficulty", "result", "response", "latency"}
+
+//record returns the CSV columns of the share
+func (s Share) record() []string {
+	return []string{
+		s.Time.Format(time.RFC3339Nano),
+		strconv.Itoa(s.Device),
+		s.Pool,
+		s.JobID,
+		s.ExtraNonce2,
+		s.NTime,
+		s.Nonce,
+		s.Header,
+		s.Hash,
+		strconv.FormatFloat(s.Difficulty, 'g', -1, 64),
+		s.Result,
+		s.Response,
+		strconv.FormatInt(int64(s.Latency), 10),
+	}
+}
+
+//Log is an append-only share log. It is rotated when it reaches MaxSize bytes or, if Daily is set, when the day changes,
+// the rotated files are kept with the time or day of the rotation in their name. The methods can be called on a nil Log.
+type Log struct {
+	Path    string
+	Format  Format
+	MaxSize int64
+	Daily   bool
+
+	mutex sync.Mutex // protects following
+	file  *os.File
+	size  int64
+	//day is the day the shares in the file were found on, in the local time zone
+	day string
+	//dirty is set when shares are written since the last fsync
+	dirty bool
+	stop  chan struct{}
+}
+
+//dayFormat is the format of the days the Log is rotated on
+const dayFormat = "2006-01-02"
+
+//Open opens or creates a share log and fsyncs it every syncInterval, 0 syncs after every share.
+// A MaxSize of 0 disables the rotation by size.
+func Open(path string, format Format, maxSize int64, daily bool, syncInterval time.Duration) (l *Log, err error) {
+	l = &Log{Path: path, Format: format, MaxSize: maxSize, Daily: daily}
+	if err = l.open(); err != nil {
+		return nil, err
+	}
+	if syncInterval > 0 {
+		l.stop = make(chan struct{})
+		go l.syncEvery(syncInterval)
+	}
+	return
+}
+
+//open opens the file at Path for appending, a new CSV file starts with the header row
+// This method is not threadsafe
+func (l *Log) open() (err error) {
+	file, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+	l.file = file
+	l.size = info.Size()
+	l.day = ""
+	if l.size > 0 {
+		l.day = info.ModTime().Format(dayFormat)
+	} else if l.Format == CSV {
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		w.Write(csvHeader)
+		w.Flush()
+		err = l.write(b.Bytes())
+	}
+	return
+}
+
+//write appends a line to the file
+// This method is not threadsafe
+func (l *Log) write(line []byte) (err error) {
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	l.dirty = true
+	return
+}
+
+//Record appends a share to the log, the file is rotated first if needed
+func (l *Log) Record(s Share) (err error) {
+	if l == nil {
+		return
+	}
+	var line []byte
+	if l.Format == CSV {
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		w.Write(s.record())
+		w.Flush()
+		line = b.Bytes()
+	} else {
+		if line, err = json.Marshal(s); err != nil {
+			return
+		}
+		line = append(line, '\n')
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return errors.New("The share log is closed")
+	}
+	day := s.Time.Local().Format(dayFormat)
+	switch {
+	case l.Daily && l.day != "" && l.day != day:
+		err = l.rotate(l.day)
+	case l.MaxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.MaxSize:
+		err = l.rotate(s.Time.Local().Format("2006-01-02T150405"))
+	}
+	if err != nil {
+		return
+	}
+	if l.day == "" {
+		l.day = day
+	}
+	if err = l.write(line); err != nil {
+		return
+	}
+	if l.stop == nil {
+		err = l.sync()
+	}
+	return
+}
+
+//rotate renames the current file by inserting suffix before its extension and starts a new file,
+// an existing file with the same name is never overwritten
+// This method is not threadsafe
+func (l *Log) rotate(suffix string) (err error) {
+	if err = l.sync(); err != nil {
+		return
+	}
+	ext := filepath.Ext(l.Path)
+	base := strings.TrimSuffix(l.Path, ext)
+	rotated := fmt.Sprintf("%s.%s%s", base, suffix, ext)
+	for i := 1; ; i++ {
+		if _, e := os.Stat(rotated); os.IsNotExist(e) {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%s-%d%s", base, suffix, i, ext)
+	}
+	if err = l.file.Close(); err != nil {
+		return
+	}
+	l.file = nil
+	if err = os.Rename(l.Path, rotated); err != nil {
+		//Keep appending to the current file
+		l.open()
+		return
+	}
+	return l.open()
+}
+
+//Sync writes the shares recorded since the last sync to stable storage
+func (l *Log) Sync() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.sync()
+}
+
+//sync fsyncs the file if shares were written to it
+// This method is not threadsafe
+func (l *Log) sync() (err error) {
+	if l.file == nil || !l.dirty {
+		return
+	}
+	if err = l.file.Sync(); err == nil {
+		l.dirty = false
+	}
+	return
+}
+
+//syncEvery syncs the file every interval until the log is closed
+func (l *Log) syncEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.Sync()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+//Close syncs and closes the file
+func (l *Log) Close() (err error) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return
+	}
+	if l.stop != nil {
+		close(l.stop)
+	}
+	if err = l.sync(); err != nil {
+		l.file.Close()
+	} else {
+		err = l.file.Close()
+	}
+	l.file = nil
+	return
+}
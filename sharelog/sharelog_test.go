@@ -0,0 +1,194 @@
+package sharelog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testShare returns a share found at t
+func testShare(t time.Time, response string) Share {
+	return Share{
+		Time:        t,
+		Device:      1,
+		Pool:        "stratum+tcp://pool:3333",
+		JobID:       "job1",
+		ExtraNonce2: "00000001",
+		NTime:       "6400000000000000",
+		Nonce:       "0100000000000000",
+		Header:      strings.Repeat("00", 80),
+		Hash:        strings.Repeat("00", 32),
+		Difficulty:  12.5,
+		Result:      "rejected",
+		Response:    response,
+		Latency:     40 * time.Millisecond,
+	}
+}
+
+//tempDir creates a temporary directory for a share log
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "sharelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestJSONL(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shares.jsonl")
+	l, err := Open(path, JSONL, 0, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := time.Date(2026, 10, 19, 12, 0, 0, 0, time.Local)
+	for _, response := range []string{"", "low difficulty share"} {
+		if err = l.Record(testShare(found, response)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Record(testShare(found, "")); err == nil {
+		t.Error("No error recording a share in a closed log")
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Unexpected share log:", string(content))
+	}
+	var s Share
+	if err = json.Unmarshal([]byte(lines[1]), &s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Time.Equal(found) || s.JobID != "job1" || s.ExtraNonce2 != "00000001" || s.Response != "low difficulty share" || s.Latency != 40*time.Millisecond || s.Difficulty != 12.5 {
+		t.Error("Unexpected share:", s)
+	}
+
+	//A nil log ignores the shares
+	var nilLog *Log
+	if nilLog.Record(s) != nil || nilLog.Sync() != nil || nilLog.Close() != nil {
+		t.Error("A nil log returned an error")
+	}
+}
+
+func TestCSV(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shares.csv")
+	found := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	//The header is only written once, not again when the file is opened again
+	for i := 0; i < 2; i++ {
+		l, err := Open(path, CSV, 0, false, time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = l.Record(testShare(found, `invalid "nonce", job1`)); err != nil {
+			t.Fatal(err)
+		}
+		l.Close()
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+		t.Fatal("Unexpected share log:", records)
+	}
+	expected := []string{"2026-10-19T12:00:00Z", "1", "stratum+tcp://pool:3333", "job1", "00000001", "6400000000000000", "0100000000000000",
+		strings.Repeat("00", 80), strings.Repeat("00", 32), "12.5", "rejected", `invalid "nonce", job1`, "40000000"}
+	if strings.Join(records[2], "|") != strings.Join(expected, "|") {
+		t.Error("Unexpected record:", records[2])
+	}
+}
+
+//rotatedFiles returns the names of the files in dir
+func rotatedFiles(t *testing.T, dir string) (names []string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	return
+}
+
+func TestRotation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shares.jsonl")
+	l, err := Open(path, JSONL, 0, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, 10, 18, 23, 59, 0, 0, time.Local)
+	l.Record(testShare(day, ""))
+	l.Record(testShare(day.Add(30*time.Second), ""))
+	l.Record(testShare(day.Add(2*time.Minute), ""))
+	l.Close()
+	if names := rotatedFiles(t, dir); strings.Join(names, ",") != "shares.2026-10-18.jsonl,shares.jsonl" {
+		t.Error("Unexpected files after the daily rotation:", names)
+	}
+
+	//A share that does not fit anymore starts a new file, the rotated files are never overwritten
+	dir2 := tempDir(t)
+	defer os.RemoveAll(dir2)
+	path = filepath.Join(dir2, "shares.jsonl")
+	line, _ := json.Marshal(testShare(day, ""))
+	if l, err = Open(path, JSONL, int64(len(line)+1)*2, false, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if err = l.Record(testShare(day, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+	expected := "shares.2026-10-18T235900-1.jsonl,shares.2026-10-18T235900.jsonl,shares.jsonl"
+	if names := rotatedFiles(t, dir2); strings.Join(names, ",") != expected {
+		t.Error("Unexpected files after the rotation by size:", names)
+	}
+	for _, name := range rotatedFiles(t, dir2) {
+		if content, _ := ioutil.ReadFile(filepath.Join(dir2, name)); strings.Count(string(content), "\n") != 2 {
+			t.Error(name, "does not contain 2 shares")
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, test := range []struct {
+		format, path string
+		expected     Format
+	}{
+		{"", "shares.jsonl", JSONL},
+		{"", "shares.CSV", CSV},
+		{"jsonl", "shares.csv", JSONL},
+		{"csv", "shares.log", CSV},
+	} {
+		if f, err := ParseFormat(test.format, test.path); err != nil || f != test.expected {
+			t.Error("Unexpected format for", test.format, test.path, f, err)
+		}
+	}
+	if _, err := ParseFormat("xml", "shares.xml"); err == nil {
+		t.Error("No error for an invalid format")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/robvanmieghem/go-opencl/cl"
+	"github.com/robvanmieghem/gominer/hwmon"
+	"github.com/robvanmieghem/gominer/mining"
+)
+
+//deviceCards returns the drm cards of the GPU's.
+// A GPU with a PCI bus id gets the card in that slot. The other GPU's are only matched by position if the number of
+// GPU's of a vendor on a platform is the number of cards of that vendor: OpenCL lists the GPU's of a vendor in the
+// order of their PCI addresses, so the n-th GPU is the n-th card. A card that OpenCL does not list, like the iGPU of
+// an APU, would shift every later GPU to the card of its neighbour.
+func deviceCards(cards []hwmon.Card, devices []*deviceInfo) (found map[*deviceInfo]hwmon.Card) {
+	found = make(map[*deviceInfo]hwmon.Card)
+	bySlot := make(map[string]hwmon.Card)
+	byVendor := make(map[string][]hwmon.Card)
+	for _, card := range cards {
+		bySlot[card.Slot] = card
+		byVendor[card.Vendor] = append(byVendor[card.Vendor], card)
+	}
+	type vendorGPUs struct {
+		platform int
+		vendor   string
+	}
+	gpus := make(map[vendorGPUs][]*deviceInfo)
+	var order []vendorGPUs
+	for _, d := range devices {
+		if d.Type&cl.DeviceTypeGPU == 0 {
+			continue
+		}
+		if card, ok := bySlot[d.PCIBusID]; ok && d.PCIBusID != "" {
+			found[d] = card
+		}
+		vendor := hwmon.VendorID(d.Vendor)
+		if vendor == "" {
+			continue
+		}
+		key := vendorGPUs{platform: d.Platform, vendor: vendor}
+		if _, ok := gpus[key]; !ok {
+			order = append(order, key)
+		}
+		gpus[key] = append(gpus[key], d)
+	}
+	for _, key := range order {
+		unmatched := 0
+		for _, d := range gpus[key] {
+			if _, ok := found[d]; !ok {
+				unmatched++
+			}
+		}
+		if unmatched == 0 {
+			continue
+		}
+		vendorCards := byVendor[key.vendor]
+		if len(gpus[key]) != len(vendorCards) {
+			mainLog.Error("Platform", key.platform, "has", len(gpus[key]), gpus[key][0].Vendor, "GPU's but there are", len(vendorCards),
+				"drm cards of that vendor - set the card of these devices with \"hwmon\" in the device settings")
+			continue
+		}
+		for n, d := range gpus[key] {
+			if _, ok := found[d]; !ok {
+				found[d] = vendorCards[n]
+			}
+		}
+	}
+	return
+}
+
+//hwmonSensors returns the hwmon directories of the selected devices by their Index.
+// The cards configured for the devices take precedence, they are a card name or a hwmon directory.
+func hwmonSensors(cards []hwmon.Card, devices []*deviceInfo, selected map[int]*deviceInfo, configured map[int]string) (sensors map[int]string) {
+	sensors = make(map[int]string)
+	byName := make(map[string]hwmon.Card)
+	for _, card := range cards {
+		byName[card.Name] = card
+	}
+	found := deviceCards(cards, devices)
+	for _, d := range devices {
+		if d.Index < 0 || selected[d.Index] != d {
+			continue
+		}
+		if name, ok := configured[d.Index]; ok {
+			if filepath.IsAbs(name) {
+				sensors[d.Index] = name
+			} else if byName[name].HWMon != "" {
+				sensors[d.Index] = byName[name].HWMon
+			}
+			continue
+		}
+		if card, ok := found[d]; ok && card.HWMon != "" {
+			sensors[d.Index] = card.HWMon
+		}
+	}
+	return
+}
+
+//startMonitor starts monitoring the sensors of the selected devices and protecting them against overheating
+func startMonitor(cfg ThermalConfig, devices []*deviceInfo, selected map[int]*deviceInfo, configured map[int]string, stats *mining.Stats, control hwmon.Controller) {
+	cards, err := hwmon.Cards(cfg.Root)
+	if err != nil {
+		mainLog.Error("Unable to list the GPU's to monitor -", err)
+		return
+	}
+	sensors := hwmonSensors(cards, devices, selected, configured)
+	indexes := make([]int, 0, len(selected))
+	for index := range selected {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		if dir, found := sensors[index]; found {
+			mainLog.Info("Monitoring device", index, "using", dir)
+		} else if cfg.Limit > 0 {
+			mainLog.Error("No sensors found for device", index, "- it is not protected against overheating")
+		} else {
+			mainLog.Warn("No sensors found for device", index)
+		}
+	}
+	//The action is checked when the configuration is validated
+	action, _ := hwmon.ParseAction(cfg.Action)
+	monitor := hwmon.NewMonitor(sensors, stats, control)
+	monitor.Interval = time.Duration(cfg.Interval)
+	monitor.Limit = cfg.Limit
+	monitor.Resume = cfg.Resume
+	monitor.Action = action
+	go monitor.Run(nil)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/robvanmieghem/gominer/hwmon"
+)
+
+func TestHWMonSensors(t *testing.T) {
+	cards := []hwmon.Card{
+		{Name: "card0", Slot: "0000:00:02.0", Vendor: hwmon.VendorIntel},
+		{Name: "card2", Slot: "0000:01:00.0", Vendor: hwmon.VendorAMD, HWMon: "/sys/class/drm/card2/device/hwmon/hwmon3"},
+		{Name: "card1", Slot: "0000:03:00.0", Vendor: hwmon.VendorAMD, HWMon: "/sys/class/drm/card1/device/hwmon/hwmon4"},
+		{Name: "card3", Slot: "0000:04:00.0", Vendor: hwmon.VendorNVIDIA},
+	}
+	selected, err := selectDevices(testDevices, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sensors := hwmonSensors(cards, testDevices, selected, nil)
+	if len(sensors) != 2 || sensors[0] != cards[1].HWMon || sensors[1] != cards[2].HWMon {
+		t.Error("Unexpected sensors:", sensors)
+	}
+
+	//The second AMD GPU keeps its card when the first one is not selected
+	if selected, err = selectDevices(testDevices, "1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if sensors = hwmonSensors(cards, testDevices, selected, nil); len(sensors) != 1 || sensors[1] != cards[2].HWMon {
+		t.Error("Unexpected sensors:", sensors)
+	}
+
+	//The configured cards take precedence
+	selected, _ = selectDevices(testDevices, "", "")
+	sensors = hwmonSensors(cards, testDevices, selected, map[int]string{0: "card1", 1: "card0", 3: "/tmp/hwmon"})
+	if len(sensors) != 2 || sensors[0] != cards[2].HWMon || sensors[3] != "/tmp/hwmon" {
+		t.Error("Unexpected sensors:", sensors)
+	}
+}
+
+func TestHWMonSensorsCardCountMismatch(t *testing.T) {
+	//The iGPU of an APU is a drm card but not an OpenCL device
+	cards := []hwmon.Card{
+		{Name: "card0", Slot: "0000:00:01.0", Vendor: hwmon.VendorAMD, HWMon: "/sys/class/drm/card0/device/hwmon/hwmon2"},
+		{Name: "card1", Slot: "0000:01:00.0", Vendor: hwmon.VendorAMD, HWMon: "/sys/class/drm/card1/device/hwmon/hwmon3"},
+		{Name: "card2", Slot: "0000:03:00.0", Vendor: hwmon.VendorAMD, HWMon: "/sys/class/drm/card2/device/hwmon/hwmon4"},
+	}
+	selected, err := selectDevices(testDevices, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sensors := hwmonSensors(cards, testDevices, selected, nil); len(sensors) != 0 {
+		t.Error("GPU's matched by position while the number of cards differs:", sensors)
+	}
+
+	//The configured cards are still used
+	sensors := hwmonSensors(cards, testDevices, selected, map[int]string{0: "card1", 1: "card2"})
+	if len(sensors) != 2 || sensors[0] != cards[1].HWMon || sensors[1] != cards[2].HWMon {
+		t.Error("Unexpected sensors:", sensors)
+	}
+
+	//The GPU's with a PCI bus id get the card in their slot
+	devices := make([]*deviceInfo, len(testDevices))
+	for i, d := range testDevices {
+		device := *d
+		devices[i] = &device
+	}
+	devices[1].PCIBusID = "0000:03:00.0"
+	selected, _ = selectDevices(devices, "", "")
+	if sensors = hwmonSensors(cards, devices, selected, nil); len(sensors) != 1 || sensors[1] != cards[2].HWMon {
+		t.Error("Unexpected sensors:", sensors)
+	}
+}